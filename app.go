@@ -0,0 +1,283 @@
+package engins
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/amsalt/engins/components"
+	"github.com/amsalt/engins/database"
+	"github.com/amsalt/engins/health"
+	"github.com/amsalt/nginet/message"
+)
+
+// App represents an isolated engins instance, which owns its components,
+// message register, processor manager, database clients and health checks.
+// Multiple Apps can run side by side in one process.
+type App struct {
+	components *components.Manager
+	register   message.Register
+	dispatcher message.ProcessorMgr
+
+	health *health.Aggregator
+
+	redis *database.RedisClient
+	mongo *database.MongoClient
+
+	messages messageRecords
+
+	mutex        sync.Mutex
+	signals      map[os.Signal]SignalHandler
+	sigChan      chan os.Signal // notified signals when the App is running.
+	shutdown     chan struct{}  // closed when shutdown requested.
+	shutdownOnce sync.Once
+}
+
+// Names of the health checks of the database clients of App.
+const (
+	RedisCheck = "redis"
+	MongoCheck = "mongo"
+)
+
+// defaultApp is the App used by the package level functions.
+var defaultApp = newApp(components.Default)
+
+// Default returns the default App.
+func Default() *App {
+	return defaultApp
+}
+
+// NewApp creates a new App.
+func NewApp() *App {
+	return newApp(components.NewManager())
+}
+
+func newApp(m *components.Manager) *App {
+	a := &App{components: m, signals: defaultSignalHandlers(), shutdown: make(chan struct{})}
+	a.register = message.NewRegister()
+	a.dispatcher = message.NewProcessorMgr(a.register)
+	if m == components.Default {
+		a.health = health.Default
+	} else {
+		a.health = health.NewAggregator(m)
+	}
+	return a
+}
+
+// Components returns the components manager of the App.
+func (a *App) Components() *components.Manager {
+	return a.components
+}
+
+// Health returns the health checks of the App, health.Default for the default App.
+func (a *App) Health() *health.Aggregator {
+	return a.health
+}
+
+// Register returns the message register of the App.
+func (a *App) Register() message.Register {
+	return a.register
+}
+
+// Dispatcher returns the processor manager of the App.
+func (a *App) Dispatcher() message.ProcessorMgr {
+	return a.dispatcher
+}
+
+// SetRedis sets the redis client of the App, and checks its health as RedisCheck.
+// For the default App, database.Redis is set too.
+func (a *App) SetRedis(r *database.RedisClient) {
+	a.redis = r
+	if r != nil {
+		a.health.Register(RedisCheck, r)
+	} else {
+		a.health.Unregister(RedisCheck)
+	}
+	if a == defaultApp {
+		database.Redis = r
+	}
+}
+
+// Redis returns the redis client of the App.
+func (a *App) Redis() *database.RedisClient {
+	if a == defaultApp {
+		return database.Redis
+	}
+	return a.redis
+}
+
+// SetMongo sets the mongo client of the App, and checks its health as MongoCheck.
+// For the default App, database.Mongo is set too.
+func (a *App) SetMongo(m *database.MongoClient) {
+	a.mongo = m
+	if m != nil {
+		a.health.Register(MongoCheck, m)
+	} else {
+		a.health.Unregister(MongoCheck)
+	}
+	if a == defaultApp {
+		database.Mongo = m
+	}
+}
+
+// Mongo returns the mongo client of the App.
+func (a *App) Mongo() *database.MongoClient {
+	if a == defaultApp {
+		return database.Mongo
+	}
+	return a.mongo
+}
+
+// Start registers the components and runs them in the order of their dependencies.
+// If any component fails to start, the started ones are rolled back and the error is returned.
+func (a *App) Start(component ...components.Component) error {
+	a.components.Register(component...)
+	if err := a.components.Run(); err != nil {
+		log.Errorf("engins start failed: %v", err)
+		return err
+	}
+	log.Infof("engins start success")
+	return nil
+}
+
+// Stop stops the components in reverse order of starting.
+func (a *App) Stop() error {
+	if err := a.components.Stop(); err != nil {
+		log.Errorf("engins stop failed: %v", err)
+		return err
+	}
+	return nil
+}
+
+// Shutdown requests the running App to stop.
+func (a *App) Shutdown() {
+	a.shutdownOnce.Do(func() {
+		close(a.shutdown)
+	})
+}
+
+// GracefulShutdown drains the components implementing components.Drainable
+// within timeout, then requests the running App to stop.
+// The draining failures are logged and returned, the App is stopped anyway.
+func (a *App) GracefulShutdown(timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	log.Infof("engins draining for shutdown in %v", timeout)
+	err := a.components.Drain(ctx)
+	if err != nil {
+		log.Errorf("engins drain failed: %v", err)
+	}
+	a.Shutdown()
+	return err
+}
+
+// ShuttingDown returns whether shutdown has been requested.
+func (a *App) ShuttingDown() bool {
+	select {
+	case <-a.shutdown:
+		return true
+	default:
+		return false
+	}
+}
+
+// Run dispatches the received signals to their handlers from starting the
+// components until they are stopped, and stops them in reverse order when
+// shutdown requested.
+// By default, SIGINT and SIGTERM shut down the App, a second SIGINT exits immediately,
+// SIGHUP reloads, SIGUSR1 dumps and SIGUSR2 drains, see HandleSignal for customizing,
+// e.g. GracefulShutdownHandler for SIGTERM to drain before shutting down.
+// See components.Named, components.Dependent and components.Require for declaring
+// dependencies, the order of arguments doesn't matter.
+// If a critical component can't be restarted by its components.RestartPolicy, all
+// components are stopped and the failure is returned.
+func (a *App) Run(component ...components.Component) error {
+	c := make(chan os.Signal, 1)
+	a.mutex.Lock()
+	a.sigChan = c
+	a.mutex.Unlock()
+	signal.Notify(c, a.handledSignals()...)
+
+	// keep dispatching signals during stopping, to force exit.
+	done := make(chan struct{})
+	go a.dispatchSignals(c, done)
+	defer func() {
+		a.mutex.Lock()
+		a.sigChan = nil
+		a.mutex.Unlock()
+		signal.Stop(c)
+		close(done)
+	}()
+
+	if err := a.Start(component...); err != nil {
+		return err
+	}
+
+	var fatal error
+	select {
+	case <-a.shutdown:
+		log.Infof("engins closing down")
+	case fatal = <-a.components.Fatal():
+		log.Errorf("engins closing down (fatal: %v)", fatal)
+	}
+
+	if err := a.Stop(); err != nil {
+		return err
+	}
+	return fatal
+}
+
+// GetMetaByID returns the meta of message with id.
+func (a *App) GetMetaByID(id interface{}) message.Meta {
+	return a.register.GetMetaByID(id)
+}
+
+// GetMetaByMsg returns the meta of message.
+func (a *App) GetMetaByMsg(msg interface{}) message.Meta {
+	return a.register.GetMetaByMsg(msg)
+}
+
+// RegisterMsg registers message.
+func (a *App) RegisterMsg(msg interface{}) (meta message.Meta) {
+	meta = a.register.RegisterMsg(msg)
+	a.messages.add(nil, msg, meta)
+	return meta
+}
+
+// RegisterMsgByID registers message with assigned id.
+func (a *App) RegisterMsgByID(assignID interface{}, msg interface{}) message.Meta {
+	meta := a.register.RegisterMsgByID(assignID, msg)
+	a.messages.add(assignID, msg, meta)
+	return meta
+}
+
+// RegisterProcessor registers processor of message.
+// Dispatched messages are recorded in metrics by the type of message.
+func (a *App) RegisterProcessor(msg interface{}, hf message.ProcessorFunc) error {
+	hf = instrument(messageTypeLabel(msg), hf)
+	err := a.dispatcher.RegisterProcessor(msg, hf)
+	if err == nil {
+		a.messages.setProcessor(func(r *messageRecord) bool { return r.typ == reflect.TypeOf(msg) }, hf)
+	}
+	return err
+}
+
+// RegisterProcessorByID registers processor of message with id.
+// Dispatched messages are recorded in metrics by id.
+func (a *App) RegisterProcessorByID(msgID interface{}, hf message.ProcessorFunc) error {
+	hf = instrument(messageLabel(msgID), hf)
+	err := a.dispatcher.RegisterProcessorByID(msgID, hf)
+	if err == nil {
+		a.messages.setProcessor(func(r *messageRecord) bool { return r.id == msgID }, hf)
+	}
+	return err
+}
+
+// GetProcessorByID returns the processor of message with id.
+func (a *App) GetProcessorByID(msgID interface{}) *message.Processor {
+	return a.dispatcher.GetProcessorByID(msgID)
+}
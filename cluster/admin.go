@@ -0,0 +1,368 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/amsalt/engins/monitor"
+	"github.com/amsalt/nginet/core"
+	"github.com/amsalt/nginet/encoding"
+	"github.com/amsalt/nginet/encoding/json"
+)
+
+// ExecTimeout is the default time to wait for each node to run a monitor command.
+var ExecTimeout = time.Second * 3
+
+// DefaultRemoteCommands are the monitor commands the connected nodes are allowed
+// to run on this node by default, which only show the status.
+var DefaultRemoteCommands = []string{"help", "components", "health", "messages", "metrics",
+	"goroutines", "memstats", "gc", "version", "uptime"}
+
+// remoteMonitorKey is the key of channel attribute to store the monitor commands
+// allowed to run by the node of channel.
+const remoteMonitorKey = "RemoteMonitor"
+
+// MonitorRequest is a protocol to ask a node to run a monitor command.
+type MonitorRequest struct {
+	ID      uint64
+	Fields  []string
+	Timeout int64 // in milliseconds.
+}
+
+// MonitorResponse is a protocol to reply the result of MonitorRequest.
+type MonitorResponse struct {
+	ID    uint64
+	Text  string
+	Data  interface{}
+	Error string
+}
+
+// NodeResult is the result of a monitor command run by a node.
+type NodeResult struct {
+	Service string      `json:"service"`
+	Node    string      `json:"node"` // address of the node.
+	Text    string      `json:"text,omitempty"`
+	Data    interface{} `json:"data,omitempty"`
+	Error   string      `json:"error,omitempty"`
+}
+
+// execs records the monitor commands waiting for responses.
+type execs struct {
+	seq     uint64
+	mutex   sync.Mutex
+	pending map[uint64]chan *MonitorResponse
+}
+
+// registerMonitor registers the protocols to run monitor commands on nodes,
+// and the `cluster` monitor command to the monitor.Registry of the App, working
+// with the first Cluster of the App initialized.
+func (c *Cluster) registerMonitor() {
+	c.app.RegisterMsgByID(SystemMonitorRequest, &MonitorRequest{}).SetCodec(encoding.MustGetCodec(json.CodecJSON))
+	c.app.RegisterProcessorByID(SystemMonitorRequest, c.monitorRequestHandler)
+	c.app.RegisterMsgByID(SystemMonitorResponse, &MonitorResponse{}).SetCodec(encoding.MustGetCodec(json.CodecJSON))
+	c.app.RegisterProcessorByID(SystemMonitorResponse, c.monitorResponseHandler)
+
+	if r := monitor.RegistryOf(c.app); !r.Registered(Name) {
+		r.RegisterCommand(monitor.NewCommand(Name, "show the connected nodes by service, or run monitor commands on them", c.topologyCommand,
+			monitor.WithGroup(Name),
+			monitor.WithUsage("cluster [service] | cluster <subcommand>"),
+			monitor.WithSubcommands(
+				monitor.NewCommand("exec", "run a command on the nodes of service, `*` for all services, results by node", c.execCommand(false),
+					monitor.WithUsage("cluster exec <service|*> <command> [args...]")),
+				monitor.NewCommand("merge", "run a command on the nodes of service, `*` for all services, results merged", c.execCommand(true),
+					monitor.WithUsage("cluster merge <service|*> <command> [args...]")),
+			)))
+	}
+}
+
+// Exec runs the monitor command in fields on every node of the service connected
+// with, all services if servName is `*`. Each node is waited for at most timeout.
+func (c *Cluster) Exec(ctx context.Context, servName string, fields []string, timeout time.Duration) []NodeResult {
+	if timeout <= 0 {
+		timeout = ExecTimeout
+	}
+
+	services := []string{servName}
+	if servName == "*" {
+		services = c.serviceNames()
+	}
+
+	var results []NodeResult
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	for _, service := range services {
+		for _, node := range c.nodes(service) {
+			wg.Add(1)
+			go func(service string, node core.SubChannel) {
+				defer wg.Done()
+				r := c.execNode(ctx, node, fields, timeout)
+				r.Service = service
+
+				mutex.Lock()
+				results = append(results, r)
+				mutex.Unlock()
+			}(service, node)
+		}
+	}
+	wg.Wait()
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Service != results[j].Service {
+			return results[i].Service < results[j].Service
+		}
+		return results[i].Node < results[j].Node
+	})
+	return results
+}
+
+func (c *Cluster) execNode(ctx context.Context, node core.SubChannel, fields []string, timeout time.Duration) NodeResult {
+	result := NodeResult{Node: fmt.Sprintf("%v", node.RemoteAddr())}
+
+	id := atomic.AddUint64(&c.execs.seq, 1)
+	reply := make(chan *MonitorResponse, 1)
+	c.execs.mutex.Lock()
+	c.execs.pending[id] = reply
+	c.execs.mutex.Unlock()
+	defer func() {
+		c.execs.mutex.Lock()
+		delete(c.execs.pending, id)
+		c.execs.mutex.Unlock()
+	}()
+
+	req := &MonitorRequest{ID: id, Fields: fields, Timeout: int64(timeout / time.Millisecond)}
+	if err := node.Write(req); err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case resp := <-reply:
+		result.Text, result.Data, result.Error = resp.Text, resp.Data, resp.Error
+	case <-timer.C:
+		result.Error = "timeout"
+	case <-ctx.Done():
+		result.Error = ctx.Err().Error()
+	}
+	return result
+}
+
+// nodes returns the connected nodes of service, both as client and server.
+func (c *Cluster) nodes(servName string) []core.SubChannel {
+	seen := make(map[core.SubChannel]bool)
+	var nodes []core.SubChannel
+	for _, node := range append(c.Clients(servName), c.resolver.Resolve(servName)...) {
+		if node != nil && !seen[node] {
+			seen[node] = true
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes
+}
+
+// serviceNames returns the names of services connected as client or identified by server.
+func (c *Cluster) serviceNames() []string {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	names := make([]string, 0, len(c.services)+len(c.storages))
+	for name := range c.services {
+		names = append(names, name)
+	}
+	for name := range c.storages {
+		if !c.services[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (c *Cluster) monitorRequestHandler(ctx *core.ChannelContext, msg interface{}, args ...interface{}) {
+	req, ok := msg.(*MonitorRequest)
+	if !ok {
+		return
+	}
+
+	go func() {
+		resp := &MonitorResponse{ID: req.ID}
+		if len(req.Fields) > 0 && req.Fields[0] == Name {
+			resp.Error = "can't run cluster command on nodes"
+			ctx.Write(resp)
+			return
+		}
+		registry, line := monitor.RegistryOf(c.app), strings.Join(req.Fields, " ")
+		allowed := c.remoteCommands(ctx)
+		if allowed == nil {
+			log.Errorf("cluster rejected monitor command %q from %v not authenticated", line, ctx.Channel().RemoteAddr())
+			resp.Error = "monitor commands not allowed from unauthenticated nodes"
+			ctx.Write(resp)
+			return
+		}
+		if !registry.Allowed(allowed, req.Fields) {
+			err := &monitor.PermissionDenied{User: fmt.Sprintf("%v", ctx.Channel().RemoteAddr()), Command: line}
+			log.Errorf("cluster rejected monitor command: %v", err)
+			resp.Error = err.Error()
+			ctx.Write(resp)
+			return
+		}
+
+		timeout := time.Duration(req.Timeout) * time.Millisecond
+		if timeout <= 0 {
+			timeout = ExecTimeout
+		}
+		runCtx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+
+		log.Infof("cluster run monitor command %q for %v", line, ctx.Channel().RemoteAddr())
+		result, err := registry.ExecuteFields(runCtx, req.Fields)
+		if err != nil {
+			resp.Error = err.Error()
+		} else {
+			resp.Text, resp.Data = result.String(), result.Data
+		}
+		ctx.Write(resp)
+	}()
+}
+
+// remoteCommands returns the monitor commands allowed to run by the node of ctx,
+// the ones of WithRemoteMonitor, or DefaultRemoteCommands if the node is
+// authenticated by the server. Nil if not allowed to run any.
+func (c *Cluster) remoteCommands(ctx *core.ChannelContext) []string {
+	if allowed, ok := ctx.Attr().Value(remoteMonitorKey).([]string); ok {
+		return allowed
+	}
+	if h, ok := ctx.Attr().Value(handshakeKey).(*handshake); ok && h.done() {
+		return DefaultRemoteCommands
+	}
+	return nil
+}
+
+func (c *Cluster) monitorResponseHandler(ctx *core.ChannelContext, msg interface{}, args ...interface{}) {
+	resp, ok := msg.(*MonitorResponse)
+	if !ok {
+		return
+	}
+
+	c.execs.mutex.Lock()
+	reply := c.execs.pending[resp.ID]
+	c.execs.mutex.Unlock()
+	if reply != nil {
+		select {
+		case reply <- resp:
+		default:
+		}
+	}
+}
+
+func (c *Cluster) execCommand(merge bool) monitor.Action {
+	return func(ctx context.Context, args *monitor.Args) (*monitor.Result, error) {
+		if len(args.Positional) < 2 {
+			return nil, fmt.Errorf("service and command are required")
+		}
+		fields := args.Rest(1)
+		if monitor.RegistryFrom(ctx).GetCommand(fields[0]) == nil {
+			return nil, &monitor.UnknownCommand{Name: fields[0]}
+		}
+		if err := monitor.Authorize(ctx, fields); err != nil {
+			return nil, err
+		}
+
+		results := c.Exec(ctx, args.Arg(0), fields, ExecTimeout)
+		var text strings.Builder
+		for _, r := range results {
+			output := r.Text
+			if r.Error != "" {
+				output = "error: " + r.Error
+			}
+			if merge {
+				for _, line := range strings.Split(strings.TrimRight(output, "\n"), "\n") {
+					fmt.Fprintf(&text, "%s@%s | %s\n", r.Service, r.Node, line)
+				}
+				continue
+			}
+			fmt.Fprintf(&text, "== %s@%s ==\n%s\n\n", r.Service, r.Node, strings.TrimRight(output, "\n"))
+		}
+		if len(results) == 0 {
+			text.WriteString("no connected nodes\n")
+		}
+		return monitor.NewResult(results, text.String()), nil
+	}
+}
+
+func (c *Cluster) topologyCommand(ctx context.Context, args *monitor.Args) (*monitor.Result, error) {
+	topology := c.Topology()
+	if service := args.Arg(0); service != "" {
+		topology = map[string][]Member{service: topology[service]}
+	}
+
+	services := make([]string, 0, len(topology))
+	for service := range topology {
+		services = append(services, service)
+	}
+	sort.Strings(services)
+
+	var text strings.Builder
+	if c.Draining() {
+		text.WriteString("draining")
+		if d := c.DrainDeadline(); !d.IsZero() {
+			fmt.Fprintf(&text, " until %s", d.Format("2006-01-02 15:04:05"))
+		}
+		fmt.Fprintf(&text, ", %d sessions\n", c.sessions())
+	}
+	for _, service := range services {
+		members := topology[service]
+		fmt.Fprintf(&text, "%s (%d)\n", service, len(members))
+		for _, m := range members {
+			fmt.Fprintf(&text, "    %-22s %-9s since %s (%v)", m.Addr, m.Direction,
+				m.Since.Format("2006-01-02 15:04:05"), time.Since(m.Since).Round(time.Second))
+			if m.ID != "" {
+				fmt.Fprintf(&text, " id=%s version=%s build=%s weight=%d", m.ID, m.Version, m.Build, m.Weight)
+			}
+			if m.Zone != "" || m.Region != "" {
+				fmt.Fprintf(&text, " zone=%s/%s", m.Region, m.Zone)
+			}
+			if m.Draining {
+				text.WriteString(" draining")
+			}
+			if len(m.Labels) > 0 {
+				fmt.Fprintf(&text, " %s", formatLabels(m.Labels))
+			}
+			text.WriteString("\n")
+		}
+	}
+	if len(services) == 0 {
+		text.WriteString("no connected nodes\n")
+	}
+
+	reconnects := c.Reconnects()
+	for i, r := range reconnects {
+		if i == 0 {
+			text.WriteString("reconnecting\n")
+		}
+		fmt.Fprintf(&text, "    %s@%s attempt %d, next in %v", r.Service, r.Addr, r.Attempt,
+			time.Until(r.Next).Round(time.Millisecond))
+		if r.Err != "" {
+			fmt.Fprintf(&text, ", last error: %s", r.Err)
+		}
+		text.WriteString("\n")
+	}
+	data := map[string]interface{}{"topology": topology, "reconnecting": reconnects}
+	return monitor.NewResult(data, text.String()), nil
+}
+
+// formatLabels formats the labels as `k1=v1,k2=v2` sorted by key.
+func formatLabels(labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+	for k, v := range labels {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
@@ -0,0 +1,287 @@
+package cluster
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/amsalt/engins/errs"
+	"github.com/amsalt/engins/monitor/events"
+	"github.com/amsalt/engins/monitor/metrics"
+	"github.com/amsalt/ngicluster/consts"
+	"github.com/amsalt/nginet/core"
+	"github.com/amsalt/nginet/encoding"
+	"github.com/amsalt/nginet/encoding/json"
+)
+
+// HandshakeTimeout is the time for a node connected to an authenticating server
+// to prove its identity, and for a client with Authenticator to be identified
+// back by the server, the connection is closed after it.
+var HandshakeTimeout = time.Second * 5
+
+// keys of channel attribute for the handshake.
+const (
+	handshakeKey = "Handshake" // the state of server.
+	proveKey     = "Prove"     // the function proving identity of client.
+	readyKey     = "Ready"     // the function called by client when server identified.
+)
+
+var authFailures = metrics.NewCounter("engins_cluster_auth_failures_total",
+	"Total connections rejected by the authenticating servers.", "server")
+
+// Challenge is a protocol sent by an authenticating server on connected, the
+// client identifies itself with the proof of the nonce.
+type Challenge struct {
+	Nonce string
+}
+
+// Authenticator proves the identity of clients and verifies it on servers.
+type Authenticator interface {
+	// Prove returns the proof of identify for the nonce of server.
+	Prove(identify *IdentifySelf, nonce string) (string, error)
+	// Verify verifies the proof of identify for nonce.
+	Verify(identify *IdentifySelf, nonce string, proof string) error
+}
+
+// HMACAuth proves the identity with HMAC-SHA256 over the nonce by a shared secret.
+type HMACAuth struct {
+	secret []byte
+}
+
+// NewHMACAuth creates a HMACAuth with the shared secret, such as loaded from config.
+func NewHMACAuth(secret string) *HMACAuth {
+	return &HMACAuth{secret: []byte(secret)}
+}
+
+// Prove implements Authenticator.
+func (a *HMACAuth) Prove(identify *IdentifySelf, nonce string) (string, error) {
+	if len(a.secret) == 0 {
+		return "", fmt.Errorf("empty secret")
+	}
+	mac := hmac.New(sha256.New, a.secret)
+	fmt.Fprintf(mac, "%s|%s|%s", nonce, identify.Name, identify.ID)
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// Verify implements Authenticator.
+func (a *HMACAuth) Verify(identify *IdentifySelf, nonce string, proof string) error {
+	expected, err := a.Prove(identify, nonce)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal([]byte(expected), []byte(proof)) {
+		return fmt.Errorf("bad proof")
+	}
+	return nil
+}
+
+// TokenAuth proves the identity with a token, such as signed by an issuer.
+type TokenAuth struct {
+	token  string
+	verify func(identify *IdentifySelf, token string) error
+}
+
+// NewTokenAuth creates a TokenAuth sending token by clients, and verifying the
+// tokens received by verify on servers. A nil verify accepts only the same token.
+func NewTokenAuth(token string, verify func(identify *IdentifySelf, token string) error) *TokenAuth {
+	return &TokenAuth{token: token, verify: verify}
+}
+
+// Prove implements Authenticator.
+func (a *TokenAuth) Prove(identify *IdentifySelf, nonce string) (string, error) {
+	if a.token == "" {
+		return "", fmt.Errorf("empty token")
+	}
+	return a.token, nil
+}
+
+// Verify implements Authenticator.
+func (a *TokenAuth) Verify(identify *IdentifySelf, nonce string, proof string) error {
+	if a.verify != nil {
+		return a.verify(identify, proof)
+	}
+	if a.token == "" || subtle.ConstantTimeCompare([]byte(a.token), []byte(proof)) != 1 {
+		return fmt.Errorf("bad token")
+	}
+	return nil
+}
+
+// handshake is the authenticating state of a connection.
+type handshake struct {
+	nonce         string
+	authenticated int32
+	timer         *time.Timer
+
+	// the identity authenticated, kept by the identities sent again.
+	name string
+	id   string
+}
+
+func (h *handshake) done() bool {
+	return atomic.LoadInt32(&h.authenticated) == 1
+}
+
+func (c *Cluster) registerAuth() {
+	c.app.RegisterMsgByID(SystemChallenge, &Challenge{}).SetCodec(encoding.MustGetCodec(json.CodecJSON))
+	c.app.RegisterProcessorByID(SystemChallenge, c.challengeHandler)
+}
+
+// challenge sends the challenge to the client connected to server, and closes
+// the connection if it's not authenticated in HandshakeTimeout.
+func (c *Cluster) challenge(servName string, ctx *core.ChannelContext, channel core.Channel) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		log.Errorf("server %s generate nonce failed: %v", servName, err)
+		channel.Close()
+		return
+	}
+
+	h := &handshake{nonce: hex.EncodeToString(nonce)}
+	h.timer = time.AfterFunc(HandshakeTimeout, func() {
+		if !h.done() {
+			c.rejectAuth(servName, channel, fmt.Sprintf("%v", channel.RemoteAddr()), "handshake timeout")
+		}
+	})
+	ctx.Attr().SetValue(handshakeKey, h)
+	ctx.Write(&Challenge{Nonce: h.nonce})
+}
+
+// authenticate verifies identify received by server, the connection is closed on failure.
+func (c *Cluster) authenticate(servName string, auth Authenticator, ctx *core.ChannelContext, identify *IdentifySelf) bool {
+	addr := fmt.Sprintf("%s@%v", identify.Name, ctx.Channel().RemoteAddr())
+	h, ok := ctx.Attr().Value(handshakeKey).(*handshake)
+	if !ok {
+		c.rejectAuth(servName, ctx.Channel(), addr, "no challenge sent")
+		return false
+	}
+	if h.done() {
+		// identified again, such as to update labels, as the one authenticated.
+		if identify.Name != h.name || identify.ID != h.id {
+			c.rejectAuth(servName, ctx.Channel(), addr, fmt.Sprintf("identified again as %s(%s) other than %s(%s)",
+				identify.Name, identify.ID, h.name, h.id))
+			return false
+		}
+		return true
+	}
+	if identify.Nonce != h.nonce {
+		c.rejectAuth(servName, ctx.Channel(), addr, "nonce mismatch")
+		return false
+	}
+	if err := auth.Verify(identify, identify.Nonce, identify.Proof); err != nil {
+		c.rejectAuth(servName, ctx.Channel(), addr, err.Error())
+		return false
+	}
+
+	h.timer.Stop()
+	h.name, h.id = identify.Name, identify.ID
+	atomic.StoreInt32(&h.authenticated, 1)
+	log.Infof("server %s authenticated %s", servName, addr)
+	return true
+}
+
+// proveSelf makes the client identify self with the proof when challenged,
+// and delays onConnect until the server identifies back. The connection is
+// closed if it's not identified back in HandshakeTimeout, such as connected to
+// a server not authenticating, which never challenges.
+func (c *Cluster) proveSelf(name string, opts *ConfigOpts, ctx *core.ChannelContext, onConnect func()) {
+	h := &handshake{}
+	h.timer = time.AfterFunc(HandshakeTimeout, func() {
+		if atomic.CompareAndSwapInt32(&h.authenticated, 0, 1) {
+			log.Errorf("client %s handshake with %v timeout, the server may not authenticate", name, ctx.Channel().RemoteAddr())
+			events.Publish(Name, "client %s handshake with %v timeout", name, ctx.Channel().RemoteAddr())
+			ctx.Close()
+		}
+	})
+	ctx.Attr().SetValue(proveKey, func(nonce string) (*IdentifySelf, error) {
+		self := *c.identity(name, c.advertiseAddr(), opts)
+		self.Nonce = nonce
+		proof, err := opts.Auth.Prove(&self, nonce)
+		if err != nil {
+			return nil, err
+		}
+		self.Proof = proof
+		return &self, nil
+	})
+	ctx.Attr().SetValue(readyKey, func() {
+		if atomic.CompareAndSwapInt32(&h.authenticated, 0, 1) {
+			h.timer.Stop()
+			onConnect()
+		}
+	})
+}
+
+func (c *Cluster) rejectAuth(servName string, channel core.Channel, node string, reason string) {
+	err := errs.NewUnauthenticated(node, reason)
+	log.Errorf("server %s rejected connection: %v", servName, err)
+	events.Publish(Name, "server %s rejected connection: %v", servName, err)
+	authFailures.With(servName).Inc()
+	channel.Close()
+}
+
+// challengeHandler identifies self with the proof of challenge by client.
+func (c *Cluster) challengeHandler(ctx *core.ChannelContext, msg interface{}, args ...interface{}) {
+	challenge, ok := msg.(*Challenge)
+	if !ok {
+		return
+	}
+
+	prove, ok := ctx.Attr().Value(proveKey).(func(nonce string) (*IdentifySelf, error))
+	if !ok {
+		log.Errorf("cluster challenged by %v without authenticator", ctx.Channel().RemoteAddr())
+		ctx.Close()
+		return
+	}
+	self, err := prove(challenge.Nonce)
+	if err != nil {
+		log.Errorf("cluster prove identity to %v failed: %v", ctx.Channel().RemoteAddr(), err)
+		ctx.Close()
+		return
+	}
+	ctx.Write(self)
+}
+
+// handshakeMessages are the ids of messages accepted before authenticated.
+var handshakeMessages = map[string]bool{
+	fmt.Sprintf("%v", consts.SystemIdentifySelf): true,
+	fmt.Sprintf("%v", SystemPing):                true,
+	fmt.Sprintf("%v", SystemPong):                true,
+}
+
+// authGate closes the connections sending messages other than IdentifySelf and
+// heartbeats before authenticated, so that no message is dispatched or relayed for them.
+type authGate struct {
+	*core.DefaultInboundHandler
+	c        *Cluster
+	servName string
+}
+
+func newAuthGate(c *Cluster, servName string) *authGate {
+	return &authGate{DefaultInboundHandler: core.NewDefaultInboundHandler(), c: c, servName: servName}
+}
+
+func (g *authGate) OnRead(ctx *core.ChannelContext, msg interface{}) {
+	if h, ok := ctx.Attr().Value(handshakeKey).(*handshake); ok && !h.done() {
+		if id, ok := g.c.messageID(msg); !ok || !handshakeMessages[fmt.Sprintf("%v", id)] {
+			g.c.rejectAuth(g.servName, ctx.Channel(), fmt.Sprintf("%v", ctx.Channel().RemoteAddr()),
+				fmt.Sprintf("message %v before authenticated", id))
+			return
+		}
+	}
+	g.DefaultInboundHandler.OnRead(ctx, msg)
+}
+
+// messageID returns the id of message parsed by IDParser or decoded.
+func (c *Cluster) messageID(msg interface{}) (interface{}, bool) {
+	if m, ok := msg.(interface{ ID() interface{} }); ok {
+		return m.ID(), true
+	}
+	if meta := c.app.GetMetaByMsg(msg); meta != nil {
+		return meta.ID(), true
+	}
+	return nil, false
+}
@@ -0,0 +1,319 @@
+package cluster
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/amsalt/engins/errs"
+	"github.com/amsalt/ngicluster/balancer"
+	"github.com/amsalt/ngicluster/resolver"
+	"github.com/amsalt/nginet/core"
+)
+
+// Names of the balancers registered by cluster, to be used with balancer.GetBuilder
+// or WithServerBalancer.
+const (
+	ConsistentHashName     = "consistent_hash"
+	WeightedRoundRobinName = "weighted_round_robin"
+	LeastLoadName          = "least_load"
+)
+
+// DefaultHashReplicas is the number of virtual nodes of a node with
+// DefaultWeight on the hash ring.
+const DefaultHashReplicas = 160
+
+// loadKey is the key of channel attribute to store the load reported by the node.
+const loadKey = "Load"
+
+func init() {
+	balancer.Register(&balancerBuilder{name: ConsistentHashName, build: newConsistentHash})
+	balancer.Register(&balancerBuilder{name: WeightedRoundRobinName, build: newWeightedRoundRobin})
+	balancer.Register(&balancerBuilder{name: LeastLoadName, build: newLeastLoad})
+}
+
+// balancerOpts represents the options of the balancers of cluster.
+type balancerOpts struct {
+	servName string
+	resolver resolver.Resolver
+	storage  balancer.Storage
+	replicas int
+}
+
+// WithBalancerService sets the service whose nodes the balancer picks.
+func WithBalancerService(servName string) balancer.BuildOption {
+	return func(o interface{}) {
+		o.(*balancerOpts).servName = servName
+	}
+}
+
+// WithBalancerResolver sets the resolver resolving the nodes of service.
+func WithBalancerResolver(r resolver.Resolver) balancer.BuildOption {
+	return func(o interface{}) {
+		o.(*balancerOpts).resolver = r
+	}
+}
+
+// WithBalancerStorage sets the storage of the nodes picked by stickiness key,
+// which makes the weighted round-robin and least-load balancers sticky.
+func WithBalancerStorage(s balancer.Storage) balancer.BuildOption {
+	return func(o interface{}) {
+		o.(*balancerOpts).storage = s
+	}
+}
+
+// WithHashReplicas sets the number of virtual nodes of a node with DefaultWeight
+// on the hash ring, DefaultHashReplicas by default.
+func WithHashReplicas(n int) balancer.BuildOption {
+	return func(o interface{}) {
+		o.(*balancerOpts).replicas = n
+	}
+}
+
+type balancerBuilder struct {
+	name  string
+	build func(opts *balancerOpts) balancer.Balancer
+}
+
+func (b *balancerBuilder) Name() string {
+	return b.name
+}
+
+func (b *balancerBuilder) Build(opts ...balancer.BuildOption) balancer.Balancer {
+	o := &balancerOpts{replicas: DefaultHashReplicas}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.resolver == nil {
+		panic(fmt.Errorf("balancer %s of %s requires a resolver", b.name, o.servName))
+	}
+	return b.build(o)
+}
+
+// nodeKey returns the key identifying the node of channel on balancing, the
+// ID of node which is stable across its reconnections, or the address of node
+// if not identified with ID.
+func nodeKey(channel core.Channel) string {
+	if node, ok := channel.Attr().Value(NodeKey).(*Node); ok {
+		if node.ID != "" {
+			return node.ID
+		}
+		if node.Addr != "" {
+			return node.Addr
+		}
+	}
+	return fmt.Sprintf("%v", channel.RemoteAddr())
+}
+
+// nodeWeight returns the capacity weight of the node of channel.
+func nodeWeight(channel core.Channel) int {
+	if node, ok := channel.Attr().Value(NodeKey).(*Node); ok && node.Weight > 0 {
+		return node.Weight
+	}
+	return DefaultWeight
+}
+
+// nodeLoad returns the load reported by the node of channel, and whether reported.
+func nodeLoad(channel core.Channel) (int64, bool) {
+	if load, ok := channel.Attr().Value(loadKey).(*int64); ok {
+		return atomic.LoadInt64(load), true
+	}
+	return 0, false
+}
+
+// storeLoad stores the load reported by the node of ctx.
+func storeLoad(ctx *core.ChannelContext, load int64) {
+	if l, ok := ctx.Attr().Value(loadKey).(*int64); ok {
+		atomic.StoreInt64(l, load)
+		return
+	}
+	ctx.Attr().SetValue(loadKey, &load)
+}
+
+// sticky picks the node stored for the stickiness key if it's still resolved,
+// even if draining, otherwise picks one by pick and stores it.
+func sticky(o *balancerOpts, key interface{}, pick func() core.SubChannel) core.SubChannel {
+	if key == nil || o.storage == nil {
+		return pick()
+	}
+	if k, ok := o.storage.Get(key).(string); ok {
+		for _, node := range resolveAll(o.resolver, o.servName) {
+			if nodeKey(node) == k {
+				return node
+			}
+		}
+	}
+	node := pick()
+	o.storage.Set(key, nodeKey(node))
+	return node
+}
+
+// consistentHash picks nodes on a hash ring of the stickiness keys with
+// virtual nodes proportional to weights, so only the keys of the node joined
+// or left are reassigned. The draining nodes are kept on the ring, as the keys
+// hashed to them can't be told from the new ones.
+type consistentHash struct {
+	opts *balancerOpts
+	next uint64 // round-robin counter of messages without stickiness key.
+
+	mutex  sync.Mutex
+	sign   string
+	hashes []uint32
+	ring   map[uint32]string // node keys by hash of virtual node.
+}
+
+func newConsistentHash(opts *balancerOpts) balancer.Balancer {
+	return &consistentHash{opts: opts}
+}
+
+func hashOf(s string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(s))
+	return h.Sum32()
+}
+
+func (b *consistentHash) Pick(ctx interface{}) (core.SubChannel, error) {
+	nodes := b.opts.resolver.Resolve(b.opts.servName)
+	if len(nodes) == 0 {
+		return nil, errs.NewNoRoute(b.opts.servName)
+	}
+	if ctx == nil {
+		return nodes[atomic.AddUint64(&b.next, 1)%uint64(len(nodes))], nil
+	}
+
+	nodes = resolveAll(b.opts.resolver, b.opts.servName)
+	byKey := make(map[string]core.SubChannel, len(nodes))
+	for _, node := range nodes {
+		byKey[nodeKey(node)] = node
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.build(nodes)
+	h := hashOf(fmt.Sprintf("%v", ctx))
+	i := sort.Search(len(b.hashes), func(i int) bool { return b.hashes[i] >= h })
+	if i == len(b.hashes) {
+		i = 0
+	}
+	return byKey[b.ring[b.hashes[i]]], nil
+}
+
+// build rebuilds the ring if the nodes or their weights changed.
+func (b *consistentHash) build(nodes []core.SubChannel) {
+	keys := make([]string, len(nodes))
+	for i, node := range nodes {
+		keys[i] = nodeKey(node) + "*" + strconv.Itoa(nodeWeight(node))
+	}
+	sort.Strings(keys)
+	sign := strings.Join(keys, ",")
+	if sign == b.sign {
+		return
+	}
+
+	b.sign = sign
+	b.hashes = b.hashes[:0]
+	b.ring = make(map[uint32]string)
+	for _, node := range nodes {
+		key := nodeKey(node)
+		replicas := b.opts.replicas * nodeWeight(node) / DefaultWeight
+		if replicas < 1 {
+			replicas = 1
+		}
+		for i := 0; i < replicas; i++ {
+			h := hashOf(key + "#" + strconv.Itoa(i))
+			if _, ok := b.ring[h]; !ok {
+				b.hashes = append(b.hashes, h)
+			}
+			b.ring[h] = key
+		}
+	}
+	sort.Slice(b.hashes, func(i, j int) bool { return b.hashes[i] < b.hashes[j] })
+}
+
+// weightedRoundRobin picks nodes in turn proportional to their weights, in the
+// smooth way spreading the picks of a node.
+type weightedRoundRobin struct {
+	opts *balancerOpts
+
+	mutex   sync.Mutex
+	current map[string]int // current weights by node key.
+}
+
+func newWeightedRoundRobin(opts *balancerOpts) balancer.Balancer {
+	return &weightedRoundRobin{opts: opts, current: make(map[string]int)}
+}
+
+func (b *weightedRoundRobin) Pick(ctx interface{}) (core.SubChannel, error) {
+	nodes := b.opts.resolver.Resolve(b.opts.servName)
+	if len(nodes) == 0 {
+		return nil, errs.NewNoRoute(b.opts.servName)
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return sticky(b.opts, ctx, func() core.SubChannel {
+		var best core.SubChannel
+		var bestKey string
+		total := 0
+		current := make(map[string]int, len(nodes))
+		for _, node := range nodes {
+			key, weight := nodeKey(node), nodeWeight(node)
+			total += weight
+			current[key] = b.current[key] + weight
+			if best == nil || current[key] > current[bestKey] {
+				best, bestKey = node, key
+			}
+		}
+		current[bestKey] -= total
+		b.current = current // the nodes gone are forgotten.
+		return best
+	}), nil
+}
+
+// leastLoad picks the node with the least load per weight. The load is the
+// one reported by heartbeats, or the number of picks of the node by this
+// balancer if not reported.
+type leastLoad struct {
+	opts *balancerOpts
+
+	mutex sync.Mutex
+	picks map[string]int64 // number of picks by node key.
+}
+
+func newLeastLoad(opts *balancerOpts) balancer.Balancer {
+	return &leastLoad{opts: opts, picks: make(map[string]int64)}
+}
+
+func (b *leastLoad) Pick(ctx interface{}) (core.SubChannel, error) {
+	nodes := b.opts.resolver.Resolve(b.opts.servName)
+	if len(nodes) == 0 {
+		return nil, errs.NewNoRoute(b.opts.servName)
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return sticky(b.opts, ctx, func() core.SubChannel {
+		var best core.SubChannel
+		var bestLoad, bestWeight int64
+		for _, node := range nodes {
+			load, ok := nodeLoad(node)
+			if !ok {
+				load = b.picks[nodeKey(node)]
+			}
+			weight := int64(nodeWeight(node))
+			// compares load/weight without division.
+			if best == nil || load*bestWeight < bestLoad*weight {
+				best, bestLoad, bestWeight = node, load, weight
+			}
+		}
+		b.picks[nodeKey(best)]++
+		return best
+	}), nil
+}
@@ -0,0 +1,95 @@
+package cluster
+
+import (
+	"fmt"
+
+	"github.com/amsalt/engins/errs"
+	"github.com/amsalt/nginet/core"
+)
+
+// NodeKey is the key of channel attribute to store the *Node identified.
+const NodeKey = "Node"
+
+// Node describes a connected node of a service.
+type Node struct {
+	Service  string            `json:"service"`
+	Addr     string            `json:"addr"`
+	ID       string            `json:"id,omitempty"`
+	Version  string            `json:"version,omitempty"`
+	Build    string            `json:"build,omitempty"`
+	Zone     string            `json:"zone,omitempty"`
+	Region   string            `json:"region,omitempty"`
+	Weight   int               `json:"weight,omitempty"`
+	Draining bool              `json:"draining,omitempty"`
+	Labels   map[string]string `json:"labels,omitempty"`
+}
+
+// NodeFilter selects the nodes to send message to.
+type NodeFilter func(node *Node) bool
+
+// MatchLabels selects the nodes having all the labels.
+func MatchLabels(labels map[string]string) NodeFilter {
+	return func(node *Node) bool {
+		for k, v := range labels {
+			if node.Labels[k] != v {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// Broadcast sends message to every connected node of service `servName`.
+// It returns the number of nodes sent to and the first error.
+func (c *Cluster) Broadcast(servName string, msg interface{}) (int, error) {
+	return c.BroadcastFilter([]string{servName}, msg, nil)
+}
+
+// Multicast sends message to every connected node of the services.
+// It returns the number of nodes sent to and the first error.
+func (c *Cluster) Multicast(servNames []string, msg interface{}) (int, error) {
+	return c.BroadcastFilter(servNames, msg, nil)
+}
+
+// BroadcastFilter sends message to the connected nodes of the services selected
+// by filter, nil filter selects all. It returns the number of nodes sent to and
+// the first error, *errs.NoRoute if a service has no connected node.
+func (c *Cluster) BroadcastFilter(servNames []string, msg interface{}, filter NodeFilter) (int, error) {
+	var sent int
+	var firstErr error
+	for _, servName := range servNames {
+		nodes := c.nodes(servName)
+		if len(nodes) == 0 {
+			log.Errorf("no connected node to broadcast message for %s", servName)
+			recordWrite(servName, viaNone, errs.NewNoRoute(servName))
+			if firstErr == nil {
+				firstErr = errs.NewNoRoute(servName)
+			}
+			continue
+		}
+
+		for _, ch := range nodes {
+			if filter != nil && !filter(c.nodeOf(servName, ch)) {
+				continue
+			}
+			err := ch.Write(msg)
+			recordWrite(servName, viaBroadcast, err)
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				continue
+			}
+			sent++
+		}
+	}
+	return sent, firstErr
+}
+
+// nodeOf returns the node identified on the channel, or the one described by address.
+func (c *Cluster) nodeOf(servName string, ch core.SubChannel) *Node {
+	if node, ok := ch.Attr().Value(NodeKey).(*Node); ok {
+		return node
+	}
+	return &Node{Service: servName, Addr: fmt.Sprintf("%v", ch.RemoteAddr())}
+}
@@ -1,11 +1,15 @@
 package cluster
 
 import (
-	"github.com/amsalt/engins"
-	"github.com/amsalt/log"
+	"fmt"
+	"time"
+
+	"github.com/amsalt/engins/database"
+	"github.com/amsalt/engins/monitor/events"
 	"github.com/amsalt/ngicluster"
 	"github.com/amsalt/ngicluster/balancer"
 	"github.com/amsalt/ngicluster/balancer/stickiness"
+	"github.com/amsalt/ngicluster/resolver"
 	"github.com/amsalt/nginet/core"
 )
 
@@ -27,14 +31,20 @@ func (c *Cluster) BuildServer(servName string, addr string, servType string, opt
 	}
 	server := c.clus.NewServerWithConfig(servName, opts.ReadBufSize, opts.WriteBufSize, opts.MaxConn)
 
-	server.InitAcceptor(opts.Executor, engins.Register, engins.Dispatcher, servType)
+	server.InitAcceptor(opts.Executor, c.app.Register(), c.app.Dispatcher(), servType)
 
-	c.registerServListener(server, servName, &opts)
-	c.servers[server] = addr
+	c.registerServListener(server, servName, addr, &opts)
+	listen := c.listenAddr(server, servName, addr, &opts)
+	c.mutex.Lock()
+	c.servers[server] = listen
+	if c.advertise == "" {
+		c.advertise = addr
+	}
+	c.mutex.Unlock()
 }
 
 // BuildServerWithAcceptor builds a new server with serverName, address, acceptor and Options.
-// NOTE: When use this method, register and dispatcher must use  engins.Register and engins.Dispatcher
+// NOTE: When use this method, register and dispatcher must use the ones of the App of Cluster.
 func (c *Cluster) BuildServerWithAcceptor(servName string, addr string, acceptor core.AcceptorChannel, opt ...BuildOption) {
 	opts := defaultConfigOpts
 	for _, o := range opt {
@@ -43,27 +53,122 @@ func (c *Cluster) BuildServerWithAcceptor(servName string, addr string, acceptor
 	server := c.clus.NewServerWithConfig(servName, opts.ReadBufSize, opts.WriteBufSize, opts.MaxConn)
 	server.SetAcceptor(acceptor)
 
-	c.registerServListener(server, servName, &opts)
-	c.servers[server] = addr
+	c.registerServListener(server, servName, addr, &opts)
+	listen := c.listenAddr(server, servName, addr, &opts)
+	c.mutex.Lock()
+	c.servers[server] = listen
+	if c.advertise == "" {
+		c.advertise = addr
+	}
+	c.mutex.Unlock()
 }
 
-func (c *Cluster) registerServListener(server *ngicluster.Server, servName string, opts *ConfigOpts) {
+// listenAddr returns the address for server to listen on, which is empty for
+// the TLS server terminating the connections on addr, and listening on loopback
+// picked when started.
+func (c *Cluster) listenAddr(server *ngicluster.Server, servName string, addr string, opts *ConfigOpts) string {
+	if opts.TLS == nil {
+		return addr
+	}
+
+	if opts.TLS.CertFile == "" {
+		panic(fmt.Errorf("server of %s built with TLS without certificate", servName))
+	}
+	store, err := newCertStore(*opts.TLS)
+	if err != nil {
+		panic(err)
+	}
+	c.mutex.Lock()
+	c.tlsServers[server] = &tlsServer{name: servName, store: store, addr: addr}
+	c.mutex.Unlock()
+	return ""
+}
+
+func (c *Cluster) registerServListener(server *ngicluster.Server, servName string, addr string, opts *ConfigOpts) {
 	server.OnConnect(func(ctx *core.ChannelContext, channel core.Channel) {
+		if c.Draining() {
+			log.Infof("server %s is draining, reject new connection", servName)
+			channel.Close()
+			return
+		}
+
 		ctx.Attr().SetValue(AssociatedServerKey, server)
+		if opts.RemoteMonitor != nil {
+			ctx.Attr().SetValue(remoteMonitorKey, opts.RemoteMonitor)
+		}
+		c.mutex.RLock()
+		ts := c.tlsServers[server]
+		c.mutex.RUnlock()
+		if ts != nil {
+			ts.accepted(fmt.Sprintf("%v", channel.RemoteAddr()))
+		}
+		connections.With(servName, directionInbound).Inc()
+		events.Publish(Name, "server %s accepted connection from %v", servName, channel.RemoteAddr())
+		if opts.Auth != nil {
+			c.challenge(servName, ctx, channel)
+		}
+		c.startHeartbeat(servName, opts.Heartbeat, ctx, channel)
 		if opts.OnConnect != nil {
 			opts.OnConnect(ctx, channel)
 		}
 	})
 
 	server.OnDisconnect(func(ctx *core.ChannelContext) {
+		stopHeartbeat(ctx)
+		if ctx.Attr().Value(AssociatedServerKey) != nil {
+			connections.With(servName, directionInbound).Dec()
+			events.Publish(Name, "server %s lost a connection", servName)
+		}
+		c.leave(ctx.Channel())
+		c.mutex.RLock()
+		ts := c.tlsServers[server]
+		c.mutex.RUnlock()
+		if ts != nil && ctx.Channel() != nil {
+			ts.accepts.Delete(fmt.Sprintf("%v", ctx.Channel().RemoteAddr()))
+		}
+		if h, ok := ctx.Attr().Value(handshakeKey).(*handshake); ok {
+			h.timer.Stop()
+		}
+		if name, ok := ctx.Attr().Value(ChannelNameKey).(string); ok {
+			if channel, ok := ctx.Channel().(core.SubChannel); ok {
+				c.resolver.UnregisterSubChannel(name, channel)
+			}
+		}
 		if opts.OnDisconnect != nil {
 			opts.OnDisconnect(ctx)
 		}
 	})
 
+	c.mutex.Lock()
+	c.relays[server] = opts.IsRelay
+	c.balancers[server] = opts.ServerBalancer
+	c.newStorages[server] = opts.Storage
+	c.idents[server] = c.identity(servName, addr, opts)
+	c.auths[server] = opts.Auth
+	if opts.Executor != nil && c.executors[""] == nil {
+		c.executors[""] = opts.Executor // for services connected to the servers.
+	}
+	c.mutex.Unlock()
+
+	base := "IDParser"
+	if opts.TLS != nil {
+		server.AddAfterHandler(base, nil, "TLSGate", newTLSGate(c, servName))
+		base = "TLSGate"
+	}
+	if opts.Auth != nil {
+		server.AddAfterHandler(base, nil, "AuthGate", newAuthGate(c, servName))
+		base = "AuthGate"
+	}
+
 	if opts.IsRelay {
-		relayHandler := ngicluster.NewRelayHandler(servName, c.clus, DefaultRelayStickinessKey)
-		server.AddAfterHandler("IDParser", nil, "RelayHandler", relayHandler)
+		key := opts.RelayStickinessKey
+		if key == "" {
+			key = DefaultRelayStickinessKey
+		}
+		relayHandler := ngicluster.NewRelayHandler(servName, c.clus, key)
+		server.AddAfterHandler(base, nil, "RelayMetrics", newRelayMetricsHandler(servName))
+		server.AddAfterHandler("RelayMetrics", nil, "RelayRouter", newRelayRouter(c, servName, key))
+		server.AddAfterHandler("RelayRouter", nil, "RelayHandler", relayHandler)
 	}
 }
 
@@ -76,15 +181,18 @@ func (c *Cluster) BuildClient(servName string, clientName string, opt ...BuildOp
 		o(&opts)
 	}
 	client := ngicluster.NewClientWithBufSize(opts.ReadBufSize, opts.WriteBufSize)
-	client.InitConnector(opts.Executor, engins.Register, engins.Dispatcher, true)
+	client.InitConnector(opts.Executor, c.app.Register(), c.app.Dispatcher(), !reconnectable(client, &opts) && opts.TLS == nil)
 
-	c.registerCliListener(client, clientName, &opts)
-	c.clus.AddClient(servName, client, opts.Balancer)
+	c.registerCliListener(client, servName, clientName, &opts)
+	c.clus.AddClient(servName, client, c.clientBalancer(servName, &opts))
+	c.mutex.Lock()
+	c.services[servName] = true
+	c.mutex.Unlock()
 	return client
 }
 
 // BuildClientWithConnector builds a client with Connector and service type.
-// NOTE: When use this method, register and dispatcher must use  engins.Register and engins.Dispatcher
+// NOTE: When use this method, register and dispatcher must use the ones of the App of Cluster.
 func (c *Cluster) BuildClientWithConnector(servName string, clientName string, connector core.ConnectorChannel, opt ...BuildOption) {
 	opts := defaultConfigOpts
 	for _, o := range opt {
@@ -93,29 +201,95 @@ func (c *Cluster) BuildClientWithConnector(servName string, clientName string, c
 	client := ngicluster.NewClientWithBufSize(opts.ReadBufSize, opts.WriteBufSize)
 	client.SetConnector(connector)
 
-	c.registerCliListener(client, clientName, &opts)
-	c.clus.AddClient(servName, client, opts.Balancer)
+	c.registerCliListener(client, servName, clientName, &opts)
+	c.clus.AddClient(servName, client, c.clientBalancer(servName, &opts))
+	c.mutex.Lock()
+	c.services[servName] = true
+	c.mutex.Unlock()
+}
+
+// clientBalancer returns the balancer of the clients of servName, the one of
+// opts or a stickiness balancer by default, recording the nodes picked for calls.
+func (c *Cluster) clientBalancer(servName string, opts *ConfigOpts) balancer.Balancer {
+	b := opts.Balancer
+	if b == nil {
+		b = balancer.GetBuilder(stickiness.Name).Build(
+			stickiness.WithServName(servName),
+			stickiness.WithResolver(c.resolver),
+		)
+	}
+	return &pickingBalancer{Balancer: b}
 }
 
-func (c *Cluster) registerCliListener(client *ngicluster.Client, clientName string, opts *ConfigOpts) {
+func (c *Cluster) registerCliListener(client *ngicluster.Client, servName string, clientName string, opts *ConfigOpts) {
+	if opts.Executor != nil {
+		c.mutex.Lock()
+		c.executors[servName] = opts.Executor
+		c.mutex.Unlock()
+	}
+	var reconnector interface{} = client
+	var tc *tlsClient
+	if opts.TLS != nil {
+		store, err := newCertStore(*opts.TLS)
+		if err != nil {
+			panic(err)
+		}
+		cn, ok := interface{}(client).(connector)
+		if !ok {
+			panic(fmt.Errorf("client of %s built with TLS can't connect to an address", servName))
+		}
+		tc = &tlsClient{c: c, name: servName, store: store, client: cn}
+		reconnector = tc
+		c.mutex.Lock()
+		c.tlsClients[servName] = tc
+		c.mutex.Unlock()
+	}
+
 	client.OnConnect(func(ctx *core.ChannelContext, channel core.Channel) {
+		addr := fmt.Sprintf("%v", channel.RemoteAddr())
+		if tc != nil {
+			addr = tc.nodeAddr(addr)
+		}
 		ctx.Attr().SetValue(AssociatedClientKey, client)
-		c.identifingSelf(clientName, ctx)
+		ctx.Attr().SetValue(dialAddrKey, addr)
+		if opts.RemoteMonitor != nil {
+			ctx.Attr().SetValue(remoteMonitorKey, opts.RemoteMonitor)
+		}
+		connections.With(servName, directionOutbound).Inc()
+		events.Publish(Name, "client %s connected to %s at %v", clientName, servName, channel.RemoteAddr())
+		c.join(channel, &Node{Service: servName, Addr: addr}, directionOutbound)
+
+		if opts.Auth != nil {
+			c.proveSelf(clientName, opts, ctx, func() {
+				c.startHeartbeat(servName, opts.Heartbeat, ctx, channel)
+				if opts.OnConnect != nil {
+					opts.OnConnect(ctx, channel)
+				}
+			})
+			return
+		}
 
+		c.identifingSelf(clientName, opts, ctx)
+		c.startHeartbeat(servName, opts.Heartbeat, ctx, channel)
 		if opts.OnConnect != nil {
 			opts.OnConnect(ctx, channel)
 		}
 	})
 
 	client.OnDisconnect(func(ctx *core.ChannelContext) {
+		connections.With(servName, directionOutbound).Dec()
+		events.Publish(Name, "client %s disconnected from %s", clientName, servName)
+		stopHeartbeat(ctx)
+		c.leave(ctx.Channel())
+		c.onClientDisconnect(servName, reconnector, opts, ctx)
 		if opts.OnDisconnect != nil {
 			opts.OnDisconnect(ctx)
 		}
 	})
 }
 
-func (c *Cluster) identifingSelf(servName string, ctx *core.ChannelContext) {
-	ctx.Write(&IdentifySelf{Name: servName})
+func (c *Cluster) identifingSelf(servName string, opts *ConfigOpts, ctx *core.ChannelContext) {
+	ctx.Write(c.identity(servName, c.advertiseAddr(), opts))
 }
 
 // WithOnConnect register handler When Connect.
@@ -153,13 +327,140 @@ func WithExecutor(e core.Executor) BuildOption {
 	}
 }
 
-// WithBalancer sets executor.
+// WithBalancer sets the balancer of the client picking the node to write to,
+// a stickiness balancer of the resolver of Cluster by default.
 func WithBalancer(b balancer.Balancer) BuildOption {
 	return func(o interface{}) {
 		o.(*ConfigOpts).Balancer = b
 	}
 }
 
+// WithServerBalancer sets the name of balancer the server writes to the
+// clients connected with, such as ConsistentHashName. `stickiness` by default.
+func WithServerBalancer(name string) BuildOption {
+	return func(o interface{}) {
+		o.(*ConfigOpts).ServerBalancer = name
+	}
+}
+
+// WithLabels sets the labels to identify self to the other side,
+// which are used to select nodes by BroadcastFilter.
+func WithLabels(labels map[string]string) BuildOption {
+	return func(o interface{}) {
+		o.(*ConfigOpts).Labels = labels
+	}
+}
+
+// WithNodeID sets the unique id to identify self, NodeID by default.
+func WithNodeID(id string) BuildOption {
+	return func(o interface{}) {
+		o.(*ConfigOpts).NodeID = id
+	}
+}
+
+// WithZone sets the zone and region to identify self.
+func WithZone(zone string, region string) BuildOption {
+	return func(o interface{}) {
+		o.(*ConfigOpts).Zone = zone
+		o.(*ConfigOpts).Region = region
+	}
+}
+
+// WithWeight sets the capacity weight to identify self, DefaultWeight by default.
+func WithWeight(weight int) BuildOption {
+	return func(o interface{}) {
+		o.(*ConfigOpts).Weight = weight
+	}
+}
+
+// WithAdvertiseAddr sets the address to identify self, for the other nodes to
+// connect to. The listening address of server, or of the first server of the
+// Cluster for client by default.
+func WithAdvertiseAddr(addr string) BuildOption {
+	return func(o interface{}) {
+		o.(*ConfigOpts).AdvertiseAddr = addr
+	}
+}
+
+// WithAuth sets the authenticator of handshake. A server with it challenges the
+// connected clients and closes the ones failed to prove their identities, or
+// sending other messages before authenticated. A client with it proves its
+// identity when challenged, and OnConnect is called after the server accepted.
+func WithAuth(a Authenticator) BuildOption {
+	return func(o interface{}) {
+		o.(*ConfigOpts).Auth = a
+	}
+}
+
+// WithRemoteMonitor accepts the monitor commands run by the nodes connected,
+// such as by `cluster exec`, allowing the commands written as the ones of
+// monitor.Access.DefineRole, DefaultRemoteCommands if none.
+// Without it, the server accepts DefaultRemoteCommands from the nodes
+// authenticated by WithAuth only, and the client accepts none.
+func WithRemoteMonitor(commands ...string) BuildOption {
+	return func(o interface{}) {
+		if len(commands) == 0 {
+			commands = DefaultRemoteCommands
+		}
+		o.(*ConfigOpts).RemoteMonitor = commands
+	}
+}
+
+// WithHMACAuth sets the authenticator of handshake with the shared secret, see WithAuth.
+func WithHMACAuth(secret string) BuildOption {
+	return WithAuth(NewHMACAuth(secret))
+}
+
+// WithTLS enables TLS with the certificate and its key in PEM files, see
+// TLSConfig. The clients built with it connect by Cluster.ConnectTLS, the
+// files may be empty for the clients presenting no certificate.
+func WithTLS(certFile string, keyFile string) BuildOption {
+	return func(o interface{}) {
+		cfg := tlsOf(o.(*ConfigOpts))
+		cfg.CertFile, cfg.KeyFile = certFile, keyFile
+	}
+}
+
+// WithMutualTLS makes servers require the certificates of clients verified by
+// caFile or the CA of a service in serviceCAs, and clients verify the servers
+// by the CA of service or caFile, see TLSConfig. It works with WithTLS.
+func WithMutualTLS(caFile string, serviceCAs map[string]string) BuildOption {
+	return func(o interface{}) {
+		cfg := tlsOf(o.(*ConfigOpts))
+		cfg.CAFile, cfg.ServiceCAs = caFile, serviceCAs
+	}
+}
+
+// WithTLSConfig enables TLS with cfg.
+func WithTLSConfig(cfg TLSConfig) BuildOption {
+	return func(o interface{}) {
+		*tlsOf(o.(*ConfigOpts)) = cfg
+	}
+}
+
+func tlsOf(opts *ConfigOpts) *TLSConfig {
+	if opts.TLS == nil {
+		opts.TLS = &TLSConfig{}
+	}
+	return opts.TLS
+}
+
+// WithHeartbeat sends Ping every interval on the channels, and evicts the node
+// if no Ping or Pong received in misses intervals, DefaultHeartbeatMisses if 0.
+func WithHeartbeat(interval time.Duration, misses int) BuildOption {
+	return func(o interface{}) {
+		o.(*ConfigOpts).Heartbeat = &Heartbeat{Interval: interval, Misses: misses}
+	}
+}
+
+// WithReconnect makes the client reconnect to the node disconnected by the
+// backoff, the fields not set are the ones of DefaultBackoff.
+func WithReconnect(b Backoff) BuildOption {
+	return func(o interface{}) {
+		o.(*ConfigOpts).Reconnect = &b
+	}
+}
+
 // WithServerMaxConnSize sets max size of connected clients.
 func WithServerMaxConnSize(m int) BuildOption {
 	return func(o interface{}) {
@@ -174,6 +475,35 @@ func WithServerRelay(b bool) BuildOption {
 	}
 }
 
+// WithRelayStickinessKey sets the channel attribute as the stickiness key of
+// relay server, DefaultRelayStickinessKey if not set. Use AddRelayRoute for
+// the messages relayed with other keys.
+func WithRelayStickinessKey(key string) BuildOption {
+	return func(o interface{}) {
+		o.(*ConfigOpts).RelayStickinessKey = key
+	}
+}
+
+// StorageBuilder creates the storage of the nodes of servName picked by
+// stickiness keys, resolved by r.
+type StorageBuilder func(servName string, r resolver.Resolver) balancer.Storage
+
+// WithStorage sets the builder of storages of the balancers the server writes
+// to the clients connected with, the in-process storage by default.
+func WithStorage(b StorageBuilder) BuildOption {
+	return func(o interface{}) {
+		o.(*ConfigOpts).Storage = b
+	}
+}
+
+// WithRedisStorage sets the storages of the server to RedisStorage, so the
+// servers sharing client routes the same stickiness key to the same node.
+func WithRedisStorage(client *database.RedisClient, ttl time.Duration) BuildOption {
+	return WithStorage(func(servName string, r resolver.Resolver) balancer.Storage {
+		return NewRedisStorage(client, servName, r, ttl)
+	})
+}
+
 // ConfigOpts represents the options to build a new cluster.Server or cluster.Client
 type ConfigOpts struct {
 	OnConnect    func(*core.ChannelContext, core.Channel)
@@ -183,10 +513,29 @@ type ConfigOpts struct {
 	WriteBufSize int               // sets the size of write buffer.
 	ReadBufSize  int               // sets the size of read buffer.
 	Balancer     balancer.Balancer // sets the balancer to dispatch message in servers.
+	Labels       map[string]string // sets the labels to identify self.
+
+	// identity of self
+	NodeID        string // sets the unique id of node.
+	Zone          string // sets the zone of node.
+	Region        string // sets the region of node.
+	Weight        int    // sets the capacity weight of node.
+	AdvertiseAddr string // sets the address for the other nodes to connect to.
+
+	Auth          Authenticator // sets the authenticator of handshake.
+	RemoteMonitor []string      // the monitor commands allowed to run by the nodes connected.
+	TLS           *TLSConfig    // enables TLS.
+
+	Heartbeat *Heartbeat // enables heartbeats.
+	Reconnect *Backoff   // sets the backoff of client reconnecting.
 
 	// server specifics
-	MaxConn int  // limit the max connection number to the server.
-	IsRelay bool // whether the server is a relay server.
+	MaxConn        int            // limit the max connection number to the server.
+	IsRelay        bool           // whether the server is a relay server.
+	ServerBalancer string         // the name of balancer to write to the clients connected.
+	Storage        StorageBuilder // the builder of storages of balancers.
+
+	RelayStickinessKey string // the channel attribute as stickiness key of relay server.
 }
 
 var defaultConfigOpts = ConfigOpts{
@@ -196,27 +545,83 @@ var defaultConfigOpts = ConfigOpts{
 }
 
 func (c *Cluster) identityClientHandler(ctx *core.ChannelContext, msg interface{}, args ...interface{}) {
+	identify, isIdentifySelf := msg.(*IdentifySelf)
+	if !isIdentifySelf {
+		return
+	}
+
+	node := newNode(identify, ctx.Channel())
+	if node.Draining && ctx.Channel() != nil {
+		c.drained.Store(ctx.Channel(), int64(0))
+	}
 	relatedServer := ctx.Attr().Value(AssociatedServerKey)
 	if relatedServer == nil {
+		ctx.Attr().SetValue(NodeKey, node)
+		c.join(ctx.Channel(), node, directionOutbound) // identified by the server connected.
+		if ready, ok := ctx.Attr().Value(readyKey).(func()); ok {
+			ctx.Attr().SetValue(readyKey, nil)
+			ready()
+		}
 		return
 	}
 
 	server, isServer := relatedServer.(*ngicluster.Server)
-	identify, isIdentifySelf := msg.(*IdentifySelf)
+	if isServer {
+		c.mutex.RLock()
+		self, auth := c.idents[server], c.auths[server]
+		c.mutex.RUnlock()
+		if auth != nil && !c.authenticate(self.Name, auth, ctx, identify) {
+			return
+		}
+		if err := c.checkPeer(ctx, identify); err != nil {
+			c.rejectAuth(self.Name, ctx.Channel(), fmt.Sprintf("%s@%v", identify.Name, ctx.Channel().RemoteAddr()), err.Error())
+			return
+		}
+		ctx.Attr().SetValue(NodeKey, node)
+		if self != nil {
+			ctx.Write(self)
+		}
 
-	if isServer && isIdentifySelf {
 		ctx.Attr().SetValue(ChannelNameKey, identify.Name)
 		if server.GetBalancer(identify.Name) == nil {
-			c.storages[identify.Name] = stickiness.NewDefaultStorage()
-			b := balancer.GetBuilder(stickiness.Name).Build(
-				stickiness.WithStorage(c.storages[identify.Name]),
-				stickiness.WithServName(identify.Name),
-				stickiness.WithResolver(c.resolver),
-			)
-
-			server.SetBalancer(identify.Name, b)
-			c.resolver.RegisterSubChannel(identify.Name, ctx.Channel().(core.SubChannel))
+			c.mutex.RLock()
+			name, newStorage := c.balancers[server], c.newStorages[server]
+			c.mutex.RUnlock()
+			var storage balancer.Storage
+			if newStorage != nil {
+				storage = newStorage(identify.Name, c.resolver)
+			} else {
+				storage = stickiness.NewDefaultStorage()
+			}
+			c.mutex.Lock()
+			c.storages[identify.Name] = storage
+			c.mutex.Unlock()
+
+			var b balancer.Balancer
+			switch name {
+			case "", stickiness.Name:
+				b = &drainBalancer{
+					Balancer: balancer.GetBuilder(stickiness.Name).Build(
+						stickiness.WithStorage(storage),
+						stickiness.WithServName(identify.Name),
+						stickiness.WithResolver(c.Resolver()),
+					),
+					servName: identify.Name,
+					storage:  storage,
+					resolver: c.resolver,
+				}
+			default:
+				b = balancer.GetBuilder(name).Build(
+					WithBalancerStorage(storage),
+					WithBalancerService(identify.Name),
+					WithBalancerResolver(c.Resolver()),
+				)
+			}
+
+			server.SetBalancer(identify.Name, &pickingBalancer{Balancer: b})
 			log.Debugf("server set balancer for client: %+v", identify.Name)
 		}
+		c.resolver.RegisterSubChannel(identify.Name, ctx.Channel().(core.SubChannel))
+		c.join(ctx.Channel(), node, directionInbound)
 	}
 }
@@ -1,10 +1,16 @@
 package cluster
 
 import (
+	"context"
 	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 
 	"github.com/amsalt/engins"
-	"github.com/amsalt/log"
+	"github.com/amsalt/engins/errs"
+	"github.com/amsalt/engins/logging"
 	"github.com/amsalt/ngicluster"
 	"github.com/amsalt/ngicluster/balancer"
 	"github.com/amsalt/ngicluster/consts"
@@ -14,6 +20,8 @@ import (
 	"github.com/amsalt/nginet/encoding/json"
 )
 
+var log = logging.New("cluster")
+
 // package cluster provides the API for building an auto service discovery cluster.
 // it's easy to build a client auto connect to the special kinds of servers,
 // and it's also easy to build a server for relay message.
@@ -22,23 +30,91 @@ import (
 // right server or client.
 
 // IdentifySelf is a protocol for cluster client to register self information when connected with server.
+// Servers identify themselves back to the clients.
 type IdentifySelf struct {
-	Name string
-	Addr string
+	Name    string
+	Addr    string // the address to connect to the node, if it's serving.
+	ID      string // unique id of the node, NodeID by default.
+	Version string // version of engins.
+	Build   string // build of the node, Build by default.
+	Zone    string
+	Region  string
+	Weight  int // capacity weight used by balancers, DefaultWeight by default.
+	Labels  map[string]string
+
+	Draining bool // whether the node is draining, see DrainNotice.
+
+	// proof of identity for the Challenge of an authenticating server.
+	Nonce string
+	Proof string
 }
 
+// Name is the component name of Cluster.
+const Name = "cluster"
+
 type Cluster struct {
+	app      *engins.App
 	resolver resolver.Resolver
 	clus     *ngicluster.Cluster
 	servers  map[*ngicluster.Server]string
 	storages map[string]balancer.Storage
+	services map[string]bool // service names connected as client.
+	started  int32           // whether the servers are listening.
+
+	mutex          sync.RWMutex // guards storages, relays, balancers, newStorages, idents, auths, routes, relayRoutes, executors, advertise and draining.
+	draining       bool         // whether the servers reject new connections.
+	advertise      string       // address of the first server built, identified by clients.
+	drainDeadline  int64        // unix milliseconds announced by Drain.
+	sessionCounter func() int
+	loadReporter   func() int64
+	relays         map[*ngicluster.Server]bool
+	balancers      map[*ngicluster.Server]string // names of balancers of servers.
+	newStorages    map[*ngicluster.Server]StorageBuilder
+	idents         map[*ngicluster.Server]*IdentifySelf // identities of servers.
+	auths          map[*ngicluster.Server]Authenticator // authenticators of servers.
+	routes         map[string]string                    // service names by message id of relay routers.
+	relayRoutes    []RelayRoute                         // routes added by AddRelayRoute.
+	executors      map[string]core.Executor             // executors by service name.
+	execs          execs
+	calls          calls
+	membership     membership
+
+	tlsServers map[*ngicluster.Server]*tlsServer // guarded by mutex.
+	tlsClients map[string]*tlsClient             // by service name, guarded by mutex.
+	peers      sync.Map                          // *Peer by the address of connection forwarded by TLS, nil if no certificate.
+	drained    sync.Map                          // deadlines of the draining nodes by channel.
+
+	reconnecting reconnecting
+	done         chan struct{} // closed when stopped.
+	stopOnce     sync.Once
 }
 
+// NewCluster creates a Cluster by using the default App.
 func NewCluster(rsv resolver.Resolver) *Cluster {
-	c := &Cluster{resolver: rsv}
+	return NewClusterWithApp(engins.Default(), rsv)
+}
+
+// NewClusterWithApp creates a Cluster which registers and dispatches messages with app.
+func NewClusterWithApp(app *engins.App, rsv resolver.Resolver) *Cluster {
+	c := &Cluster{app: app, resolver: rsv}
 	c.clus = ngicluster.NewCluster(rsv)
 	c.servers = make(map[*ngicluster.Server]string)
 	c.storages = make(map[string]balancer.Storage)
+	c.services = make(map[string]bool)
+	c.relays = make(map[*ngicluster.Server]bool)
+	c.balancers = make(map[*ngicluster.Server]string)
+	c.newStorages = make(map[*ngicluster.Server]StorageBuilder)
+	c.idents = make(map[*ngicluster.Server]*IdentifySelf)
+	c.auths = make(map[*ngicluster.Server]Authenticator)
+	c.routes = make(map[string]string)
+	c.executors = make(map[string]core.Executor)
+	c.execs.pending = make(map[uint64]chan *MonitorResponse)
+	c.calls.pending = make(map[uint64]*call)
+	c.membership.members = make(map[core.Channel]*Member)
+	c.tlsServers = make(map[*ngicluster.Server]*tlsServer)
+	c.tlsClients = make(map[string]*tlsClient)
+	c.reconnecting.states = make(map[string]*ReconnectState)
+	c.done = make(chan struct{})
 	c.Init()
 
 	return c
@@ -49,6 +125,10 @@ func NewCluster(rsv resolver.Resolver) *Cluster {
 // with the msgID will be sent to the server with Name `servName` automatically
 func (c *Cluster) RegisterRelayRouter(msgID interface{}, servName string) {
 	c.clus.Register(msgID, servName)
+
+	c.mutex.Lock()
+	c.routes[fmt.Sprintf("%v", msgID)] = servName
+	c.mutex.Unlock()
 }
 
 func (c *Cluster) Clients(servName string) []core.SubChannel {
@@ -58,57 +138,122 @@ func (c *Cluster) Clients(servName string) []core.SubChannel {
 // Write sends message to server with name `servName`.
 // it will try to use client connection or server connection to send message.
 func (c *Cluster) Write(servName string, msg interface{}, ctx ...interface{}) error {
-	var err error
-	var found bool
+	via, err := c.write(servName, msg, ctx...)
+	recordWrite(servName, via, err)
+	return err
+}
+
+// write writes the message and returns which kind of connection is used.
+func (c *Cluster) write(servName string, msg interface{}, ctx ...interface{}) (string, error) {
 	if len(c.Clients(servName)) > 0 {
-		found = true
 		log.Debugf("cluster write msg with client.")
-		err = c.clus.Write(servName, msg, ctx...)
-	} else {
-		for s := range c.servers { // support multiple servers.
-			log.Debugf("cluster write msg with server.")
-			if len(ctx) > 0 {
-				err = s.Write(servName, msg, ctx[0])
-			} else {
-				err = s.Write(servName, msg, nil)
-			}
+		return viaClient, c.clus.Write(servName, msg, ctx...)
+	}
 
-			if err == nil {
-				found = true
-				return nil
-			}
+	for s := range c.servers { // support multiple servers.
+		log.Debugf("cluster write msg with server.")
+		var err error
+		if len(ctx) > 0 {
+			err = s.Write(servName, msg, ctx[0])
+		} else {
+			err = s.Write(servName, msg, nil)
 		}
-	}
 
-	if !found {
-		log.Errorf("no suited cluster components found to write message for %s", servName)
-		return fmt.Errorf("no suited cluster components found to write message for %s", servName)
+		if err == nil {
+			return viaServer, nil
+		}
 	}
 
-	return err
+	log.Errorf("no suited cluster components found to write message for %s", servName)
+	return viaNone, errs.NewNoRoute(servName)
 }
 
 func (c *Cluster) Init() {
-	engins.RegisterMsgByID(
+	c.app.RegisterMsgByID(
 		consts.SystemIdentifySelf,
 		&IdentifySelf{}).SetCodec(encoding.MustGetCodec(json.CodecJSON))
-	engins.RegisterProcessorByID(consts.SystemIdentifySelf, c.identityClientHandler)
+	c.app.RegisterProcessorByID(consts.SystemIdentifySelf, c.identityClientHandler)
+	c.registerMonitor()
+	c.registerRPC()
+	c.registerAuth()
+	c.registerHeartbeat()
+	c.registerDrain()
+}
+
+// Name returns the component name of the Cluster.
+func (c *Cluster) Name() string {
+	return Name
 }
 
 // Start starts the Cluster
 func (c *Cluster) Start() {
 	for s, addr := range c.servers {
+		c.mutex.RLock()
+		ts := c.tlsServers[s]
+		c.mutex.RUnlock()
+		if ts != nil {
+			backend, err := loopbackAddr()
+			if err != nil {
+				log.Errorf("cluster pick address on loopback for %s failed: %v", ts.name, err)
+				continue
+			}
+			ts.backend, addr = backend, backend
+		}
 		s.Listen(addr)
 		go s.Accept()
 	}
+	for _, ts := range c.tlsServers {
+		if err := c.startTLSServer(ts); err != nil {
+			log.Errorf("cluster serve TLS on %s failed: %v", ts.addr, err)
+		}
+	}
+	atomic.StoreInt32(&c.started, 1)
+}
+
+// CheckHealth checks the servers are listening and every service connected
+// as client has at least one connected node.
+func (c *Cluster) CheckHealth(ctx context.Context) error {
+	c.mutex.RLock()
+	listening := len(c.servers) > 0
+	services := make([]string, 0, len(c.services))
+	for servName := range c.services {
+		services = append(services, servName)
+	}
+	c.mutex.RUnlock()
+
+	if listening && atomic.LoadInt32(&c.started) == 0 {
+		return fmt.Errorf("cluster servers not listening")
+	}
+
+	var disconnected []string
+	for _, servName := range services {
+		if len(c.Clients(servName)) == 0 {
+			disconnected = append(disconnected, servName)
+		}
+	}
+	if len(disconnected) > 0 {
+		sort.Strings(disconnected)
+		return fmt.Errorf("no connected node of services: %s", strings.Join(disconnected, ", "))
+	}
+	return nil
 }
 
 // Stop stops the Cluster
 func (c *Cluster) Stop() {
+	atomic.StoreInt32(&c.started, 0)
+	c.stopOnce.Do(func() { close(c.done) })
+
 	// stop servers
 	for s := range c.servers {
 		s.Close()
 	}
+	c.mutex.RLock()
+	for _, ts := range c.tlsServers {
+		if ts.tunnel != nil {
+			ts.tunnel.close()
+		}
+	}
+	c.mutex.RUnlock()
 
 	// stop clients
 	c.clus.CloseClients()
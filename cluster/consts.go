@@ -0,0 +1,33 @@
+package cluster
+
+import (
+	"fmt"
+
+	"github.com/amsalt/ngicluster/consts"
+)
+
+// System message IDs used by the Cluster, allocated downwards from consts.SystemIdentifySelf.
+const (
+	SystemMonitorRequest = consts.SystemIdentifySelf - 1 - iota
+	SystemMonitorResponse
+	SystemRPCRequest
+	SystemRPCResponse
+	SystemChallenge
+	SystemPing
+	SystemPong
+	SystemDrain
+)
+
+var systemMessages = map[string]bool{}
+
+func init() {
+	for _, id := range []interface{}{consts.SystemIdentifySelf, SystemMonitorRequest, SystemMonitorResponse,
+		SystemRPCRequest, SystemRPCResponse, SystemChallenge, SystemPing, SystemPong, SystemDrain} {
+		systemMessages[fmt.Sprintf("%v", id)] = true
+	}
+}
+
+// isSystemMessage returns whether msgID is the id of a system message.
+func isSystemMessage(msgID string) bool {
+	return systemMessages[msgID]
+}
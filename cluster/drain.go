@@ -0,0 +1,210 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/amsalt/ngicluster/balancer"
+	"github.com/amsalt/ngicluster/resolver"
+	"github.com/amsalt/nginet/core"
+	"github.com/amsalt/nginet/encoding"
+	"github.com/amsalt/nginet/encoding/json"
+)
+
+// DrainPollInterval is the interval to check the sessions while draining.
+var DrainPollInterval = time.Millisecond * 200
+
+// DrainNotice is a protocol announcing the node is draining, the peers stop
+// picking it for new stickiness keys.
+type DrainNotice struct {
+	Deadline int64 // unix milliseconds the node shuts down at, 0 if unknown.
+}
+
+func (c *Cluster) registerDrain() {
+	c.app.RegisterMsgByID(SystemDrain, &DrainNotice{}).SetCodec(encoding.MustGetCodec(json.CodecJSON))
+	c.app.RegisterProcessorByID(SystemDrain, c.drainHandler)
+}
+
+// SetSessionCounter sets the function counting the sessions not finished yet,
+// which Drain waits for. If not set, Drain waits until its context done, as the
+// sessions such as of the users relayed are unknown.
+func (c *Cluster) SetSessionCounter(f func() int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.sessionCounter = f
+}
+
+// countingSessions returns whether the sessions are counted by SetSessionCounter.
+func (c *Cluster) countingSessions() bool {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return c.sessionCounter != nil
+}
+
+// sessions returns the sessions counted, or the pending calls if not counting,
+// as the load of node.
+func (c *Cluster) sessions() int {
+	c.mutex.RLock()
+	f := c.sessionCounter
+	c.mutex.RUnlock()
+	if f != nil {
+		return f()
+	}
+
+	c.calls.mutex.Lock()
+	defer c.calls.mutex.Unlock()
+	return len(c.calls.pending)
+}
+
+// Drain makes the servers reject new connections and announces draining to
+// the connected nodes, then waits for the sessions counted to finish until ctx
+// done, or until ctx done if not counted, see SetSessionCounter. The connected
+// ones keep working until the Cluster stopped.
+func (c *Cluster) Drain(ctx context.Context) error {
+	var deadline int64
+	if d, ok := ctx.Deadline(); ok {
+		deadline = d.UnixNano() / int64(time.Millisecond)
+	}
+	c.mutex.Lock()
+	c.draining = true
+	c.drainDeadline = deadline
+	c.mutex.Unlock()
+	log.Infof("cluster draining, new connections will be rejected")
+
+	notice := &DrainNotice{Deadline: deadline}
+	c.membership.mutex.RLock()
+	for channel := range c.membership.members {
+		if err := channel.Write(notice); err != nil {
+			log.Errorf("cluster announce draining to %v failed: %v", channel.RemoteAddr(), err)
+		}
+	}
+	c.membership.mutex.RUnlock()
+
+	if !c.countingSessions() {
+		<-ctx.Done()
+		if ctx.Err() != context.DeadlineExceeded {
+			return fmt.Errorf("cluster drain stopped: %v", ctx.Err())
+		}
+		log.Infof("cluster drained at deadline")
+		return nil
+	}
+
+	ticker := time.NewTicker(DrainPollInterval)
+	defer ticker.Stop()
+	for {
+		n := c.sessions()
+		if n == 0 {
+			log.Infof("cluster drained")
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("cluster drain stopped with %d sessions: %v", n, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// Draining returns whether the Cluster is draining.
+func (c *Cluster) Draining() bool {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return c.draining
+}
+
+// DrainDeadline returns the deadline announced by Drain, zero if unknown.
+func (c *Cluster) DrainDeadline() time.Time {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	if c.drainDeadline == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, c.drainDeadline*int64(time.Millisecond))
+}
+
+func (c *Cluster) drainHandler(ctx *core.ChannelContext, msg interface{}, args ...interface{}) {
+	notice, ok := msg.(*DrainNotice)
+	if !ok || ctx.Channel() == nil {
+		return
+	}
+
+	node := &Node{Addr: fmt.Sprintf("%v", ctx.Channel().RemoteAddr())}
+	if identified, ok := ctx.Attr().Value(NodeKey).(*Node); ok {
+		*node = *identified
+	}
+	node.Draining = true
+	ctx.Attr().SetValue(NodeKey, node)
+	c.drained.Store(ctx.Channel(), notice.Deadline)
+
+	m := Member{Node: *node, Direction: directionOutbound}
+	if ctx.Attr().Value(AssociatedServerKey) != nil {
+		m.Direction = directionInbound
+	}
+	if c.update(ctx.Channel(), node) {
+		c.emit(MembershipEvent{Type: NodeDraining, Service: node.Service, Member: m})
+	}
+}
+
+// Resolver returns the resolver of the Cluster resolving the nodes not
+// draining, for the balancers picking nodes for new stickiness keys.
+// All nodes are resolved if all of them are draining. The balancers of the
+// servers still pick a draining node for the keys stored or hashed to it.
+func (c *Cluster) Resolver() resolver.Resolver {
+	return &drainResolver{Resolver: c.resolver, c: c}
+}
+
+// resolveAll returns all nodes of service resolved by r, including the draining
+// ones filtered out by the resolver of Cluster, for the stored or ring lookups.
+func resolveAll(r resolver.Resolver, servName string) []core.SubChannel {
+	if d, ok := r.(*drainResolver); ok {
+		return d.Resolver.Resolve(servName)
+	}
+	return r.Resolve(servName)
+}
+
+// drainBalancer picks the node stored for the stickiness key even if it's
+// draining, otherwise picks by the Balancer resolving the nodes not draining.
+type drainBalancer struct {
+	balancer.Balancer
+	servName string
+	storage  balancer.Storage
+	resolver resolver.Resolver // resolves all nodes.
+}
+
+func (b *drainBalancer) Pick(ctx interface{}) (core.SubChannel, error) {
+	if ctx != nil {
+		if stored, ok := b.storage.Get(ctx).(core.SubChannel); ok {
+			for _, node := range b.resolver.Resolve(b.servName) {
+				if node == stored {
+					return node, nil
+				}
+			}
+		}
+	}
+	return b.Balancer.Pick(ctx)
+}
+
+// drainResolver filters out the draining nodes.
+type drainResolver struct {
+	resolver.Resolver
+	c *Cluster
+}
+
+func (r *drainResolver) Resolve(servName string) []core.SubChannel {
+	all := r.Resolver.Resolve(servName)
+	nodes := make([]core.SubChannel, 0, len(all))
+	for _, node := range all {
+		if _, draining := r.c.drained.Load(node); !draining {
+			nodes = append(nodes, node)
+		}
+	}
+	if len(nodes) == 0 {
+		return all
+	}
+	return nodes
+}
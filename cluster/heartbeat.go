@@ -0,0 +1,172 @@
+package cluster
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/amsalt/engins/monitor/metrics"
+	"github.com/amsalt/nginet/core"
+	"github.com/amsalt/nginet/encoding"
+	"github.com/amsalt/nginet/encoding/json"
+)
+
+// DefaultHeartbeatMisses is the number of missed heartbeats to evict a node.
+const DefaultHeartbeatMisses = 3
+
+// heartbeatKey is the key of channel attribute to store the *beat.
+const heartbeatKey = "Heartbeat"
+
+var evictions = metrics.NewCounter("engins_cluster_evictions_total",
+	"Total nodes evicted for missing heartbeats.", "service")
+
+// Ping is a protocol of heartbeat, replied by Pong. Nodes always reply Ping
+// even if heartbeat is not enabled on them.
+type Ping struct {
+	Seq  uint64
+	Load int64 // the load of sender, see SetLoadReporter.
+}
+
+// Pong is a protocol replying Ping.
+type Pong struct {
+	Seq  uint64
+	Load int64 // the load of sender.
+}
+
+// Heartbeat configures the heartbeats of channels.
+type Heartbeat struct {
+	Interval time.Duration // interval to send Ping.
+	Misses   int           // number of intervals without Ping or Pong received to evict the node.
+}
+
+// beat is the heartbeat state of a channel.
+type beat struct {
+	last int64 // unix nano of the last Ping or Pong received.
+	seq  uint64
+	stop chan struct{}
+	once sync.Once
+}
+
+func (b *beat) alive() {
+	atomic.StoreInt64(&b.last, time.Now().UnixNano())
+}
+
+func (b *beat) close() {
+	b.once.Do(func() { close(b.stop) })
+}
+
+func (c *Cluster) registerHeartbeat() {
+	c.app.RegisterMsgByID(SystemPing, &Ping{}).SetCodec(encoding.MustGetCodec(json.CodecJSON))
+	c.app.RegisterProcessorByID(SystemPing, c.pingHandler)
+	c.app.RegisterMsgByID(SystemPong, &Pong{}).SetCodec(encoding.MustGetCodec(json.CodecJSON))
+	c.app.RegisterProcessorByID(SystemPong, c.pongHandler)
+}
+
+// startHeartbeat sends Ping on the channel every interval, and evicts the
+// node of service if no Ping or Pong received in Misses intervals.
+func (c *Cluster) startHeartbeat(servName string, hb *Heartbeat, ctx *core.ChannelContext, channel core.Channel) {
+	if hb == nil || hb.Interval <= 0 {
+		return
+	}
+	misses := hb.Misses
+	if misses <= 0 {
+		misses = DefaultHeartbeatMisses
+	}
+
+	b := &beat{stop: make(chan struct{})}
+	b.alive()
+	ctx.Attr().SetValue(heartbeatKey, b)
+
+	go func() {
+		ticker := time.NewTicker(hb.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-b.stop:
+				return
+			case <-ticker.C:
+			}
+
+			silent := time.Since(time.Unix(0, atomic.LoadInt64(&b.last)))
+			if silent > hb.Interval*time.Duration(misses) {
+				b.close()
+				c.evict(servName, ctx, channel, fmt.Errorf("no heartbeat for %v", silent.Round(time.Millisecond)))
+				return
+			}
+			ctx.Write(&Ping{Seq: atomic.AddUint64(&b.seq, 1), Load: c.load()})
+		}
+	}()
+}
+
+// stopHeartbeat stops the heartbeat of the channel disconnected.
+func stopHeartbeat(ctx *core.ChannelContext) {
+	if b, ok := ctx.Attr().Value(heartbeatKey).(*beat); ok {
+		b.close()
+	}
+}
+
+// evict removes the dead node from the resolver and the members, and closes it.
+func (c *Cluster) evict(servName string, ctx *core.ChannelContext, channel core.Channel, err error) {
+	log.Errorf("cluster evict node of %s at %v: %v", servName, channel.RemoteAddr(), err)
+	evictions.With(servName).Inc()
+
+	m := Member{Node: Node{Service: servName, Addr: fmt.Sprintf("%v", channel.RemoteAddr())}}
+	if node, ok := ctx.Attr().Value(NodeKey).(*Node); ok {
+		m.Node = *node
+	}
+	m.Direction = directionOutbound
+	if ctx.Attr().Value(AssociatedServerKey) != nil {
+		m.Direction = directionInbound
+	}
+	c.emit(MembershipEvent{Type: NodeEvicted, Service: m.Service, Member: m, Err: err})
+
+	if name, ok := ctx.Attr().Value(ChannelNameKey).(string); ok {
+		if sub, ok := channel.(core.SubChannel); ok {
+			c.resolver.UnregisterSubChannel(name, sub)
+		}
+	}
+	c.leave(channel)
+	channel.Close()
+}
+
+func (c *Cluster) pingHandler(ctx *core.ChannelContext, msg interface{}, args ...interface{}) {
+	ping, ok := msg.(*Ping)
+	if !ok {
+		return
+	}
+	if b, ok := ctx.Attr().Value(heartbeatKey).(*beat); ok {
+		b.alive()
+	}
+	storeLoad(ctx, ping.Load)
+	ctx.Write(&Pong{Seq: ping.Seq, Load: c.load()})
+}
+
+func (c *Cluster) pongHandler(ctx *core.ChannelContext, msg interface{}, args ...interface{}) {
+	if b, ok := ctx.Attr().Value(heartbeatKey).(*beat); ok {
+		b.alive()
+	}
+	if pong, ok := msg.(*Pong); ok {
+		storeLoad(ctx, pong.Load)
+	}
+}
+
+// SetLoadReporter sets the function reporting the load of this node to the
+// peers by heartbeats, for the least-load balancers. The sessions counted for
+// Drain are reported if not set.
+func (c *Cluster) SetLoadReporter(f func() int64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.loadReporter = f
+}
+
+func (c *Cluster) load() int64 {
+	c.mutex.RLock()
+	f := c.loadReporter
+	c.mutex.RUnlock()
+	if f != nil {
+		return f()
+	}
+	return int64(c.sessions())
+}
@@ -0,0 +1,141 @@
+package cluster
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"runtime/debug"
+
+	"github.com/amsalt/engins"
+	"github.com/amsalt/nginet/core"
+)
+
+// DefaultWeight is the capacity weight of a node if not set.
+const DefaultWeight = 100
+
+// NodeID is the default ID of the nodes built by this process, `hostname-pid`.
+var NodeID = defaultNodeID()
+
+// Build is the build of this process reported to the other nodes, the VCS
+// revision of the main module by default. It can be set by linker flags such as
+// `-ldflags "-X github.com/amsalt/engins/cluster.Build=v1.2.3-abcdef"`.
+var Build = defaultBuild()
+
+func defaultNodeID() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
+}
+
+func defaultBuild() string {
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+	var revision, modified string
+	for _, s := range bi.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			revision = s.Value
+		case "vcs.modified":
+			modified = s.Value
+		}
+	}
+	if len(revision) > 12 {
+		revision = revision[:12]
+	}
+	if revision != "" && modified == "true" {
+		revision += "-dirty"
+	}
+	if revision == "" {
+		return bi.Main.Version
+	}
+	return revision
+}
+
+// identity returns the IdentifySelf of a server or client named name.
+func (c *Cluster) identity(name string, addr string, opts *ConfigOpts) *IdentifySelf {
+	identify := &IdentifySelf{
+		Name:    name,
+		Addr:    addr,
+		ID:      opts.NodeID,
+		Version: engins.Version,
+		Build:   Build,
+		Zone:    opts.Zone,
+		Region:  opts.Region,
+		Weight:  opts.Weight,
+		Labels:  opts.Labels,
+	}
+	identify.Draining = c.Draining()
+	if opts.AdvertiseAddr != "" {
+		identify.Addr = opts.AdvertiseAddr
+	}
+	if identify.ID == "" {
+		identify.ID = NodeID
+	}
+	if identify.Weight <= 0 {
+		identify.Weight = DefaultWeight
+	}
+	return identify
+}
+
+// advertiseAddr returns the address of the first server built by the Cluster
+// for clients to identify self with, the other nodes may connect to it.
+func (c *Cluster) advertiseAddr() string {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return c.advertise
+}
+
+// newNode returns the Node identified by identify on channel.
+func newNode(identify *IdentifySelf, channel core.Channel) *Node {
+	node := &Node{
+		Service:  identify.Name,
+		Addr:     identify.Addr,
+		ID:       identify.ID,
+		Version:  identify.Version,
+		Build:    identify.Build,
+		Zone:     identify.Zone,
+		Region:   identify.Region,
+		Weight:   identify.Weight,
+		Labels:   identify.Labels,
+		Draining: identify.Draining,
+	}
+	if channel != nil {
+		node.Addr = reachableAddr(node.Addr, channel.RemoteAddr())
+	}
+	if node.Weight <= 0 {
+		node.Weight = DefaultWeight
+	}
+	return node
+}
+
+// reachableAddr completes the address identified such as `:8080` with the
+// host of remote, the remote address is used if addr is empty.
+func reachableAddr(addr string, remote net.Addr) string {
+	if remote == nil {
+		return addr
+	}
+	if addr == "" {
+		return remote.String()
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || (host != "" && !net.ParseIP(host).IsUnspecified()) {
+		return addr
+	}
+	remoteHost, _, err := net.SplitHostPort(remote.String())
+	if err != nil {
+		return addr
+	}
+	return net.JoinHostPort(remoteHost, port)
+}
+
+// NodeOf returns the node identified on the channel of ctx, nil if the other
+// side hasn't identified itself.
+func NodeOf(ctx *core.ChannelContext) *Node {
+	node, _ := ctx.Attr().Value(NodeKey).(*Node)
+	return node
+}
@@ -0,0 +1,213 @@
+package cluster
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/amsalt/engins/monitor/events"
+	"github.com/amsalt/nginet/core"
+)
+
+// MembershipEventType is the type of MembershipEvent.
+type MembershipEventType int
+
+const (
+	NodeJoined MembershipEventType = iota
+	NodeLeft
+	ServiceUp       // the first node of service joined.
+	ServiceDown     // the last node of service left.
+	NodeEvicted     // the node missed heartbeats, it's closed then.
+	Reconnecting    // a client is going to reconnect to the node.
+	Reconnected     // a client reconnected to the node.
+	ReconnectFailed // a client failed to reconnect to the node.
+	NodeDraining    // the node announced draining.
+)
+
+func (t MembershipEventType) String() string {
+	switch t {
+	case NodeJoined:
+		return "NodeJoined"
+	case NodeLeft:
+		return "NodeLeft"
+	case ServiceUp:
+		return "ServiceUp"
+	case ServiceDown:
+		return "ServiceDown"
+	case NodeEvicted:
+		return "NodeEvicted"
+	case Reconnecting:
+		return "Reconnecting"
+	case Reconnected:
+		return "Reconnected"
+	case ReconnectFailed:
+		return "ReconnectFailed"
+	case NodeDraining:
+		return "NodeDraining"
+	}
+	return fmt.Sprintf("MembershipEventType(%d)", int(t))
+}
+
+// Member is a node connected with, as client or server.
+type Member struct {
+	Node
+	Direction string    `json:"direction"` // inbound if the node connected to the servers, otherwise outbound.
+	Since     time.Time `json:"since"`
+}
+
+// MembershipEvent represents a change of cluster membership.
+type MembershipEvent struct {
+	Type    MembershipEventType
+	Service string
+	Member  Member
+	Attempt int   // the attempt of reconnecting.
+	Err     error // why the node is evicted, or failed to reconnect.
+}
+
+// MembershipListener receives the membership events.
+type MembershipListener func(e MembershipEvent)
+
+// membership tracks the members by their channels.
+type membership struct {
+	mutex     sync.RWMutex
+	members   map[core.Channel]*Member
+	listeners []MembershipListener
+}
+
+// OnMembershipChange registers a listener to receive the membership events.
+// Listeners are called synchronously and must not block.
+func (c *Cluster) OnMembershipChange(l MembershipListener) {
+	c.membership.mutex.Lock()
+	defer c.membership.mutex.Unlock()
+
+	c.membership.listeners = append(c.membership.listeners, l)
+}
+
+// Topology returns the connected members by service name, sorted by address.
+func (c *Cluster) Topology() map[string][]Member {
+	c.membership.mutex.RLock()
+	defer c.membership.mutex.RUnlock()
+
+	topology := make(map[string][]Member)
+	for _, m := range c.membership.members {
+		topology[m.Service] = append(topology[m.Service], *m)
+	}
+	for _, members := range topology {
+		sort.Slice(members, func(i, j int) bool {
+			return members[i].Addr < members[j].Addr
+		})
+	}
+	return topology
+}
+
+// join records the member on channel, or updates the identity of the member
+// if it's joined, such as identified by the server connected.
+func (c *Cluster) join(channel core.Channel, node *Node, direction string) {
+	if channel == nil {
+		return
+	}
+
+	if c.update(channel, node) {
+		return
+	}
+
+	ms := &c.membership
+	ms.mutex.Lock()
+	if _, exist := ms.members[channel]; exist {
+		ms.mutex.Unlock()
+		return
+	}
+
+	m := &Member{Node: *node, Direction: direction, Since: time.Now()}
+	ms.members[channel] = m
+	es := []MembershipEvent{{Type: NodeJoined, Service: m.Service, Member: *m}}
+	if ms.count(m.Service) == 1 {
+		es = append(es, MembershipEvent{Type: ServiceUp, Service: m.Service, Member: *m})
+	}
+	listeners := ms.listeners
+	ms.mutex.Unlock()
+
+	dispatch(listeners, es)
+}
+
+// update updates the identity of the member on channel, returns false if the
+// member is not joined.
+func (c *Cluster) update(channel core.Channel, node *Node) bool {
+	ms := &c.membership
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	m, exist := ms.members[channel]
+	if !exist {
+		return false
+	}
+	service, addr := m.Service, m.Addr
+	m.Node = *node
+	m.Service = service
+	if m.Addr == "" {
+		m.Addr = addr
+	}
+	return true
+}
+
+// leave removes the member on channel.
+func (c *Cluster) leave(channel core.Channel) {
+	if channel == nil {
+		return
+	}
+	c.drained.Delete(channel)
+
+	ms := &c.membership
+	ms.mutex.Lock()
+	m, exist := ms.members[channel]
+	if !exist {
+		ms.mutex.Unlock()
+		return
+	}
+
+	delete(ms.members, channel)
+	es := []MembershipEvent{{Type: NodeLeft, Service: m.Service, Member: *m}}
+	if ms.count(m.Service) == 0 {
+		es = append(es, MembershipEvent{Type: ServiceDown, Service: m.Service, Member: *m})
+	}
+	listeners := ms.listeners
+	ms.mutex.Unlock()
+
+	dispatch(listeners, es)
+}
+
+func (ms *membership) count(service string) int {
+	n := 0
+	for _, m := range ms.members {
+		if m.Service == service {
+			n++
+		}
+	}
+	return n
+}
+
+// emit sends the events not changing the members.
+func (c *Cluster) emit(es ...MembershipEvent) {
+	c.membership.mutex.RLock()
+	listeners := c.membership.listeners
+	c.membership.mutex.RUnlock()
+
+	dispatch(listeners, es)
+}
+
+func dispatch(listeners []MembershipListener, es []MembershipEvent) {
+	for _, e := range es {
+		text := fmt.Sprintf("%s %s %s (%s)", e.Type, e.Service, e.Member.Addr, e.Member.Direction)
+		if e.Attempt > 0 {
+			text += fmt.Sprintf(" attempt %d", e.Attempt)
+		}
+		if e.Err != nil {
+			text += fmt.Sprintf(": %v", e.Err)
+		}
+		events.Publish(Name, "%s", text)
+		for _, l := range listeners {
+			l(e)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package cluster
+
+import (
+	"github.com/amsalt/engins/monitor/metrics"
+	"github.com/amsalt/nginet/core"
+)
+
+const (
+	viaClient    = "client"
+	viaServer    = "server"
+	viaBroadcast = "broadcast"
+	viaRelay     = "relay"
+	viaNone      = "none"
+
+	directionInbound  = "inbound"
+	directionOutbound = "outbound"
+)
+
+var (
+	writes = metrics.NewCounter("engins_cluster_writes_total",
+		"Total messages written by cluster.", "service", "via", "result")
+	relayReads = metrics.NewCounter("engins_cluster_relay_reads_total",
+		"Total messages read by relay servers.", "server")
+	connections = metrics.NewGauge("engins_cluster_connections",
+		"Current connections of cluster servers and clients.", "name", "direction")
+)
+
+func recordWrite(servName string, via string, err error) {
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	writes.With(servName, via, result).Inc()
+}
+
+// relayMetricsHandler counts the messages read by relay servers.
+type relayMetricsHandler struct {
+	*core.DefaultInboundHandler
+	reads *metrics.Counter
+}
+
+func newRelayMetricsHandler(servName string) *relayMetricsHandler {
+	return &relayMetricsHandler{
+		DefaultInboundHandler: core.NewDefaultInboundHandler(),
+		reads:                 relayReads.With(servName),
+	}
+}
+
+func (h *relayMetricsHandler) OnRead(ctx *core.ChannelContext, msg interface{}) {
+	h.reads.Inc()
+	h.DefaultInboundHandler.OnRead(ctx, msg)
+}
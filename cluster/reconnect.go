@@ -0,0 +1,191 @@
+package cluster
+
+import (
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/amsalt/engins/monitor/metrics"
+	"github.com/amsalt/nginet/core"
+)
+
+// dialAddrKey is the key of channel attribute to store the address connected to.
+const dialAddrKey = "DialAddr"
+
+var reconnects = metrics.NewCounter("engins_cluster_reconnects_total",
+	"Total attempts of clients to reconnect.", "service", "result")
+
+// Backoff is the policy of reconnecting, the delay of attempt n is
+// Initial*Multiplier^(n-1) up to Max, randomized by ±Jitter of it.
+type Backoff struct {
+	Initial     time.Duration
+	Max         time.Duration
+	Multiplier  float64
+	Jitter      float64 // in (0, 1], or NoJitter.
+	MaxAttempts int     // gives up after it, 0 for never.
+}
+
+// NoJitter disables the jitter of Backoff, as Jitter not set is the default.
+const NoJitter = -1
+
+// DefaultBackoff is the Backoff used by WithReconnect if fields not set.
+var DefaultBackoff = Backoff{
+	Initial:    time.Millisecond * 500,
+	Max:        time.Second * 30,
+	Multiplier: 2,
+	Jitter:     0.2,
+}
+
+// Delay returns the delay before the attempt, starting from 1.
+func (b Backoff) Delay(attempt int) time.Duration {
+	d := float64(b.Initial)
+	for i := 1; i < attempt && d < float64(b.Max); i++ {
+		d *= b.Multiplier
+	}
+	if d > float64(b.Max) {
+		d = float64(b.Max)
+	}
+	if b.Jitter > 0 {
+		d += d * b.Jitter * (rand.Float64()*2 - 1)
+	}
+	return time.Duration(d)
+}
+
+// WithDefaults returns the backoff with the fields not set replaced by the ones
+// of DefaultBackoff, as used by WithReconnect.
+func (b Backoff) WithDefaults() Backoff {
+	if b.Initial <= 0 {
+		b.Initial = DefaultBackoff.Initial
+	}
+	if b.Max < b.Initial {
+		b.Max = DefaultBackoff.Max
+		if b.Max < b.Initial {
+			b.Max = b.Initial
+		}
+	}
+	if b.Multiplier < 1 {
+		b.Multiplier = DefaultBackoff.Multiplier
+	}
+	if b.Jitter == 0 {
+		b.Jitter = DefaultBackoff.Jitter
+	} else if b.Jitter < 0 {
+		b.Jitter = NoJitter
+	} else if b.Jitter > 1 {
+		b.Jitter = 1
+	}
+	return b
+}
+
+// connector is implemented by the clients able to connect to an address.
+type connector interface {
+	Connect(addr string) error
+}
+
+// ReconnectState is the state of a client reconnecting to a node.
+type ReconnectState struct {
+	Service string    `json:"service"`
+	Addr    string    `json:"addr"`
+	Attempt int       `json:"attempt"`
+	Next    time.Time `json:"next"` // time of the next attempt.
+	Err     string    `json:"error,omitempty"`
+}
+
+// reconnecting records the clients reconnecting by address.
+type reconnecting struct {
+	mutex  sync.Mutex
+	states map[string]*ReconnectState
+}
+
+// Reconnects returns the states of clients reconnecting.
+func (c *Cluster) Reconnects() []ReconnectState {
+	c.reconnecting.mutex.Lock()
+	defer c.reconnecting.mutex.Unlock()
+
+	states := make([]ReconnectState, 0, len(c.reconnecting.states))
+	for _, s := range c.reconnecting.states {
+		states = append(states, *s)
+	}
+	sort.Slice(states, func(i, j int) bool {
+		if states[i].Service != states[j].Service {
+			return states[i].Service < states[j].Service
+		}
+		return states[i].Addr < states[j].Addr
+	})
+	return states
+}
+
+// reconnectable returns whether the client connects by the Cluster.
+func reconnectable(client interface{}, opts *ConfigOpts) bool {
+	_, ok := client.(connector)
+	return ok && opts.Reconnect != nil
+}
+
+// reconnect connects the client to addr again by the backoff, until connected,
+// given up or the Cluster stopped.
+func (c *Cluster) reconnect(servName string, cn connector, addr string, backoff Backoff) {
+	key := servName + "@" + addr
+	rs := &c.reconnecting
+	rs.mutex.Lock()
+	if _, exist := rs.states[key]; exist {
+		rs.mutex.Unlock()
+		return
+	}
+	state := &ReconnectState{Service: servName, Addr: addr}
+	rs.states[key] = state
+	rs.mutex.Unlock()
+	defer func() {
+		rs.mutex.Lock()
+		delete(rs.states, key)
+		rs.mutex.Unlock()
+	}()
+
+	m := Member{Node: Node{Service: servName, Addr: addr}, Direction: directionOutbound}
+	for attempt := 1; backoff.MaxAttempts <= 0 || attempt <= backoff.MaxAttempts; attempt++ {
+		delay := backoff.Delay(attempt)
+		rs.mutex.Lock()
+		state.Attempt, state.Next = attempt, time.Now().Add(delay)
+		rs.mutex.Unlock()
+		c.emit(MembershipEvent{Type: Reconnecting, Service: servName, Member: m, Attempt: attempt})
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-c.done:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		m.Since = time.Now()
+		if err := cn.Connect(addr); err != nil {
+			reconnects.With(servName, "error").Inc()
+			rs.mutex.Lock()
+			state.Err = err.Error()
+			rs.mutex.Unlock()
+			c.emit(MembershipEvent{Type: ReconnectFailed, Service: servName, Member: m, Attempt: attempt, Err: err})
+			continue
+		}
+
+		reconnects.With(servName, "ok").Inc()
+		c.emit(MembershipEvent{Type: Reconnected, Service: servName, Member: m, Attempt: attempt})
+		return
+	}
+	log.Errorf("cluster gave up reconnecting to %s at %s", servName, addr)
+}
+
+// onClientDisconnect reconnects the client if the Cluster is not stopped.
+func (c *Cluster) onClientDisconnect(servName string, client interface{}, opts *ConfigOpts, ctx *core.ChannelContext) {
+	if !reconnectable(client, opts) {
+		return
+	}
+	addr, ok := ctx.Attr().Value(dialAddrKey).(string)
+	if !ok || addr == "" {
+		return
+	}
+	select {
+	case <-c.done:
+		return
+	default:
+	}
+	go c.reconnect(servName, client.(connector), addr, opts.Reconnect.WithDefaults())
+}
@@ -0,0 +1,203 @@
+package cluster
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+
+	"github.com/amsalt/engins/logging"
+	"github.com/amsalt/nginet/core"
+)
+
+// StickinessKey returns the value of stickiness key for the relayed message,
+// the messages with the same value are relayed to the same node. The message
+// is the one parsed by IDParser and not decoded, or the *RPCRequest forwarded,
+// see Cluster.FieldKey for keying on a field of the message.
+type StickinessKey func(ctx *core.ChannelContext, msg interface{}) interface{}
+
+// AttrKey returns the StickinessKey reading the channel attribute name, such
+// as DefaultRelayStickinessKey set by the gate after the user logged in.
+func AttrKey(name string) StickinessKey {
+	return func(ctx *core.ChannelContext, msg interface{}) interface{} {
+		return ctx.Attr().Value(name)
+	}
+}
+
+// FieldKey returns the StickinessKey reading the field name, such as `GuildID`,
+// of the message decoded by the type registered with its id. The payload of
+// the *RPCRequest forwarded, or of the message parsed with `Payload() []byte`
+// is decoded, the others are read as decoded. It's nil if the message can't be
+// decoded or has no such field.
+func (c *Cluster) FieldKey(name string) StickinessKey {
+	return func(ctx *core.ChannelContext, msg interface{}) interface{} {
+		return fieldOf(c.decoded(msg), name)
+	}
+}
+
+// decoded returns the message decoded for reading its fields, nil on failure.
+func (c *Cluster) decoded(msg interface{}) interface{} {
+	var msgID string
+	var payload []byte
+	switch m := msg.(type) {
+	case *RPCRequest:
+		msgID, payload = m.MsgID, m.Payload
+	case interface{ Payload() []byte }:
+		id, ok := c.messageID(msg)
+		if !ok {
+			return nil
+		}
+		msgID, payload = fmt.Sprintf("%v", id), m.Payload()
+	default:
+		return msg
+	}
+
+	decoded, err := c.decode(msgID, payload)
+	if err != nil {
+		log.Errorf("cluster decode message %s for stickiness key failed: %v", msgID, err)
+		return nil
+	}
+	return decoded
+}
+
+func fieldOf(msg interface{}, name string) interface{} {
+	v := reflect.ValueOf(msg)
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return nil
+	}
+	f := v.FieldByName(name)
+	if !f.IsValid() || !f.CanInterface() {
+		return nil
+	}
+	return f.Interface()
+}
+
+// RelayRoute routes the messages relayed by relay servers.
+type RelayRoute struct {
+	// Match selects the messages by id.
+	Match func(msgID string) bool
+
+	// Service is the service to relay to, or Pick returns it for the message,
+	// which is passed as the one of StickinessKey.
+	Service string
+	Pick    func(ctx *core.ChannelContext, msgID string, msg interface{}) (string, error)
+
+	// Key is the stickiness key of route, such as AttrKey or Cluster.FieldKey.
+	// If nil, it's AttrKey of the stickiness key of the relay server, or the
+	// key of caller for the requests forwarded.
+	Key StickinessKey
+}
+
+// MatchIDs matches the messages with the ids.
+func MatchIDs(ids ...interface{}) func(msgID string) bool {
+	set := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		set[fmt.Sprintf("%v", id)] = true
+	}
+	return func(msgID string) bool {
+		return set[msgID]
+	}
+}
+
+// MatchRange matches the messages with integer ids in [min, max].
+func MatchRange(min, max int) func(msgID string) bool {
+	return func(msgID string) bool {
+		id, err := strconv.Atoi(msgID)
+		return err == nil && id >= min && id <= max
+	}
+}
+
+// AddRelayRoute adds the route of relay servers, which takes precedence over
+// the mapping of RegisterRelayRouter. The routes are matched in the order added.
+func (c *Cluster) AddRelayRoute(route RelayRoute) {
+	if route.Match == nil || (route.Service == "" && route.Pick == nil) {
+		panic(fmt.Errorf("relay route requires Match, and Service or Pick"))
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.relayRoutes = append(c.relayRoutes, route)
+}
+
+// matchRelayRoute returns the first route matching the message.
+func (c *Cluster) matchRelayRoute(msgID string) *RelayRoute {
+	if isSystemMessage(msgID) {
+		return nil
+	}
+
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	for i := range c.relayRoutes {
+		if c.relayRoutes[i].Match(msgID) {
+			route := c.relayRoutes[i]
+			return &route
+		}
+	}
+	return nil
+}
+
+// relayRouter relays the messages matching the routes added by AddRelayRoute,
+// the others are passed to the relay handler of ngicluster.
+type relayRouter struct {
+	*core.DefaultInboundHandler
+	c        *Cluster
+	servName string
+	key      StickinessKey
+}
+
+func newRelayRouter(c *Cluster, servName string, key string) *relayRouter {
+	return &relayRouter{DefaultInboundHandler: core.NewDefaultInboundHandler(), c: c, servName: servName, key: AttrKey(key)}
+}
+
+func (h *relayRouter) OnRead(ctx *core.ChannelContext, msg interface{}) {
+	id, ok := h.c.messageID(msg)
+	if !ok {
+		h.DefaultInboundHandler.OnRead(ctx, msg)
+		return
+	}
+	msgID := fmt.Sprintf("%v", id)
+	if log.Tracing() {
+		keys := []string{logging.ConnKey(fmt.Sprint(ctx.Channel().RemoteAddr()))}
+		if user := h.key(ctx, msg); user != nil {
+			keys = append(keys, logging.UserKey(user))
+		}
+		log.With(keys...).Debugf("server %s received message %s to relay", h.servName, msgID)
+	}
+	route := h.c.matchRelayRoute(msgID)
+	if route == nil {
+		h.DefaultInboundHandler.OnRead(ctx, msg)
+		return
+	}
+
+	servName := route.Service
+	if route.Pick != nil {
+		var err error
+		if servName, err = route.Pick(ctx, msgID, msg); err != nil {
+			log.Errorf("server %s pick service to relay message %s failed: %v", h.servName, msgID, err)
+			recordWrite(servName, viaRelay, err)
+			return
+		}
+	}
+	key := route.Key
+	if key == nil {
+		key = h.key
+	}
+
+	var err error
+	if k := key(ctx, msg); k != nil {
+		err = h.c.clus.Write(servName, msg, k)
+	} else {
+		err = h.c.clus.Write(servName, msg)
+	}
+	recordWrite(servName, viaRelay, err)
+	if err != nil {
+		log.Errorf("server %s relay message %s to %s failed: %v", h.servName, msgID, servName, err)
+	}
+}
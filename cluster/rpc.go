@@ -0,0 +1,403 @@
+package cluster
+
+import (
+	encjson "encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/amsalt/engins/errs"
+	"github.com/amsalt/ngicluster"
+	"github.com/amsalt/ngicluster/balancer"
+	"github.com/amsalt/nginet/core"
+	"github.com/amsalt/nginet/encoding"
+	"github.com/amsalt/nginet/encoding/json"
+)
+
+// CallTimeout is the default timeout of Call and Go.
+var CallTimeout = time.Second * 5
+
+// RPCRequest is a protocol carrying a request of Call.
+// The request is encoded as JSON, so are the response.
+type RPCRequest struct {
+	ID      uint64 // unique in the sending node.
+	MsgID   string // registered id of the request.
+	Payload []byte
+	Timeout int64  // in milliseconds, for the relay servers to expire it.
+	Key     string // the stickiness key of caller, for the relay servers to forward it.
+}
+
+// RPCResponse is a protocol carrying the response of RPCRequest.
+type RPCResponse struct {
+	ID      uint64
+	MsgID   string
+	Payload []byte
+	Error   string
+}
+
+// call is a Call waiting for response, or a request forwarded by relay server.
+type call struct {
+	service string
+	timer   *time.Timer
+	node    core.SubChannel // picked to send the request, the only one to respond.
+
+	// of Call.
+	executor core.Executor
+	cb       func(resp interface{}, err error)
+
+	// of forwarded request.
+	origin   *core.ChannelContext
+	originID uint64
+}
+
+// calls records the calls waiting for responses.
+type calls struct {
+	seq     uint64
+	mutex   sync.Mutex
+	pending map[uint64]*call
+}
+
+func (cs *calls) add(cl *call, timeout time.Duration, expire func()) uint64 {
+	id := atomic.AddUint64(&cs.seq, 1)
+	cs.mutex.Lock()
+	cs.pending[id] = cl
+	cl.timer = time.AfterFunc(timeout, func() {
+		if cs.remove(id) != nil {
+			expire()
+		}
+	})
+	cs.mutex.Unlock()
+	return id
+}
+
+// sent records the node picked to send the request of call id.
+func (cs *calls) sent(id uint64, node core.SubChannel) {
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+
+	if cl := cs.pending[id]; cl != nil {
+		cl.node = node
+	}
+}
+
+// respond removes and returns the call responded by channel, nil if it's
+// already removed or the request isn't sent to channel.
+func (cs *calls) respond(id uint64, channel core.Channel) *call {
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+
+	cl := cs.pending[id]
+	if cl == nil || cl.node == nil || core.Channel(cl.node) != channel {
+		return nil
+	}
+	delete(cs.pending, id)
+	cl.timer.Stop()
+	return cl
+}
+
+// remove removes and returns the call, nil if it's already removed.
+func (cs *calls) remove(id uint64) *call {
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+
+	cl := cs.pending[id]
+	if cl != nil {
+		delete(cs.pending, id)
+		cl.timer.Stop()
+	}
+	return cl
+}
+
+func (c *Cluster) registerRPC() {
+	c.app.RegisterMsgByID(SystemRPCRequest, &RPCRequest{}).SetCodec(encoding.MustGetCodec(json.CodecJSON))
+	c.app.RegisterProcessorByID(SystemRPCRequest, c.rpcRequestHandler)
+	c.app.RegisterMsgByID(SystemRPCResponse, &RPCResponse{}).SetCodec(encoding.MustGetCodec(json.CodecJSON))
+	c.app.RegisterProcessorByID(SystemRPCResponse, c.rpcResponseHandler)
+}
+
+// Go sends the request to service servName by the same way as Write, and calls
+// cb with the response on the executor of the client or server of service.
+// cb receives *errs.NoRoute, *errs.CallTimeout or *errs.RemoteError on failure.
+// The messages of request and response must be registered on both sides.
+func (c *Cluster) Go(servName string, req interface{}, timeout time.Duration, cb func(resp interface{}, err error), ctx ...interface{}) {
+	c.call(servName, req, timeout, c.executorOf(servName), cb, ctx...)
+}
+
+// Call is the blocking version of Go, it must not be called on the executor
+// which runs the processors of the connections to servName.
+func (c *Cluster) Call(servName string, req interface{}, timeout time.Duration, ctx ...interface{}) (interface{}, error) {
+	type result struct {
+		resp interface{}
+		err  error
+	}
+	done := make(chan result, 1)
+	c.call(servName, req, timeout, nil, func(resp interface{}, err error) {
+		done <- result{resp, err}
+	}, ctx...)
+
+	r := <-done
+	return r.resp, r.err
+}
+
+func (c *Cluster) call(servName string, req interface{}, timeout time.Duration, executor core.Executor, cb func(resp interface{}, err error), ctx ...interface{}) {
+	if timeout <= 0 {
+		timeout = CallTimeout
+	}
+	run := func(resp interface{}, err error) {
+		if executor != nil {
+			executor.Execute(func() { cb(resp, err) })
+		} else {
+			cb(resp, err)
+		}
+	}
+
+	msgID, payload, err := c.encode(req)
+	if err != nil {
+		run(nil, err)
+		return
+	}
+
+	cl := &call{service: servName, executor: executor, cb: cb}
+	id := c.calls.add(cl, timeout, func() {
+		run(nil, errs.NewCallTimeout(servName, timeout))
+	})
+
+	r := &RPCRequest{ID: id, MsgID: msgID, Payload: payload, Timeout: int64(timeout / time.Millisecond)}
+	p := &picking{id: id, calls: &c.calls}
+	if len(ctx) > 0 && ctx[0] != nil {
+		r.Key = fmt.Sprintf("%v", ctx[0])
+		p.key = ctx[0]
+	}
+	if err := c.Write(servName, r, p); err != nil && c.calls.remove(id) != nil {
+		run(nil, err)
+	}
+}
+
+// picking is passed to the balancers in place of the stickiness key of a
+// request sent by Call or forwarded, to record the node picked to send it.
+type picking struct {
+	key   interface{}
+	id    uint64
+	calls *calls
+}
+
+// pickingBalancer records the node picked for a request by the Balancer, which
+// picks by the stickiness key in picking. All the balancers of Cluster are
+// wrapped by it, the responses are accepted from the nodes recorded only.
+type pickingBalancer struct {
+	balancer.Balancer
+}
+
+func (b *pickingBalancer) Pick(ctx interface{}) (core.SubChannel, error) {
+	p, ok := ctx.(*picking)
+	if !ok {
+		return b.Balancer.Pick(ctx)
+	}
+	node, err := b.Balancer.Pick(p.key)
+	if err == nil {
+		p.calls.sent(p.id, node)
+	}
+	return node, err
+}
+
+// Responder replies the response to the caller of a request sent by Call.
+type Responder struct {
+	c    *Cluster
+	ctx  *core.ChannelContext
+	id   uint64
+	once sync.Once
+}
+
+// ResponderOf returns the Responder in the args of processor, nil if the
+// message is not a request sent by Call.
+func ResponderOf(args []interface{}) *Responder {
+	for _, arg := range args {
+		if r, ok := arg.(*Responder); ok {
+			return r
+		}
+	}
+	return nil
+}
+
+// Reply replies the response, only the first reply or failure is sent.
+func (r *Responder) Reply(resp interface{}) error {
+	msgID, payload, err := r.c.encode(resp)
+	if err != nil {
+		return err
+	}
+	return r.write(&RPCResponse{ID: r.id, MsgID: msgID, Payload: payload})
+}
+
+// Fail replies the error, which is received by the caller as *errs.RemoteError.
+func (r *Responder) Fail(err error) error {
+	return r.write(&RPCResponse{ID: r.id, Error: err.Error()})
+}
+
+func (r *Responder) write(resp *RPCResponse) error {
+	err := fmt.Errorf("request %d has been replied", r.id)
+	r.once.Do(func() {
+		err = r.ctx.Write(resp)
+	})
+	return err
+}
+
+func (c *Cluster) rpcRequestHandler(ctx *core.ChannelContext, msg interface{}, args ...interface{}) {
+	req, ok := msg.(*RPCRequest)
+	if !ok {
+		return
+	}
+
+	if route, relay := c.relayRoute(ctx, req.MsgID); relay {
+		c.forward(ctx, route, req)
+		return
+	}
+
+	r := &Responder{c: c, ctx: ctx, id: req.ID}
+	m, err := c.decode(req.MsgID, req.Payload)
+	if err == nil {
+		err = c.app.Process(ctx, req.MsgID, m, r)
+	}
+	if err != nil {
+		log.Errorf("cluster process request %v failed: %v", req.MsgID, err)
+		r.Fail(err)
+	}
+}
+
+// forward forwards the request received by relay server to the service of
+// route, sticky by the key of route or the key of caller in its string form,
+// and the response back to the origin.
+func (c *Cluster) forward(origin *core.ChannelContext, route *RelayRoute, req *RPCRequest) {
+	servName := route.Service
+	if route.Pick != nil {
+		var err error
+		if servName, err = route.Pick(origin, req.MsgID, req); err != nil {
+			log.Errorf("cluster pick service to forward request %s failed: %v", req.MsgID, err)
+			origin.Write(&RPCResponse{ID: req.ID, Error: err.Error()})
+			return
+		}
+	}
+	p := &picking{calls: &c.calls}
+	if route.Key != nil {
+		p.key = route.Key(origin, req)
+	} else if req.Key != "" {
+		p.key = req.Key
+	}
+
+	timeout := time.Duration(req.Timeout) * time.Millisecond
+	if timeout <= 0 {
+		timeout = CallTimeout
+	}
+
+	cl := &call{service: servName, origin: origin, originID: req.ID}
+	id := c.calls.add(cl, timeout, func() {})
+	p.id = id
+
+	fwd := *req
+	fwd.ID = id
+	if err := c.Write(servName, &fwd, p); err != nil && c.calls.remove(id) != nil {
+		origin.Write(&RPCResponse{ID: req.ID, Error: err.Error()})
+	}
+}
+
+func (c *Cluster) rpcResponseHandler(ctx *core.ChannelContext, msg interface{}, args ...interface{}) {
+	resp, ok := msg.(*RPCResponse)
+	if !ok {
+		return
+	}
+
+	cl := c.calls.respond(resp.ID, ctx.Channel())
+	if cl == nil {
+		log.Debugf("cluster response %d from %v expired or not requested", resp.ID, ctx.Channel().RemoteAddr())
+		return
+	}
+
+	if cl.origin != nil {
+		back := *resp
+		back.ID = cl.originID
+		cl.origin.Write(&back)
+		return
+	}
+
+	var result interface{}
+	var err error
+	if resp.Error != "" {
+		err = errs.NewRemoteError(cl.service, resp.Error)
+	} else {
+		result, err = c.decode(resp.MsgID, resp.Payload)
+	}
+
+	if cl.executor != nil {
+		cl.executor.Execute(func() { cl.cb(result, err) })
+	} else {
+		cl.cb(result, err)
+	}
+}
+
+// relayRoute returns the route to forward the request to, if it's received by
+// a relay server and a RelayRoute or a relay router is registered for it.
+func (c *Cluster) relayRoute(ctx *core.ChannelContext, msgID string) (*RelayRoute, bool) {
+	server, ok := ctx.Attr().Value(AssociatedServerKey).(*ngicluster.Server)
+	if !ok {
+		return nil, false
+	}
+
+	c.mutex.RLock()
+	relay := c.relays[server]
+	servName, routed := c.routes[msgID]
+	c.mutex.RUnlock()
+
+	if !relay {
+		return nil, false
+	}
+	if route := c.matchRelayRoute(msgID); route != nil {
+		return route, true
+	}
+	if routed {
+		return &RelayRoute{Service: servName}, true
+	}
+	return nil, false
+}
+
+func (c *Cluster) encode(msg interface{}) (string, []byte, error) {
+	meta := c.app.GetMetaByMsg(msg)
+	if meta == nil {
+		return "", nil, fmt.Errorf("message %T not registered", msg)
+	}
+	payload, err := encjson.Marshal(msg)
+	if err != nil {
+		return "", nil, err
+	}
+	return fmt.Sprintf("%v", meta.ID()), payload, nil
+}
+
+func (c *Cluster) decode(msgID string, payload []byte) (interface{}, error) {
+	typ, ok := c.app.MessageType(msgID)
+	if !ok {
+		return nil, fmt.Errorf("message %s not registered", msgID)
+	}
+
+	ptr := typ.Kind() == reflect.Ptr
+	if ptr {
+		typ = typ.Elem()
+	}
+	v := reflect.New(typ)
+	if err := encjson.Unmarshal(payload, v.Interface()); err != nil {
+		return nil, err
+	}
+	if ptr {
+		return v.Interface(), nil
+	}
+	return v.Elem().Interface(), nil
+}
+
+func (c *Cluster) executorOf(servName string) core.Executor {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	if e, ok := c.executors[servName]; ok {
+		return e
+	}
+	return c.executors[""]
+}
@@ -0,0 +1,205 @@
+package cluster
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/amsalt/engins/database"
+	"github.com/amsalt/engins/monitor/metrics"
+	"github.com/amsalt/ngicluster/resolver"
+	"github.com/amsalt/nginet/core"
+	"github.com/go-redis/redis"
+)
+
+// DefaultStorageTTL is the time a stickiness key kept in redis after last used.
+const DefaultStorageTTL = time.Hour
+
+// DefaultStorageCacheTTL is the time a stickiness key cached in process.
+const DefaultStorageCacheTTL = time.Second * 10
+
+// StorageKeyPrefix is the prefix of the redis keys of RedisStorage.
+var StorageKeyPrefix = "engins:stickiness:"
+
+// nodePrefix marks the stored values of channels, stored by their node keys.
+const nodePrefix = "@"
+
+var storageLookups = metrics.NewCounter("engins_cluster_storage_lookups_total",
+	"Total stickiness keys looked up in storages.", "service", "result")
+
+// RedisStorage is a balancer.Storage keeping the nodes picked by stickiness
+// keys in redis, shared by the processes routing to the same service, with a
+// local cache in front of it.
+// The channels stored are kept by node ID, or address if not identified with
+// ID, and got back from the nodes resolved, so it works with stickiness
+// balancer as well as the balancers of cluster.
+type RedisStorage struct {
+	client   *database.RedisClient
+	servName string
+	resolver resolver.Resolver
+	ttl      time.Duration
+
+	// CacheTTL is the time a key cached, DefaultStorageCacheTTL by default.
+	// The nodes picked by the other processes take effect after it.
+	CacheTTL time.Duration
+
+	mutex sync.Mutex
+	cache map[string]cached
+	swept time.Time
+}
+
+type cached struct {
+	val    string
+	expire time.Time
+}
+
+// NewRedisStorage creates a RedisStorage of the nodes of servName resolved by r,
+// the keys expire in ttl after last used, DefaultStorageTTL if not positive.
+func NewRedisStorage(client *database.RedisClient, servName string, r resolver.Resolver, ttl time.Duration) *RedisStorage {
+	if ttl <= 0 {
+		ttl = DefaultStorageTTL
+	}
+	return &RedisStorage{
+		client:   client,
+		servName: servName,
+		resolver: r,
+		ttl:      ttl,
+		CacheTTL: DefaultStorageCacheTTL,
+		cache:    make(map[string]cached),
+	}
+}
+
+func (s *RedisStorage) key(key interface{}) string {
+	return fmt.Sprintf("%s%s:%v", StorageKeyPrefix, s.servName, key)
+}
+
+// Get returns the value of key, from the cache if not expired, otherwise from redis.
+func (s *RedisStorage) Get(key interface{}) interface{} {
+	k := s.key(key)
+	s.mutex.Lock()
+	c, ok := s.cache[k]
+	if ok && time.Now().After(c.expire) {
+		delete(s.cache, k)
+		ok = false
+	}
+	s.mutex.Unlock()
+	if ok {
+		storageLookups.With(s.servName, "cache").Inc()
+		return s.decode(c.val)
+	}
+
+	val, err := s.client.GetSync(k)
+	if err == redis.Nil {
+		storageLookups.With(s.servName, "miss").Inc()
+		return nil
+	}
+	if err != nil {
+		storageLookups.With(s.servName, "error").Inc()
+		log.Errorf("storage of %s get %s failed: %v", s.servName, k, err)
+		return nil
+	}
+	storageLookups.With(s.servName, "redis").Inc()
+	if s.resolved(val) {
+		s.client.Expire(k, s.ttl)
+	}
+	s.store(k, val)
+	return s.decode(val)
+}
+
+// Set sets the value of key if it's not set by the other processes yet,
+// otherwise the value set by them is cached and got later, so the processes
+// agree on the node of key. The value of a node not resolved by this process
+// is kept if refreshed by the others recently, as it may be resolved by them,
+// otherwise it's replaced.
+func (s *RedisStorage) Set(key interface{}, val interface{}) {
+	k, v := s.key(key), s.encode(val)
+	ok, err := s.client.GetRawClient().SetNX(k, v, s.ttl).Result()
+	if err != nil {
+		log.Errorf("storage of %s set %s failed: %v", s.servName, k, err)
+	} else if !ok {
+		if set, err := s.client.GetSync(k); err == nil && s.resolved(set) {
+			v = set
+			s.client.Expire(k, s.ttl)
+		} else if err == nil && s.refreshed(k) {
+			v = set
+		} else if err := s.client.GetRawClient().Set(k, v, s.ttl).Err(); err != nil {
+			log.Errorf("storage of %s set %s failed: %v", s.servName, k, err)
+		}
+	}
+	s.store(k, v)
+}
+
+// refreshed returns whether the key is refreshed in twice CacheTTL, as the
+// processes resolving its node refresh it once got from redis after cached.
+func (s *RedisStorage) refreshed(k string) bool {
+	remaining, err := s.client.GetRawClient().PTTL(k).Result()
+	return err == nil && remaining > 0 && s.ttl-remaining < 2*s.CacheTTL
+}
+
+// Del deletes the key from the cache and redis.
+func (s *RedisStorage) Del(key interface{}) {
+	k := s.key(key)
+	s.mutex.Lock()
+	delete(s.cache, k)
+	s.mutex.Unlock()
+	s.client.Del(k)
+}
+
+func (s *RedisStorage) store(k string, v string) {
+	now := time.Now()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if now.Sub(s.swept) > s.CacheTTL {
+		for key, c := range s.cache {
+			if now.After(c.expire) {
+				delete(s.cache, key)
+			}
+		}
+		s.swept = now
+	}
+	s.cache[k] = cached{val: v, expire: now.Add(s.CacheTTL)}
+}
+
+func (s *RedisStorage) encode(val interface{}) string {
+	switch v := val.(type) {
+	case string:
+		return v
+	case core.Channel:
+		return nodePrefix + nodeKey(v)
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+// resolved returns whether the node key of val is resolved.
+func (s *RedisStorage) resolved(val string) bool {
+	if s.resolver == nil {
+		return true
+	}
+	key := strings.TrimPrefix(val, nodePrefix)
+	for _, node := range s.resolver.Resolve(s.servName) {
+		if nodeKey(node) == key {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *RedisStorage) decode(val string) interface{} {
+	if !strings.HasPrefix(val, nodePrefix) {
+		return val
+	}
+	key := strings.TrimPrefix(val, nodePrefix)
+	if s.resolver == nil {
+		return nil
+	}
+	for _, node := range s.resolver.Resolve(s.servName) {
+		if nodeKey(node) == key {
+			return node
+		}
+	}
+	return nil
+}
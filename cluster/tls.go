@@ -0,0 +1,568 @@
+package cluster
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/amsalt/engins/monitor/events"
+	"github.com/amsalt/nginet/core"
+)
+
+// PeerKey is the key of channel attribute to store the *Peer of TLS connection.
+const PeerKey = "Peer"
+
+// DefaultReloadInterval is the interval to check the certificate files for changes.
+const DefaultReloadInterval = time.Minute
+
+// TLSConfig configures TLS of the servers and clients built with WithTLS.
+//
+// The connections of a server are terminated by TLS on the address to listen,
+// and forwarded to the server listening on loopback, which accepts only the
+// connections forwarded. A client connects by Cluster.ConnectTLS, which dials
+// the node by TLS and hands the connection to the client over loopback.
+type TLSConfig struct {
+	CertFile string // certificate in PEM, presented to the peers, optional for clients.
+	KeyFile  string // private key of certificate in PEM.
+
+	// CAFile is the CA to verify the peers. Servers require the certificates
+	// of clients only if it or ServiceCAs is set. Clients verify the servers
+	// by the system CAs if neither is set.
+	CAFile string
+
+	// ServiceCAs are the CA files by service name. A client certificate
+	// verified by the CA of service can only identify itself as the service.
+	// Clients verify the servers of service by its CA rather than CAFile.
+	ServiceCAs map[string]string
+
+	ServerName     string        // name to verify the servers, host of address by default.
+	ReloadInterval time.Duration // interval to reload the changed files, DefaultReloadInterval by default.
+}
+
+// Peer is the identity of the certificate presented by the other side.
+type Peer struct {
+	Service     string    `json:"service,omitempty"` // the service whose CA verified the certificate.
+	CommonName  string    `json:"common_name"`
+	DNSNames    []string  `json:"dns_names,omitempty"`
+	URIs        []string  `json:"uris,omitempty"`
+	Fingerprint string    `json:"fingerprint"` // SHA-256 of the certificate.
+	NotAfter    time.Time `json:"not_after"`
+}
+
+func newPeer(state tls.ConnectionState, service string) *Peer {
+	if len(state.PeerCertificates) == 0 {
+		return nil
+	}
+	cert := state.PeerCertificates[0]
+	sum := sha256.Sum256(cert.Raw)
+	peer := &Peer{
+		Service:     service,
+		CommonName:  cert.Subject.CommonName,
+		DNSNames:    cert.DNSNames,
+		Fingerprint: hex.EncodeToString(sum[:]),
+		NotAfter:    cert.NotAfter,
+	}
+	for _, uri := range cert.URIs {
+		peer.URIs = append(peer.URIs, uri.String())
+	}
+	return peer
+}
+
+// PeerOf returns the identity of the TLS peer on the channel of ctx, nil if
+// the connection is not TLS or the peer presented no certificate.
+func (c *Cluster) PeerOf(ctx *core.ChannelContext) *Peer {
+	if peer, ok := ctx.Attr().Value(PeerKey).(*Peer); ok {
+		return peer
+	}
+	if ctx.Channel() == nil {
+		return nil
+	}
+	if v, ok := c.peers.Load(fmt.Sprintf("%v", ctx.Channel().RemoteAddr())); ok && v.(*Peer) != nil {
+		peer := v.(*Peer)
+		ctx.Attr().SetValue(PeerKey, peer)
+		return peer
+	}
+	return nil
+}
+
+// forwarded returns whether the channel of ctx is forwarded by TLS.
+func (c *Cluster) forwarded(ctx *core.ChannelContext) bool {
+	_, ok := c.peers.Load(fmt.Sprintf("%v", ctx.Channel().RemoteAddr()))
+	return ok
+}
+
+// certStore holds the certificate and CA pools of a TLSConfig, and reloads
+// them when the files changed.
+type certStore struct {
+	cfg TLSConfig
+
+	mutex    sync.RWMutex
+	cert     *tls.Certificate
+	roots    *x509.CertPool
+	services map[string]*x509.CertPool
+	modTimes map[string]time.Time
+	checked  time.Time
+}
+
+func newCertStore(cfg TLSConfig) (*certStore, error) {
+	if cfg.ReloadInterval <= 0 {
+		cfg.ReloadInterval = DefaultReloadInterval
+	}
+	s := &certStore{cfg: cfg}
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *certStore) files() []string {
+	files := []string{s.cfg.CertFile, s.cfg.KeyFile, s.cfg.CAFile}
+	for _, f := range s.cfg.ServiceCAs {
+		files = append(files, f)
+	}
+	return files
+}
+
+// Reload loads the files, the loaded ones are kept on failure.
+func (s *certStore) Reload() error {
+	var cert tls.Certificate
+	var err error
+	if s.cfg.CertFile != "" || s.cfg.KeyFile != "" {
+		if cert, err = tls.LoadX509KeyPair(s.cfg.CertFile, s.cfg.KeyFile); err != nil {
+			return fmt.Errorf("load certificate %s failed: %v", s.cfg.CertFile, err)
+		}
+	}
+	var roots *x509.CertPool
+	if s.cfg.CAFile != "" {
+		if roots, err = loadPool(s.cfg.CAFile); err != nil {
+			return err
+		}
+	}
+	services := make(map[string]*x509.CertPool)
+	for name, f := range s.cfg.ServiceCAs {
+		if services[name], err = loadPool(f); err != nil {
+			return err
+		}
+	}
+
+	modTimes := make(map[string]time.Time)
+	for _, f := range s.files() {
+		if info, err := os.Stat(f); err == nil {
+			modTimes[f] = info.ModTime()
+		}
+	}
+
+	s.mutex.Lock()
+	s.cert, s.roots, s.services, s.modTimes = &cert, roots, services, modTimes
+	s.checked = time.Now()
+	s.mutex.Unlock()
+	return nil
+}
+
+func loadPool(file string) (*x509.CertPool, error) {
+	pem, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("load CA %s failed: %v", file, err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificate found in CA %s", file)
+	}
+	return pool, nil
+}
+
+// check reloads the files if any changed since last check ReloadInterval ago.
+func (s *certStore) check() {
+	s.mutex.Lock()
+	if time.Since(s.checked) < s.cfg.ReloadInterval {
+		s.mutex.Unlock()
+		return
+	}
+	s.checked = time.Now()
+	modTimes := s.modTimes
+	s.mutex.Unlock()
+
+	for _, f := range s.files() {
+		if f == "" {
+			continue
+		}
+		if info, err := os.Stat(f); err == nil && !info.ModTime().Equal(modTimes[f]) {
+			if err := s.Reload(); err != nil {
+				log.Errorf("cluster reload certificates failed: %v", err)
+			} else {
+				log.Infof("cluster certificates reloaded for %s changed", f)
+			}
+			return
+		}
+	}
+}
+
+func (s *certStore) certificate() *tls.Certificate {
+	s.check()
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.cert
+}
+
+// mutual returns whether the clients are required to present certificates.
+func (s *certStore) mutual() bool {
+	return s.cfg.CAFile != "" || len(s.cfg.ServiceCAs) > 0
+}
+
+// serverConfig returns the config of a connection, service is set to the
+// service whose CA verified the client certificate.
+func (s *certStore) serverConfig(service *string) *tls.Config {
+	cfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return s.certificate(), nil
+		},
+	}
+	if s.mutual() {
+		cfg.ClientAuth = tls.RequireAnyClientCert
+		cfg.VerifyPeerCertificate = func(raw [][]byte, _ [][]*x509.Certificate) error {
+			name, err := s.verifyClient(raw)
+			*service = name
+			return err
+		}
+	}
+	return cfg
+}
+
+// verifyClient verifies the client certificate by the CA of services, then CAFile.
+func (s *certStore) verifyClient(raw [][]byte) (string, error) {
+	certs := make([]*x509.Certificate, len(raw))
+	for i, b := range raw {
+		cert, err := x509.ParseCertificate(b)
+		if err != nil {
+			return "", err
+		}
+		certs[i] = cert
+	}
+	if len(certs) == 0 {
+		return "", fmt.Errorf("no client certificate")
+	}
+
+	opts := x509.VerifyOptions{
+		Intermediates: x509.NewCertPool(),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	for _, cert := range certs[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	names := make([]string, 0, len(s.services))
+	for name := range s.services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		opts.Roots = s.services[name]
+		if _, err := certs[0].Verify(opts); err == nil {
+			return name, nil
+		}
+	}
+	if s.roots != nil {
+		opts.Roots = s.roots
+		if _, err := certs[0].Verify(opts); err == nil {
+			return "", nil
+		}
+	}
+	return "", fmt.Errorf("client certificate %s not trusted", certs[0].Subject.CommonName)
+}
+
+// clientConfig returns the config to connect to the server of service at addr.
+func (s *certStore) clientConfig(servName string, addr string) *tls.Config {
+	cfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: s.cfg.ServerName,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return s.certificate(), nil
+		},
+	}
+	if cfg.ServerName == "" {
+		if host, _, err := net.SplitHostPort(addr); err == nil {
+			cfg.ServerName = host
+		}
+	}
+
+	s.check()
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	if pool, ok := s.services[servName]; ok {
+		cfg.RootCAs = pool
+	} else {
+		cfg.RootCAs = s.roots
+	}
+	return cfg
+}
+
+// tunnel forwards the connections accepted by listener.
+type tunnel struct {
+	listener net.Listener
+	wg       sync.WaitGroup
+}
+
+// serve accepts the connections and forwards them by forward until closed.
+func (t *tunnel) serve(forward func(conn net.Conn)) {
+	for {
+		conn, err := t.listener.Accept()
+		if err != nil {
+			return
+		}
+		t.wg.Add(1)
+		go func() {
+			defer t.wg.Done()
+			forward(conn)
+		}()
+	}
+}
+
+func (t *tunnel) close() {
+	t.listener.Close()
+}
+
+// pipe copies between the connections until either side closed.
+func pipe(a, b net.Conn) {
+	done := make(chan struct{}, 2)
+	cp := func(dst, src net.Conn) {
+		io.Copy(dst, src)
+		done <- struct{}{}
+	}
+	go cp(a, b)
+	go cp(b, a)
+	<-done
+	a.Close()
+	b.Close()
+	<-done
+}
+
+// tlsServer terminates TLS of a server on addr, and forwards the connections
+// to backend on which the server listens.
+type tlsServer struct {
+	name    string
+	store   *certStore
+	addr    string
+	backend string // picked on started.
+	tunnel  *tunnel
+	accepts sync.Map // chan closed when accepted by the server, by the address of connection.
+}
+
+// acceptance returns the chan closed when the connection from addr accepted by the server.
+func (s *tlsServer) acceptance(addr string) chan struct{} {
+	ch, _ := s.accepts.LoadOrStore(addr, make(chan struct{}))
+	return ch.(chan struct{})
+}
+
+// accepted is called by the server on the connection from addr accepted.
+func (s *tlsServer) accepted(addr string) {
+	close(s.acceptance(addr))
+}
+
+// loopbackAddr returns a free address on loopback.
+func loopbackAddr() (string, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	defer l.Close()
+	return l.Addr().String(), nil
+}
+
+func (c *Cluster) startTLSServer(s *tlsServer) error {
+	l, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return err
+	}
+	s.tunnel = &tunnel{listener: l}
+	go s.tunnel.serve(func(conn net.Conn) {
+		c.terminateTLS(s, conn)
+	})
+	log.Infof("server %s serving TLS on %v for %s", s.name, l.Addr(), s.backend)
+	return nil
+}
+
+func (c *Cluster) terminateTLS(s *tlsServer, conn net.Conn) {
+	servName := s.name
+	var service string
+	tlsConn := tls.Server(conn, s.store.serverConfig(&service))
+	conn.SetDeadline(time.Now().Add(HandshakeTimeout))
+	if err := tlsConn.Handshake(); err != nil {
+		log.Errorf("server %s TLS handshake with %v failed: %v", servName, conn.RemoteAddr(), err)
+		events.Publish(Name, "server %s TLS handshake with %v failed: %v", servName, conn.RemoteAddr(), err)
+		authFailures.With(servName).Inc()
+		conn.Close()
+		return
+	}
+	conn.SetDeadline(time.Time{})
+
+	backend, err := net.DialTimeout("tcp", s.backend, HandshakeTimeout)
+	if err != nil {
+		log.Errorf("server %s forward TLS connection failed: %v", servName, err)
+		tlsConn.Close()
+		return
+	}
+
+	// forwards only to the server of the Cluster, rather than a process
+	// listening on backend instead of it.
+	key := backend.LocalAddr().String()
+	timer := time.NewTimer(HandshakeTimeout)
+	defer timer.Stop()
+	select {
+	case <-s.acceptance(key):
+	case <-timer.C:
+		log.Errorf("server %s forward TLS connection failed: not accepted on %s", servName, s.backend)
+		s.accepts.Delete(key)
+		backend.Close()
+		tlsConn.Close()
+		return
+	}
+
+	c.peers.Store(key, newPeer(tlsConn.ConnectionState(), service))
+	defer c.peers.Delete(key)
+	pipe(tlsConn, backend)
+}
+
+// tlsClient connects a client to the nodes of service by TLS.
+type tlsClient struct {
+	c      *Cluster
+	name   string
+	store  *certStore
+	client connector
+	dials  sync.Map // the address of node by the address on loopback the client connected to.
+}
+
+// ConnectTLS connects the client of servName built with WithTLS to the node at
+// addr by TLS, which verifies the node by the CA of servName or CAFile, and
+// presents the certificate of client if any. The client is reconnected with
+// WithReconnect, by TLS as well.
+func (c *Cluster) ConnectTLS(servName string, addr string) error {
+	c.mutex.RLock()
+	tc := c.tlsClients[servName]
+	c.mutex.RUnlock()
+	if tc == nil {
+		return fmt.Errorf("no client of %s built with TLS", servName)
+	}
+	return tc.Connect(addr)
+}
+
+// Connect implements connector, the client connects to a listener on loopback
+// accepting only its connection, which is forwarded to the node connected by TLS.
+func (tc *tlsClient) Connect(addr string) error {
+	dialer := &net.Dialer{Timeout: HandshakeTimeout}
+	remote, err := tls.DialWithDialer(dialer, "tcp", addr, tc.store.clientConfig(tc.name, addr))
+	if err != nil {
+		log.Errorf("client of %s TLS connect to %s failed: %v", tc.name, addr, err)
+		events.Publish(Name, "client of %s TLS connect to %s failed: %v", tc.name, addr, err)
+		return err
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		remote.Close()
+		return err
+	}
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		defer close(accepted)
+		conn, err := l.Accept()
+		l.Close()
+		if err == nil {
+			accepted <- conn
+		}
+	}()
+
+	local := l.Addr().String()
+	tc.c.peers.Store(local, newPeer(remote.ConnectionState(), tc.name))
+	tc.dials.Store(local, addr)
+	forget := func() {
+		tc.c.peers.Delete(local)
+		tc.dials.Delete(local)
+	}
+	if err := tc.client.Connect(local); err != nil {
+		l.Close()
+		remote.Close()
+		forget()
+		return err
+	}
+	conn, ok := <-accepted
+	if !ok {
+		remote.Close()
+		forget()
+		return fmt.Errorf("client of %s connection to %s not accepted on %s", tc.name, addr, local)
+	}
+	go func() {
+		defer forget()
+		pipe(conn, remote)
+	}()
+	return nil
+}
+
+// nodeAddr returns the address of node the client connected to local by TLS,
+// local itself if it's not.
+func (tc *tlsClient) nodeAddr(local string) string {
+	if addr, ok := tc.dials.Load(local); ok {
+		return addr.(string)
+	}
+	return local
+}
+
+// tlsGate closes the connections of a TLS server not forwarded by TLS, such as
+// connected to the loopback address directly, before any message dispatched.
+type tlsGate struct {
+	*core.DefaultInboundHandler
+	c        *Cluster
+	servName string
+}
+
+func newTLSGate(c *Cluster, servName string) *tlsGate {
+	return &tlsGate{DefaultInboundHandler: core.NewDefaultInboundHandler(), c: c, servName: servName}
+}
+
+func (g *tlsGate) OnRead(ctx *core.ChannelContext, msg interface{}) {
+	if !g.c.forwarded(ctx) {
+		g.c.rejectAuth(g.servName, ctx.Channel(), fmt.Sprintf("%v", ctx.Channel().RemoteAddr()), "connection not forwarded by TLS")
+		return
+	}
+	g.DefaultInboundHandler.OnRead(ctx, msg)
+}
+
+// checkPeer checks the service identified is the one whose CA verified the
+// client certificate, if it's verified by the CA of a service.
+func (c *Cluster) checkPeer(ctx *core.ChannelContext, identify *IdentifySelf) error {
+	peer := c.PeerOf(ctx)
+	if peer == nil || peer.Service == "" || peer.Service == identify.Name {
+		return nil
+	}
+	return fmt.Errorf("certificate of %s identified as %s", peer.Service, identify.Name)
+}
+
+// Reload reloads the certificates of TLS.
+func (c *Cluster) Reload() error {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	var firstErr error
+	for _, s := range c.tlsServers {
+		if err := s.store.Reload(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	for _, tc := range c.tlsClients {
+		if err := tc.store.Reload(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -1,14 +1,31 @@
 package components
 
 import (
+	"context"
+	"fmt"
 	"sync"
+	"time"
 
-	"github.com/amsalt/nginet/safe"
+	"github.com/amsalt/engins/errs"
+	"github.com/amsalt/engins/logging"
 )
 
-// ensure all components exit.
-var wg sync.WaitGroup
-var components []Component
+var log = logging.New("components")
+
+// Timeouts of each lifecycle phase, which apply to every component.
+var (
+	InitTimeout  = time.Second * 10
+	StartTimeout = time.Second * 30
+	StopTimeout  = time.Second * 30
+)
+
+// LegacyStartGrace is the time waited for Start of a Component not being a
+// ContextComponent to return before starting the next one. Such a component
+// is ready once starting, since its Start may block as long as it runs.
+var LegacyStartGrace = time.Millisecond * 100
+
+// Default is the default Manager used by the package level functions.
+var Default = NewManager()
 
 // Component defines the lifecycle process.
 type Component interface {
@@ -18,27 +35,495 @@ type Component interface {
 	Stop()
 }
 
-// Register registers new component for run.
+// ContextComponent is an optional interface for a Component to control its
+// lifecycle with context and report errors. When implemented, these methods
+// are called instead of Init, Start and Stop.
+type ContextComponent interface {
+	// InitContext initializes the component, ctx is done when InitTimeout exceeded.
+	InitContext(ctx context.Context) error
+
+	// StartContext starts the component and may block as long as the component runs.
+	// ready must be called once the component is able to serve, returning an error
+	// before that aborts the startup. ctx is done when the component is stopped.
+	StartContext(ctx context.Context, ready func()) error
+
+	// StopContext stops the component, ctx is done when StopTimeout of stopping
+	// all components exceeded.
+	StopContext(ctx context.Context) error
+}
+
+// Named is an optional interface for a Component to declare its name.
+// The name is used by other components to declare a dependency on it.
+// A component not implementing Named is named after its type.
+type Named interface {
+	Name() string
+}
+
+// Dependent is an optional interface for a Component to declare the names
+// of components which must be started before and stopped after it.
+type Dependent interface {
+	Requires() []string
+}
+
+// Manager manages the lifecycle of a set of components.
+type Manager struct {
+	mutex      sync.Mutex
+	components []Component
+	requires   map[string][]string // dependencies declared by Require.
+
+	// started records the components in the order they were started,
+	// so that Stop can tear them down in exact reverse order.
+	started []*entry
+
+	// entries records all the components of last Run in the order of starting.
+	entries      []*entry
+	entriesMutex sync.RWMutex
+
+	listenerMutex sync.RWMutex
+	listeners     []StateListener
+
+	// fatal receives the error when a critical component can't be restarted.
+	fatal chan error
+}
+
+// NewManager creates a new Manager without components.
+func NewManager() *Manager {
+	return &Manager{
+		requires: make(map[string][]string),
+		fatal:    make(chan error, 1),
+	}
+}
+
+// entry is a running component.
+type entry struct {
+	Component
+	m      *Manager
+	name   string
+	policy RestartPolicy
+	quit   chan struct{} // closed when the component is stopping.
+
+	mu       sync.Mutex
+	state    State
+	since    time.Time
+	stopping bool
+	cancel   context.CancelFunc // cancels the context of StartContext.
+	restarts []time.Time        // restarts within the window of policy.
+	total    int                // total restarts.
+	lastErr  error
+}
+
+func newEntry(m *Manager, c Component) *entry {
+	return &entry{
+		Component: c,
+		m:         m,
+		name:      NameOf(c),
+		policy:    policyOf(c),
+		quit:      make(chan struct{}),
+		since:     time.Now(),
+	}
+}
+
+// Require is a helper method by using Default manager.
+func Require(name string, deps ...string) {
+	Default.Require(name, deps...)
+}
+
+// Register is a helper method by using Default manager.
 func Register(c ...Component) {
-	components = append(components, c...)
+	Default.Register(c...)
+}
+
+// Run is a helper method by using Default manager.
+func Run() error {
+	return Default.Run()
+}
+
+// Stop is a helper method by using Default manager.
+func Stop() error {
+	return Default.Stop()
+}
+
+// Statuses is a helper method by using Default manager.
+func Statuses() []Status {
+	return Default.Statuses()
+}
+
+// Running is a helper method by using Default manager.
+func Running() []Component {
+	return Default.Running()
+}
+
+// Order is a helper method by using Default manager.
+func Order() ([]string, error) {
+	return Default.Order()
+}
+
+// Require declares that the component named `name` requires the components
+// named `deps`, in addition to the ones it declares by itself.
+// It's useful to order components which can not be changed, such as the
+// ones provided by engins.
+func (m *Manager) Require(name string, deps ...string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.requires[name] = append(m.requires[name], deps...)
+}
+
+// Register registers new component for run.
+func (m *Manager) Register(c ...Component) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.components = append(m.components, c...)
+}
+
+// Run sorts the components by their dependencies, initializes them and starts
+// them one by one, each component is waited until ready before the next starts.
+// If any component fails, the already started ones are stopped in reverse order
+// and the error is returned.
+func (m *Manager) Run() error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	ordered, err := m.sortComponents()
+	if err != nil {
+		return err
+	}
+
+	es := make([]*entry, len(ordered))
+	for i, c := range ordered {
+		es[i] = newEntry(m, c)
+	}
+	m.entriesMutex.Lock()
+	m.entries = es
+	m.entriesMutex.Unlock()
+
+	for _, e := range es {
+		log.Debugf("init component %s", e.name)
+		if err := initComponent(e.Component); err != nil {
+			e.setState(StateFailed)
+			return errs.NewComponentFailure(e.name, "init", err)
+		}
+	}
+
+	for _, e := range es {
+		log.Debugf("start component %s", e.name)
+		exit, err := e.run()
+		if err != nil {
+			log.Errorf("start component %s failed: %v, rollback started components", e.name, err)
+			if stopErr := m.stopStarted(); stopErr != nil {
+				log.Errorf("rollback components failed: %v", stopErr)
+			}
+			return errs.NewComponentFailure(e.name, "start", err)
+		}
+		m.started = append(m.started, e)
+		go e.supervise(exit)
+	}
+	return nil
 }
 
-// Run initializes the components and starts.
-func Run() {
-	for _, c := range components {
-		c.Init()
+// Stop stops all started components in reverse order of starting.
+// All components are given StopTimeout to stop, the ones that don't stop
+// in time are reported with an *errs.StopTimeout error.
+func (m *Manager) Stop() error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.stopStarted()
+}
+
+// Statuses returns the status of components of last Run in the order of starting.
+func (m *Manager) Statuses() []Status {
+	m.entriesMutex.RLock()
+	defer m.entriesMutex.RUnlock()
+
+	statuses := make([]Status, len(m.entries))
+	for i, e := range m.entries {
+		statuses[i] = e.status()
+	}
+	return statuses
+}
+
+// Running returns the started components in the order of starting.
+func (m *Manager) Running() []Component {
+	m.entriesMutex.RLock()
+	defer m.entriesMutex.RUnlock()
+
+	var running []Component
+	for _, e := range m.entries {
+		if e.status().State == StateRunning {
+			running = append(running, e.Component)
+		}
 	}
+	return running
+}
+
+// Order returns the names of registered components in the order they will be started.
+func (m *Manager) Order() ([]string, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	ordered, err := m.sortComponents()
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, len(ordered))
+	for i, c := range ordered {
+		names[i] = NameOf(c)
+	}
+	return names, nil
+}
 
-	for _, c := range components {
-		go c.Start()
+// NameOf returns the name of component c.
+func NameOf(c Component) string {
+	if n, ok := c.(Named); ok {
+		return n.Name()
 	}
+	return fmt.Sprintf("%T", c)
 }
 
-// Stop stops all components.
-func Stop() {
-	for _, c := range components {
-		safe.Call(func() {
-			c.Stop()
+func initComponent(c Component) error {
+	ctx, cancel := context.WithTimeout(context.Background(), InitTimeout)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- protect(func() error {
+			if cc, ok := c.(ContextComponent); ok {
+				return cc.InitContext(ctx)
+			}
+			c.Init()
+			return nil
+		})
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// run starts the component and waits until it's ready.
+// The returned channel receives the result of StartContext.
+func (e *entry) run() (<-chan error, error) {
+	e.setState(StateStarting)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	ready := make(chan struct{})
+	var once sync.Once
+	readyFunc := func() {
+		once.Do(func() { close(ready) })
+	}
+
+	exit := make(chan error, 1)
+	returned := make(chan error, 1) // the result of Start of legacy components.
+	cc, isContext := e.Component.(ContextComponent)
+	go func() {
+		if isContext {
+			exit <- protect(func() error { return cc.StartContext(ctx, readyFunc) })
+			return
+		}
+
+		readyFunc()
+		err := protect(func() error {
+			e.Start()
+			return nil
 		})
+		returned <- err
+		if err == nil {
+			// returning from Start is not an exit of legacy components.
+			<-ctx.Done()
+		}
+		exit <- err
+	}()
+
+	timer := time.NewTimer(StartTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-ready:
+	case err := <-exit:
+		if err != nil {
+			cancel()
+			e.fail(err)
+			return nil, err
+		}
+		// finished its work without waiting for ready.
+		exit <- nil
+	case <-timer.C:
+		cancel()
+		e.setState(StateFailed)
+		return nil, context.DeadlineExceeded
+	}
+
+	if !isContext {
+		select {
+		case err := <-returned:
+			if err != nil {
+				cancel()
+				e.fail(err)
+				return nil, err
+			}
+		case <-time.After(LegacyStartGrace):
+		}
+	}
+
+	e.mu.Lock()
+	e.cancel = cancel
+	stopping := e.stopping
+	e.mu.Unlock()
+	if stopping {
+		// stopped while restarting.
+		cancel()
+		return exit, nil
+	}
+
+	e.setState(StateRunning)
+	return exit, nil
+}
+
+// fail marks the component failed to start with err.
+func (e *entry) fail(err error) {
+	e.mu.Lock()
+	e.lastErr = err
+	e.mu.Unlock()
+	e.setState(StateFailed)
+}
+
+func (m *Manager) stopStarted() error {
+	ctx, cancel := context.WithTimeout(context.Background(), StopTimeout)
+	defer cancel()
+
+	var hung []string
+	var firstErr error
+	for i := len(m.started) - 1; i >= 0; i-- {
+		e := m.started[i]
+		log.Debugf("stop component %s", e.name)
+
+		e.mu.Lock()
+		e.stopping = true
+		cancelRun := e.cancel
+		e.mu.Unlock()
+		close(e.quit)
+		e.setState(StateStopping)
+
+		done := make(chan error, 1)
+		go func() {
+			defer cancelRun()
+			done <- protect(func() error {
+				if cc, ok := e.Component.(ContextComponent); ok {
+					return cc.StopContext(ctx)
+				}
+				e.Stop()
+				return nil
+			})
+		}()
+
+		// the ones left after the deadline are stopped without waiting.
+		var err error
+		select {
+		case err = <-done:
+		case <-ctx.Done():
+			log.Errorf("stop component %s timeout", e.name)
+			hung = append(hung, e.name)
+			continue
+		}
+		if err != nil {
+			log.Errorf("stop component %s failed: %v", e.name, err)
+			e.setState(StateFailed)
+			if firstErr == nil {
+				firstErr = errs.NewComponentFailure(e.name, "stop", err)
+			}
+		} else {
+			e.setState(StateStopped)
+		}
+	}
+	m.started = nil
+
+	if len(hung) > 0 {
+		return errs.NewStopTimeout(hung)
+	}
+	return firstErr
+}
+
+// protect calls f and converts a panic to error.
+func protect(f func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return f()
+}
+
+func (m *Manager) requiresOf(c Component) []string {
+	var deps []string
+	if d, ok := c.(Dependent); ok {
+		deps = append(deps, d.Requires()...)
+	}
+	return append(deps, m.requires[NameOf(c)]...)
+}
+
+// sortComponents returns the components in topological order of their dependencies.
+// Components without dependencies between each other keep their registration order.
+func (m *Manager) sortComponents() ([]Component, error) {
+	cs := m.components
+	byName := make(map[string]Component, len(cs))
+	for _, c := range cs {
+		name := NameOf(c)
+		if _, exist := byName[name]; exist {
+			return nil, fmt.Errorf("component with name %s has been registered", name)
+		}
+		byName[name] = c
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	marks := make(map[string]int, len(cs))
+	ordered := make([]Component, 0, len(cs))
+
+	var path []string
+	var visit func(c Component) error
+	visit = func(c Component) error {
+		name := NameOf(c)
+		switch marks[name] {
+		case visited:
+			return nil
+		case visiting:
+			for i, n := range path {
+				if n == name {
+					return errs.NewDependencyCycle(append(path[i:], name))
+				}
+			}
+		}
+
+		marks[name] = visiting
+		path = append(path, name)
+		for _, req := range m.requiresOf(c) {
+			dep, exist := byName[req]
+			if !exist {
+				return fmt.Errorf("component %s requires %s which is not registered", name, req)
+			}
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		marks[name] = visited
+		ordered = append(ordered, c)
+		return nil
+	}
+
+	for _, c := range cs {
+		if err := visit(c); err != nil {
+			return nil, err
+		}
 	}
+	return ordered, nil
 }
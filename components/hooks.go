@@ -0,0 +1,78 @@
+package components
+
+import (
+	"context"
+
+	"github.com/amsalt/engins/errs"
+)
+
+// Reloadable is an optional interface for a Component to reload its
+// configuration at runtime, such as config files or log level.
+type Reloadable interface {
+	Reload() error
+}
+
+// Drainable is an optional interface for a Component to stop accepting new
+// work while finishing the in-flight one, before it's stopped.
+type Drainable interface {
+	Drain(ctx context.Context) error
+}
+
+// Reload is a helper method by using Default manager.
+func Reload() error {
+	return Default.Reload()
+}
+
+// Drain is a helper method by using Default manager.
+func Drain(ctx context.Context) error {
+	return Default.Drain(ctx)
+}
+
+// Reload reloads the started components implementing Reloadable in the order of starting.
+// All components are reloaded even if some fail, the first error is returned.
+func (m *Manager) Reload() error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	var firstErr error
+	for _, e := range m.started {
+		r, ok := e.Component.(Reloadable)
+		if !ok {
+			continue
+		}
+
+		log.Infof("reload component %s", e.name)
+		if err := protect(r.Reload); err != nil {
+			log.Errorf("reload component %s failed: %v", e.name, err)
+			if firstErr == nil {
+				firstErr = errs.NewComponentFailure(e.name, "reload", err)
+			}
+		}
+	}
+	return firstErr
+}
+
+// Drain drains the started components implementing Drainable in reverse order of starting.
+// All components are drained even if some fail, the first error is returned.
+func (m *Manager) Drain(ctx context.Context) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	var firstErr error
+	for i := len(m.started) - 1; i >= 0; i-- {
+		e := m.started[i]
+		d, ok := e.Component.(Drainable)
+		if !ok {
+			continue
+		}
+
+		log.Infof("drain component %s", e.name)
+		if err := protect(func() error { return d.Drain(ctx) }); err != nil {
+			log.Errorf("drain component %s failed: %v", e.name, err)
+			if firstErr == nil {
+				firstErr = errs.NewComponentFailure(e.name, "drain", err)
+			}
+		}
+	}
+	return firstErr
+}
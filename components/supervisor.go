@@ -0,0 +1,235 @@
+package components
+
+import (
+	"errors"
+	"time"
+
+	"github.com/amsalt/engins/errs"
+)
+
+// State represents the running state of a component.
+type State int
+
+const (
+	StateIdle State = iota
+	StateStarting
+	StateRunning
+	StateRestarting
+	StateStopping
+	StateStopped
+	StateExited
+	StateFailed
+)
+
+var stateNames = [...]string{"idle", "starting", "running", "restarting", "stopping", "stopped", "exited", "failed"}
+
+func (s State) String() string {
+	if int(s) < len(stateNames) {
+		return stateNames[s]
+	}
+	return "unknown"
+}
+
+// RestartMode decides when a component is restarted after its StartContext returns,
+// or its Start panics for a Component not being a ContextComponent.
+type RestartMode int
+
+const (
+	RestartNever     RestartMode = iota // never restart.
+	RestartOnFailure                    // restart when StartContext returns an error or panics.
+	RestartAlways                       // restart whenever StartContext returns.
+)
+
+// RestartPolicy represents how a component is supervised.
+// A Component not being a ContextComponent is only watched for panics of Start,
+// since its Start is expected to return.
+type RestartPolicy struct {
+	Mode        RestartMode
+	MaxRestarts int           // max restarts within Window, 0 means unlimited.
+	Window      time.Duration // the window to count restarts, 0 means the whole lifetime.
+	Backoff     time.Duration // delay before the first restart, doubled by each restart in Window.
+	MaxBackoff  time.Duration // the upper limit of the delay, 0 means unlimited.
+
+	// Critical indicates the process should shut down when the component
+	// exits and can not be restarted.
+	Critical bool
+}
+
+// Supervised is an optional interface for a Component to declare its RestartPolicy.
+// A component not implementing Supervised is never restarted.
+type Supervised interface {
+	RestartPolicy() RestartPolicy
+}
+
+// Status represents the running status of a component.
+type Status struct {
+	Name      string
+	State     State
+	Since     time.Time // when the component entered State.
+	Restarts  int       // total restarts.
+	LastError error
+}
+
+// StateListener is notified when a component's state changes.
+type StateListener func(name string, from, to State)
+
+// OnStateChange is a helper method by using Default manager.
+func OnStateChange(l StateListener) {
+	Default.OnStateChange(l)
+}
+
+// Fatal is a helper method by using Default manager.
+func Fatal() <-chan error {
+	return Default.Fatal()
+}
+
+// OnStateChange registers a listener for state changes of all components.
+func (m *Manager) OnStateChange(l StateListener) {
+	m.listenerMutex.Lock()
+	defer m.listenerMutex.Unlock()
+
+	m.listeners = append(m.listeners, l)
+}
+
+// Fatal returns a channel receiving the error when a critical component exits
+// and can not be restarted, after which the process is expected to shut down.
+func (m *Manager) Fatal() <-chan error {
+	return m.fatal
+}
+
+func (m *Manager) escalate(err error) {
+	log.Errorf("critical component failure, escalate to shutdown: %v", err)
+	select {
+	case m.fatal <- err:
+	default:
+	}
+}
+
+func policyOf(c Component) RestartPolicy {
+	if s, ok := c.(Supervised); ok {
+		return s.RestartPolicy()
+	}
+	return RestartPolicy{Mode: RestartNever}
+}
+
+func (e *entry) setState(to State) {
+	e.mu.Lock()
+	from := e.state
+	e.state = to
+	e.since = time.Now()
+	e.mu.Unlock()
+
+	if from == to {
+		return
+	}
+	log.Debugf("component %s state changes from %s to %s", e.name, from, to)
+
+	e.m.listenerMutex.RLock()
+	defer e.m.listenerMutex.RUnlock()
+	for _, l := range e.m.listeners {
+		l(e.name, from, to)
+	}
+}
+
+func (e *entry) status() Status {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return Status{Name: e.name, State: e.state, Since: e.since, Restarts: e.total, LastError: e.lastErr}
+}
+
+func (e *entry) isStopping() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.stopping
+}
+
+// supervise watches the running component and restarts it according to its policy.
+func (e *entry) supervise(exit <-chan error) {
+	err := <-exit
+	for {
+		if e.isStopping() {
+			return
+		}
+
+		if err != nil {
+			log.Errorf("component %s failed: %v", e.name, err)
+			e.mu.Lock()
+			e.lastErr = err
+			e.mu.Unlock()
+			e.setState(StateFailed)
+		} else {
+			log.Infof("component %s exited", e.name)
+			e.setState(StateExited)
+		}
+
+		delay, ok := e.nextRestart(err)
+		if !ok {
+			if e.policy.Critical {
+				if err == nil {
+					err = errors.New("exited")
+				}
+				e.m.escalate(errs.NewComponentFailure(e.name, "run", err))
+			}
+			return
+		}
+
+		log.Infof("component %s restarts in %v", e.name, delay)
+		e.setState(StateRestarting)
+		select {
+		case <-time.After(delay):
+		case <-e.quit:
+			return
+		}
+
+		var startErr error
+		exit, startErr = e.run()
+		if startErr != nil {
+			err = startErr
+			continue
+		}
+		err = <-exit
+	}
+}
+
+// nextRestart returns the delay before restarting, and false if the component should not restart.
+func (e *entry) nextRestart(err error) (time.Duration, bool) {
+	p := e.policy
+	switch {
+	case p.Mode == RestartNever:
+		return 0, false
+	case p.Mode == RestartOnFailure && err == nil:
+		return 0, false
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	now := time.Now()
+	if p.Window > 0 {
+		var recent []time.Time
+		for _, t := range e.restarts {
+			if now.Sub(t) < p.Window {
+				recent = append(recent, t)
+			}
+		}
+		e.restarts = recent
+	}
+	if p.MaxRestarts > 0 && len(e.restarts) >= p.MaxRestarts {
+		log.Errorf("component %s restarted %d times within %v, give up", e.name, len(e.restarts), p.Window)
+		return 0, false
+	}
+
+	delay := p.Backoff
+	for i := 0; i < len(e.restarts) && (p.MaxBackoff <= 0 || delay < p.MaxBackoff); i++ {
+		delay *= 2
+	}
+	if p.MaxBackoff > 0 && delay > p.MaxBackoff {
+		delay = p.MaxBackoff
+	}
+
+	e.restarts = append(e.restarts, now)
+	e.total++
+	return delay, true
+}
@@ -4,9 +4,11 @@ import (
 	"encoding/json"
 	"io/ioutil"
 
-	"github.com/amsalt/log"
+	"github.com/amsalt/engins/logging"
 )
 
+var log = logging.New("conf")
+
 func init() {
 	Register(&JSONParser{})
 }
@@ -6,4 +6,7 @@ const (
 	DefaultWorkerNum         = 10
 	DefaultChanLen           = 1000
 	DefaultMongoFindMaxCount = 500
+
+	// DefaultBacklogLimit is the pending commands over which the client is unhealthy.
+	DefaultBacklogLimit = DefaultChanLen * 8 / 10
 )
@@ -0,0 +1,85 @@
+package database
+
+import (
+	"time"
+
+	"github.com/amsalt/engins/logging"
+	"github.com/amsalt/engins/monitor/events"
+	"github.com/amsalt/engins/monitor/metrics"
+)
+
+var log = logging.New("database")
+
+// SlowCommandThreshold is the time over which a command is reported as slow to the events.
+var SlowCommandThreshold = time.Millisecond * 100
+
+var (
+	redisCommandSeconds = metrics.NewHistogram("engins_redis_command_seconds",
+		"Time spent by redis commands, including callbacks without executor.", nil, "command")
+	redisQueueDepth = metrics.NewGauge("engins_redis_queue_depth",
+		"Pending redis commands.", "addr")
+	mongoCommandSeconds = metrics.NewHistogram("engins_mongo_command_seconds",
+		"Time spent by mongo commands, including callbacks without executor.", nil, "command")
+	mongoQueueDepth = metrics.NewGauge("engins_mongo_queue_depth",
+		"Pending mongo commands.", "addr")
+)
+
+var redisCommandNames = [...]string{
+	REDIS_GET:         "get",
+	REDIS_SET:         "set",
+	REDIS_SETNX:       "setnx",
+	REDIS_DEL:         "del",
+	REDIS_KEYS:        "keys",
+	REDIS_HSET:        "hset",
+	REDIS_HGET:        "hget",
+	REDIS_HMSET:       "hmset",
+	REDIS_HMGET:       "hmget",
+	REDIS_HGETALL:     "hgetall",
+	REDIS_HDEL:        "hdel",
+	REDIS_HINCRBY:     "hincrby",
+	REDIS_LPUSH:       "lpush",
+	REDIS_LPOP:        "lpop",
+	REDIS_RPUSH:       "rpush",
+	REDIS_RPOP:        "rpop",
+	REDIS_LRANGE:      "lrange",
+	REDIS_ZADD:        "zadd",
+	REDIS_ZREVRANK:    "zrevrank",
+	REDIS_ZREVRANGE:   "zrevrange",
+	REDIS_ZINCRBY:     "zincrby",
+	REDIS_SADD:        "sadd",
+	REDIS_SMEMBERS:    "smembers",
+	REDIS_SISMEMBER:   "sismember",
+	REDIS_SRANDMEMBER: "srandmember",
+	REDIS_SREM:        "srem",
+	REDIS_PIPELINED:   "pipeline",
+	REDIS_EXPIRE:      "expire",
+	REDIS_EXPIRE_AT:   "expireat",
+	REDIS_ZREM:        "zrem",
+}
+
+var mongoCommandNames = [...]string{
+	MONGO_FIND:           "find",
+	MONGO_FINDALL:        "findall",
+	MONGO_UPSERT:         "upsert",
+	MONGO_UPDATEID:       "updateid",
+	MONGO_UPDATEALL:      "updateall",
+	MONGO_DELETE:         "delete",
+	MONGO_INSERT:         "insert",
+	MONGO_AGGREGATE:      "aggregate",
+	MONGO_DELETE_WITH_CB: "delete",
+}
+
+func commandName(names []string, action int) string {
+	if action >= 0 && action < len(names) {
+		return names[action]
+	}
+	return "unknown"
+}
+
+func observeSince(source string, h *metrics.HistogramVec, command string, start time.Time) {
+	elapsed := time.Since(start)
+	h.With(command).Observe(elapsed.Seconds())
+	if elapsed > SlowCommandThreshold {
+		events.Publish(source, "slow command %s took %v", command, elapsed)
+	}
+}
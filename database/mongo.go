@@ -1,12 +1,19 @@
 package database
 
 import (
-	"github.com/amsalt/log"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/amsalt/engins/health"
+	"github.com/amsalt/engins/monitor/metrics"
 	"github.com/amsalt/nginet/core"
 	"github.com/amsalt/nginet/safe"
 	mgo "gopkg.in/mgo.v2"
 )
 
+// Mongo is the mongo client of the default engins App, set by App.SetMongo or InitMongo.
+// The other Apps keep their own clients, see App.Mongo.
 var Mongo *MongoClient
 
 const (
@@ -43,15 +50,19 @@ type MongoClient struct {
 	db      *mgo.Database
 	session *mgo.Session
 
-	commands chan *MongoCommand
-	executor core.Executor
+	commands   chan *MongoCommand
+	executor   core.Executor
+	queueDepth *metrics.Gauge
 }
 
+// InitMongo creates the mongo client of the default engins App, and checks its health.
 func InitMongo(opts *MongoOption, executor core.Executor) {
-	Mongo = newMongoClient(opts, executor)
+	Mongo = NewMongoClient(opts, executor)
+	health.Register("mongo", Mongo)
 }
 
-func newMongoClient(opts *MongoOption, executor core.Executor) *MongoClient {
+// NewMongoClient creates a new MongoClient, which is not singleton.
+func NewMongoClient(opts *MongoOption, executor core.Executor) *MongoClient {
 	mongo := &MongoClient{executor: executor}
 	mongo.Init(opts)
 	return mongo
@@ -59,6 +70,7 @@ func newMongoClient(opts *MongoOption, executor core.Executor) *MongoClient {
 
 func (mongoClient *MongoClient) Init(opts *MongoOption) {
 	mongoClient.commands = make(chan *MongoCommand, DefaultChanLen)
+	mongoClient.queueDepth = mongoQueueDepth.With(opts.Addr)
 
 	session, err := mgo.Dial(opts.Addr)
 	if err != nil {
@@ -149,6 +161,23 @@ func (mongoClient *MongoClient) EnsureIndex(col string, index mgo.Index) {
 	mongoClient.db.C(col).EnsureIndex(index)
 }
 
+// CheckHealth pings the mongo server and checks the backlog of pending commands.
+func (mongoClient *MongoClient) CheckHealth(ctx context.Context) error {
+	if backlog := len(mongoClient.commands); backlog > DefaultBacklogLimit {
+		return fmt.Errorf("mongo command backlog %d exceeds %d", backlog, DefaultBacklogLimit)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- mongoClient.session.Ping()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 func (mongoClient *MongoClient) pushCommand(command *MongoCommand) {
 	select {
 	case mongoClient.commands <- command:
@@ -156,6 +185,7 @@ func (mongoClient *MongoClient) pushCommand(command *MongoCommand) {
 		mongoClient.commands <- command
 		log.Errorf("mongo command buffer is full")
 	}
+	mongoClient.queueDepth.Set(float64(len(mongoClient.commands)))
 }
 
 func (mongoClient *MongoClient) loop() {
@@ -164,7 +194,10 @@ func (mongoClient *MongoClient) loop() {
 			for {
 				select {
 				case command := <-mongoClient.commands:
+					mongoClient.queueDepth.Set(float64(len(mongoClient.commands)))
+					start := time.Now()
 					mongoClient.process(command)
+					observeSince("mongo", mongoCommandSeconds, commandName(mongoCommandNames[:], command.action), start)
 				}
 			}
 		}, nil)
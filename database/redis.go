@@ -1,15 +1,19 @@
 package database
 
 import (
+	"context"
+	"fmt"
 	"strconv"
 	"time"
 
-	"github.com/amsalt/log"
+	"github.com/amsalt/engins/monitor/metrics"
 	"github.com/amsalt/netkit/util"
 	"github.com/amsalt/nginet/core"
 	"github.com/go-redis/redis"
 )
 
+// Redis is the redis client of the default engins App, set by App.SetRedis.
+// The other Apps keep their own clients, see App.Redis.
 var Redis *RedisClient
 
 const (
@@ -105,7 +109,8 @@ type RedisClient struct {
 	config   *RedisOption
 	commands chan *RedisCommand
 
-	executor core.Executor
+	executor   core.Executor
+	queueDepth *metrics.Gauge
 }
 
 // Redis pub-sub client need different from normal read-write node
@@ -122,6 +127,7 @@ func (redisClient *RedisClient) GetRawClient() *redis.Client {
 
 func (redisClient *RedisClient) Init() {
 	redisClient.commands = make(chan *RedisCommand, DefaultChanLen)
+	redisClient.queueDepth = redisQueueDepth.With(redisClient.config.Addr)
 
 	redisClient.redis = redis.NewClient(&redis.Options{
 		Addr:     redisClient.config.Addr,
@@ -301,6 +307,15 @@ func (redisClient *RedisClient) Pipeline(pFunc PipelineFunctor, cb PipelineCallb
 	redisClient.pushCommand(item)
 }
 
+/*--------------------------------- Health --------------------------------------*/
+// CheckHealth pings the redis server and checks the backlog of pending commands.
+func (redisClient *RedisClient) CheckHealth(ctx context.Context) error {
+	if backlog := len(redisClient.commands); backlog > DefaultBacklogLimit {
+		return fmt.Errorf("redis command backlog %d exceeds %d", backlog, DefaultBacklogLimit)
+	}
+	return redisClient.redis.WithContext(ctx).Ping().Err()
+}
+
 /*--------------------------------- Common --------------------------------------*/
 func (redisClient *RedisClient) GetSync(key string) (string, error) {
 	return redisClient.redis.Get(key).Result()
@@ -312,6 +327,7 @@ func (redisClient *RedisClient) pushCommand(command *RedisCommand) {
 	default:
 		redisClient.commands <- command
 	}
+	redisClient.queueDepth.Set(float64(len(redisClient.commands)))
 }
 
 // loop starts new goroutine for I/O
@@ -323,7 +339,10 @@ func (redisClient *RedisClient) loop() {
 				select {
 				case command := <-redisClient.commands:
 					// log.Debug("new command: %+v", command)
+					redisClient.queueDepth.Set(float64(len(redisClient.commands)))
+					start := time.Now()
 					redisClient.process(command)
+					observeSince("redis", redisCommandSeconds, commandName(redisCommandNames[:], command.action), start)
 				}
 			}
 		}, nil)
@@ -1,25 +1,19 @@
 package engins
 
 import (
-	"os"
-	"os/signal"
-	"syscall"
+	"time"
 
 	"github.com/amsalt/engins/components"
-	"github.com/amsalt/log"
 )
 
-func Run(component ...components.Component) {
-	// register and run components.
-	components.Register(component...)
-	components.Run()
-	log.Infof("engins start success")
-
-	// close
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, syscall.SIGINT, syscall.SIGKILL, syscall.SIGTERM)
+// Run is a helper method by using default App.
+// See App.Run.
+func Run(component ...components.Component) error {
+	return defaultApp.Run(component...)
+}
 
-	sig := <-c
-	log.Infof("engins closing down (signal: %v)", sig)
-	components.Stop()
+// GracefulShutdown is a helper method by using default App.
+// See App.GracefulShutdown.
+func GracefulShutdown(timeout time.Duration) error {
+	return defaultApp.GracefulShutdown(timeout)
 }
@@ -0,0 +1,61 @@
+package errs
+
+import (
+	"fmt"
+	"time"
+)
+
+// NoRoute represents no connection found to send message to a service.
+type NoRoute struct {
+	Service string
+}
+
+func NewNoRoute(service string) *NoRoute {
+	return &NoRoute{Service: service}
+}
+
+func (e *NoRoute) Error() string {
+	return "no suited cluster components found to write message for " + e.Service
+}
+
+// CallTimeout represents a call to a service not replied in time.
+type CallTimeout struct {
+	Service string
+	Timeout time.Duration
+}
+
+func NewCallTimeout(service string, timeout time.Duration) *CallTimeout {
+	return &CallTimeout{Service: service, Timeout: timeout}
+}
+
+func (e *CallTimeout) Error() string {
+	return fmt.Sprintf("Call %s timeout after %v", e.Service, e.Timeout)
+}
+
+// RemoteError represents the error replied by the service called.
+type RemoteError struct {
+	Service string
+	Message string
+}
+
+func NewRemoteError(service, message string) *RemoteError {
+	return &RemoteError{Service: service, Message: message}
+}
+
+func (e *RemoteError) Error() string {
+	return "Call " + e.Service + " failed: " + e.Message
+}
+
+// Unauthenticated represents a node failed to prove its identity in handshake.
+type Unauthenticated struct {
+	Node   string
+	Reason string
+}
+
+func NewUnauthenticated(node, reason string) *Unauthenticated {
+	return &Unauthenticated{Node: node, Reason: reason}
+}
+
+func (e *Unauthenticated) Error() string {
+	return "node " + e.Node + " unauthenticated: " + e.Reason
+}
@@ -0,0 +1,48 @@
+package errs
+
+import "strings"
+
+// DependencyCycle represents a dependency cycle between components.
+type DependencyCycle struct {
+	Path []string
+}
+
+func NewDependencyCycle(path []string) *DependencyCycle {
+	return &DependencyCycle{Path: path}
+}
+
+func (e *DependencyCycle) Error() string {
+	return "Dependency cycle between components: " + strings.Join(e.Path, " -> ")
+}
+
+// ComponentFailure represents an error of a component in a lifecycle phase.
+type ComponentFailure struct {
+	Name  string
+	Phase string
+	Err   error
+}
+
+func NewComponentFailure(name, phase string, err error) *ComponentFailure {
+	return &ComponentFailure{Name: name, Phase: phase, Err: err}
+}
+
+func (e *ComponentFailure) Error() string {
+	return "Component " + e.Name + " " + e.Phase + " failed: " + e.Err.Error()
+}
+
+func (e *ComponentFailure) Unwrap() error {
+	return e.Err
+}
+
+// StopTimeout represents the components which didn't stop in time.
+type StopTimeout struct {
+	Hung []string
+}
+
+func NewStopTimeout(hung []string) *StopTimeout {
+	return &StopTimeout{Hung: hung}
+}
+
+func (e *StopTimeout) Error() string {
+	return "Components stop timeout: " + strings.Join(e.Hung, ", ")
+}
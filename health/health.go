@@ -0,0 +1,261 @@
+package health
+
+// package health provides health checks of components and the aggregated
+// liveness and readiness report of the process.
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/amsalt/engins/components"
+	"github.com/amsalt/engins/logging"
+)
+
+var log = logging.New("health")
+
+const (
+	DefaultInterval = time.Second * 10
+	DefaultTimeout  = time.Second * 3
+)
+
+// Name is the component name of Aggregator.
+const Name = "health"
+
+// Default is the Aggregator of the default components manager.
+var Default = NewAggregator(components.Default)
+
+// Checker is an optional interface for a Component to check its health.
+// It's also used to register checks of anything else to an Aggregator.
+type Checker interface {
+	CheckHealth(ctx context.Context) error
+}
+
+// CheckFunc is an adapter to use ordinary function as Checker.
+type CheckFunc func(ctx context.Context) error
+
+func (f CheckFunc) CheckHealth(ctx context.Context) error {
+	return f(ctx)
+}
+
+// Result represents the result of a check.
+type Result struct {
+	Name      string        `json:"name"`
+	Healthy   bool          `json:"healthy"`
+	Error     string        `json:"error,omitempty"`
+	Latency   time.Duration `json:"latency"`
+	CheckedAt time.Time     `json:"checked_at"`
+}
+
+// Report represents the aggregated results.
+type Report struct {
+	Healthy bool     `json:"healthy"`
+	Results []Result `json:"results"`
+}
+
+// Register is a helper method by using Default aggregator.
+func Register(name string, c Checker) {
+	Default.Register(name, c)
+}
+
+// Aggregator evaluates the checks periodically, including the registered ones
+// and the started components implementing Checker.
+// It works as a component, before started the checks are evaluated when queried.
+type Aggregator struct {
+	Interval time.Duration // interval of evaluating.
+	Timeout  time.Duration // timeout of each check.
+
+	manager *components.Manager
+
+	mutex   sync.RWMutex
+	checks  map[string]Checker
+	last    *Report
+	lastRun time.Time
+	quit    chan struct{}
+}
+
+// NewAggregator creates an Aggregator for the components of m.
+func NewAggregator(m *components.Manager) *Aggregator {
+	return &Aggregator{
+		Interval: DefaultInterval,
+		Timeout:  DefaultTimeout,
+		manager:  m,
+		checks:   make(map[string]Checker),
+	}
+}
+
+// Register registers a check with name, replacing the previous one.
+func (a *Aggregator) Register(name string, c Checker) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.checks[name] = c
+}
+
+// Unregister unregisters the check with name.
+func (a *Aggregator) Unregister(name string) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	delete(a.checks, name)
+}
+
+// Name returns the component name of the Aggregator.
+func (a *Aggregator) Name() string {
+	return Name
+}
+
+func (a *Aggregator) Init() {
+	a.quit = make(chan struct{})
+}
+
+// Start starts evaluating the checks periodically.
+func (a *Aggregator) Start() {
+	go func() {
+		ticker := time.NewTicker(a.Interval)
+		defer ticker.Stop()
+
+		a.Check()
+		for {
+			select {
+			case <-ticker.C:
+				a.Check()
+			case <-a.quit:
+				return
+			}
+		}
+	}()
+}
+
+func (a *Aggregator) Stop() {
+	close(a.quit)
+}
+
+// Liveness reports whether the process is alive, that is no component failed.
+func (a *Aggregator) Liveness() Report {
+	report := Report{Healthy: true}
+	now := time.Now()
+	for _, s := range a.manager.Statuses() {
+		r := Result{Name: s.Name, Healthy: true, CheckedAt: now}
+		if s.State == components.StateFailed {
+			r.Healthy = false
+			r.Error = fmt.Sprintf("component %s", s.State)
+			if s.LastError != nil {
+				r.Error = fmt.Sprintf("component %s: %v", s.State, s.LastError)
+			}
+			report.Healthy = false
+		}
+		report.Results = append(report.Results, r)
+	}
+	return report
+}
+
+// Readiness reports whether the process is ready to serve, that is all components
+// are running and all checks pass. The last evaluated report within Interval is used.
+func (a *Aggregator) Readiness() Report {
+	a.mutex.RLock()
+	last, lastRun := a.last, a.lastRun
+	a.mutex.RUnlock()
+
+	if last != nil && time.Since(lastRun) < a.Interval {
+		return *last
+	}
+	return a.Check()
+}
+
+// Check evaluates the checks right now and returns the readiness report.
+func (a *Aggregator) Check() Report {
+	report := Report{Healthy: true}
+	now := time.Now()
+
+	for _, s := range a.manager.Statuses() {
+		if s.State != components.StateRunning {
+			report.Healthy = false
+			report.Results = append(report.Results, Result{
+				Name:      s.Name,
+				Error:     fmt.Sprintf("component %s", s.State),
+				CheckedAt: now,
+			})
+		}
+	}
+
+	checks := a.allChecks()
+	names := make([]string, 0, len(checks))
+	for name := range checks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	results := make([]Result, len(names))
+	var wg sync.WaitGroup
+	for i, name := range names {
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+			results[i] = a.check(name, checks[name])
+		}(i, name)
+	}
+	wg.Wait()
+
+	for _, r := range results {
+		if !r.Healthy {
+			report.Healthy = false
+			log.Errorf("health check %s failed: %s", r.Name, r.Error)
+		}
+	}
+	report.Results = append(report.Results, results...)
+
+	a.mutex.Lock()
+	a.last = &report
+	a.lastRun = now
+	a.mutex.Unlock()
+	return report
+}
+
+// allChecks returns the registered checks and the checks of started components.
+func (a *Aggregator) allChecks() map[string]Checker {
+	checks := make(map[string]Checker)
+	for _, c := range a.manager.Running() {
+		if checker, ok := c.(Checker); ok {
+			checks[components.NameOf(c)] = checker
+		}
+	}
+
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	for name, c := range a.checks {
+		checks[name] = c
+	}
+	return checks
+}
+
+func (a *Aggregator) check(name string, c Checker) Result {
+	ctx, cancel := context.WithTimeout(context.Background(), a.Timeout)
+	defer cancel()
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- fmt.Errorf("panic: %v", r)
+			}
+		}()
+		done <- c.CheckHealth(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	r := Result{Name: name, Healthy: err == nil, Latency: time.Since(start), CheckedAt: start}
+	if err != nil {
+		r.Error = err.Error()
+	}
+	return r
+}
@@ -0,0 +1,37 @@
+package health
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/amsalt/engins/pprof"
+)
+
+func init() {
+	pprof.Handle("/health/live", LivenessHandler(Default))
+	pprof.Handle("/health/ready", ReadinessHandler(Default))
+}
+
+// LivenessHandler serves the liveness report of a as JSON,
+// with status 503 if not alive.
+func LivenessHandler(a *Aggregator) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeReport(w, a.Liveness())
+	})
+}
+
+// ReadinessHandler serves the readiness report of a as JSON,
+// with status 503 if not ready.
+func ReadinessHandler(a *Aggregator) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeReport(w, a.Readiness())
+	})
+}
+
+func writeReport(w http.ResponseWriter, report Report) {
+	w.Header().Set("Content-Type", "application/json")
+	if !report.Healthy {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,433 @@
+// Package logging controls the logs at runtime: the global and per-package
+// levels, the debug logs of traced connections or users, and the output.
+// Each change may be limited to a time window after which it's reverted.
+//
+// The engins packages log through their Loggers, such as `cluster`, so the
+// changes apply to them. The records are forwarded to github.com/amsalt/log
+// unless redirected, whose level is kept by SyncLevel in sync with the most
+// verbose level needed by Default.
+package logging
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/amsalt/log"
+)
+
+// DefaultWindow is the time window of changes made from the console.
+const DefaultWindow = time.Minute * 10
+
+// Level is the severity of logs.
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelError
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "debug"
+	case LevelInfo:
+		return "info"
+	case LevelError:
+		return "error"
+	}
+	return fmt.Sprintf("Level(%d)", int(l))
+}
+
+// ParseLevel parses the level name.
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToLower(s) {
+	case "debug":
+		return LevelDebug, nil
+	case "info":
+		return LevelInfo, nil
+	case "error":
+		return LevelError, nil
+	}
+	return 0, fmt.Errorf("unknown log level %s", s)
+}
+
+// ConnKey returns the trace key of the connection with remote address addr.
+func ConnKey(addr string) string {
+	return "conn:" + addr
+}
+
+// UserAttr is the channel attribute holding the user ID of connection, whose
+// logs are traced by UserKey.
+var UserAttr = "UserID"
+
+// UserKey returns the trace key of the user.
+func UserKey(id interface{}) string {
+	return fmt.Sprintf("user:%v", id)
+}
+
+// Default is the default Controller used by the package level functions.
+var Default = NewController()
+
+// SyncLevel sets the level of github.com/amsalt/log to the most verbose level
+// needed by Default whenever it may change, so the records enabled by the
+// settings aren't dropped by it.
+var SyncLevel = func(min Level) {
+	switch min {
+	case LevelDebug:
+		log.SetLevel(log.DebugLevel)
+	case LevelInfo:
+		log.SetLevel(log.InfoLevel)
+	default:
+		log.SetLevel(log.ErrorLevel)
+	}
+}
+
+func init() {
+	OnLevelChange(func(min Level) { SyncLevel(min) })
+}
+
+// Controller holds the log settings.
+type Controller struct {
+	mutex     sync.RWMutex
+	level     Level
+	packages  map[string]Level
+	traces    map[string]bool
+	output    io.Writer
+	pending   map[string]*revert // reverts of changes with time window, by setting.
+	listeners []func(Level)
+
+	tracing     int32 // number of traced keys.
+	outputMutex sync.Mutex
+	notifyMutex sync.Mutex // keeps the listeners notified in order.
+}
+
+type revert struct {
+	gen     int
+	restore func()
+}
+
+// Settings is the snapshot of Controller.
+type Settings struct {
+	Level    string            `json:"level"`
+	Packages map[string]string `json:"packages,omitempty"`
+	Traces   []string          `json:"traces,omitempty"`
+	Output   string            `json:"output"`
+	Reverts  []string          `json:"reverts,omitempty"` // settings to be reverted.
+}
+
+// NewController creates a Controller at LevelInfo.
+func NewController() *Controller {
+	return &Controller{
+		level:    LevelInfo,
+		packages: make(map[string]Level),
+		traces:   make(map[string]bool),
+		pending:  make(map[string]*revert),
+	}
+}
+
+// SetLevel is a helper method by using Default controller.
+func SetLevel(level Level, window time.Duration) {
+	Default.SetLevel(level, window)
+}
+
+// SetPackageLevel is a helper method by using Default controller.
+func SetPackageLevel(pkg string, level Level, window time.Duration) {
+	Default.SetPackageLevel(pkg, level, window)
+}
+
+// Trace is a helper method by using Default controller.
+func Trace(key string, window time.Duration) {
+	Default.Trace(key, window)
+}
+
+// Untrace is a helper method by using Default controller.
+func Untrace(key string) {
+	Default.Untrace(key)
+}
+
+// Redirect is a helper method by using Default controller.
+func Redirect(w io.Writer, window time.Duration) {
+	Default.Redirect(w, window)
+}
+
+// OnLevelChange is a helper method by using Default controller.
+func OnLevelChange(f func(min Level)) {
+	Default.OnLevelChange(f)
+}
+
+// New is a helper method by using Default controller.
+func New(pkg string) *Logger {
+	return Default.Logger(pkg)
+}
+
+// SetLevel sets the global level, reverted after window if it's positive.
+func (c *Controller) SetLevel(level Level, window time.Duration) {
+	c.change("level", window, func() func() {
+		prev := c.level
+		c.level = level
+		return func() { c.level = prev }
+	})
+}
+
+// SetPackageLevel sets the level of pkg overriding the global one,
+// reverted after window if it's positive.
+func (c *Controller) SetPackageLevel(pkg string, level Level, window time.Duration) {
+	c.change("package:"+pkg, window, func() func() {
+		prev, exist := c.packages[pkg]
+		c.packages[pkg] = level
+		return func() {
+			if exist {
+				c.packages[pkg] = prev
+			} else {
+				delete(c.packages, pkg)
+			}
+		}
+	})
+}
+
+// Trace enables all logs with the key, such as ConnKey or UserKey,
+// disabled after window if it's positive.
+func (c *Controller) Trace(key string, window time.Duration) {
+	c.change("trace:"+key, window, func() func() {
+		existed := c.traces[key]
+		c.setTrace(key, true)
+		return func() { c.setTrace(key, existed) }
+	})
+}
+
+// Untrace disables the logs with the key enabled by Trace.
+func (c *Controller) Untrace(key string) {
+	c.change("trace:"+key, 0, func() func() {
+		c.setTrace(key, false)
+		return nil
+	})
+}
+
+// Redirect writes the logs to w instead of github.com/amsalt/log, nil
+// restores it, and github.com/amsalt/log is restored after window if it's
+// positive. The replaced output is closed if it's an io.Closer.
+func (c *Controller) Redirect(w io.Writer, window time.Duration) {
+	c.change("output", window, func() func() {
+		c.replaceOutput(w)
+		return func() { c.replaceOutput(nil) }
+	})
+}
+
+func (c *Controller) replaceOutput(w io.Writer) {
+	prev := c.output
+	c.output = w
+	if closer, ok := prev.(io.Closer); ok && prev != w {
+		c.outputMutex.Lock()
+		closer.Close()
+		c.outputMutex.Unlock()
+	}
+}
+
+// OnLevelChange registers f to receive the most verbose level needed by the
+// settings whenever it may change.
+func (c *Controller) OnLevelChange(f func(min Level)) {
+	c.mutex.Lock()
+	c.listeners = append(c.listeners, f)
+	c.mutex.Unlock()
+
+	f(c.MinLevel())
+}
+
+// MinLevel returns the most verbose level needed by the settings.
+func (c *Controller) MinLevel() Level {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return c.minLevel()
+}
+
+// Settings returns the current settings.
+func (c *Controller) Settings() Settings {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	s := Settings{Level: c.level.String(), Output: "log"}
+	if len(c.packages) > 0 {
+		s.Packages = make(map[string]string, len(c.packages))
+		for pkg, level := range c.packages {
+			s.Packages[pkg] = level.String()
+		}
+	}
+	for key := range c.traces {
+		s.Traces = append(s.Traces, key)
+	}
+	sort.Strings(s.Traces)
+	if c.output != nil {
+		s.Output = fmt.Sprintf("%T", c.output)
+		if named, ok := c.output.(interface{ Name() string }); ok {
+			s.Output = named.Name()
+		}
+	}
+	for setting := range c.pending {
+		s.Reverts = append(s.Reverts, setting)
+	}
+	sort.Strings(s.Reverts)
+	return s
+}
+
+// change applies the change of setting, which returns the function to restore
+// the previous value. A change with window reverts to the value before the
+// first pending change, a change without window cancels the pending revert.
+func (c *Controller) change(setting string, window time.Duration, apply func() func()) {
+	c.mutex.Lock()
+	restore := apply()
+	p := c.pending[setting]
+	gen := 1
+	if p != nil {
+		gen = p.gen + 1
+		restore = p.restore
+	}
+	if window > 0 && restore != nil {
+		c.pending[setting] = &revert{gen: gen, restore: restore}
+	} else {
+		delete(c.pending, setting)
+	}
+	c.mutex.Unlock()
+	c.notify()
+
+	if window <= 0 || restore == nil {
+		return
+	}
+	time.AfterFunc(window, func() {
+		c.mutex.Lock()
+		p := c.pending[setting]
+		if p == nil || p.gen != gen {
+			c.mutex.Unlock()
+			return
+		}
+		delete(c.pending, setting)
+		p.restore()
+		c.mutex.Unlock()
+		c.notify()
+		log.Infof("log setting %s reverted", setting)
+	})
+}
+
+func (c *Controller) setTrace(key string, on bool) {
+	if on && !c.traces[key] {
+		c.traces[key] = true
+		atomic.AddInt32(&c.tracing, 1)
+	} else if !on && c.traces[key] {
+		delete(c.traces, key)
+		atomic.AddInt32(&c.tracing, -1)
+	}
+}
+
+func (c *Controller) minLevel() Level {
+	min := c.level
+	for _, level := range c.packages {
+		if level < min {
+			min = level
+		}
+	}
+	if len(c.traces) > 0 {
+		min = LevelDebug
+	}
+	return min
+}
+
+func (c *Controller) notify() {
+	c.notifyMutex.Lock()
+	defer c.notifyMutex.Unlock()
+
+	c.mutex.RLock()
+	min := c.minLevel()
+	listeners := c.listeners
+	c.mutex.RUnlock()
+
+	for _, f := range listeners {
+		f(min)
+	}
+}
+
+// Logger logs for a package, with the keys to match the traces.
+type Logger struct {
+	c    *Controller
+	pkg  string
+	keys []string
+}
+
+// Logger returns the Logger of package pkg.
+func (c *Controller) Logger(pkg string) *Logger {
+	return &Logger{c: c, pkg: pkg}
+}
+
+// With returns a Logger with the trace keys added.
+func (l *Logger) With(keys ...string) *Logger {
+	return &Logger{c: l.c, pkg: l.pkg, keys: append(append([]string(nil), l.keys...), keys...)}
+}
+
+// Tracing returns whether any key is traced, to avoid building keys for nothing.
+func (l *Logger) Tracing() bool {
+	return atomic.LoadInt32(&l.c.tracing) > 0
+}
+
+// Enabled returns whether the logs at level are written.
+func (l *Logger) Enabled(level Level) bool {
+	l.c.mutex.RLock()
+	defer l.c.mutex.RUnlock()
+
+	for _, key := range l.keys {
+		if l.c.traces[key] {
+			return true
+		}
+	}
+	min, exist := l.c.packages[l.pkg]
+	if !exist {
+		min = l.c.level
+	}
+	return level >= min
+}
+
+func (l *Logger) Debugf(format string, a ...interface{}) {
+	l.logf(LevelDebug, format, a...)
+}
+
+func (l *Logger) Infof(format string, a ...interface{}) {
+	l.logf(LevelInfo, format, a...)
+}
+
+func (l *Logger) Errorf(format string, a ...interface{}) {
+	l.logf(LevelError, format, a...)
+}
+
+func (l *Logger) logf(level Level, format string, a ...interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+
+	msg := fmt.Sprintf(format, a...)
+	if len(l.keys) > 0 {
+		msg = fmt.Sprintf("%s %v", msg, l.keys)
+	}
+
+	l.c.mutex.RLock()
+	out := l.c.output
+	l.c.mutex.RUnlock()
+	if out != nil {
+		l.c.outputMutex.Lock()
+		fmt.Fprintf(out, "%s %-5s [%s] %s\n", time.Now().Format("2006-01-02 15:04:05.000"), level, l.pkg, msg)
+		l.c.outputMutex.Unlock()
+		return
+	}
+
+	switch level {
+	case LevelDebug:
+		log.Debugf("[%s] %s", l.pkg, msg)
+	case LevelInfo:
+		log.Infof("[%s] %s", l.pkg, msg)
+	default:
+		log.Errorf("[%s] %s", l.pkg, msg)
+	}
+}
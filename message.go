@@ -1,50 +1,163 @@
 package engins
 
-import "github.com/amsalt/nginet/message"
+import (
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/amsalt/nginet/core"
+	"github.com/amsalt/nginet/encoding"
+	"github.com/amsalt/nginet/message"
+)
 
 // Register registers message. In engins, all message should be registered before use.
-var Register message.Register
+// It's the register of default App.
+var Register message.Register = defaultApp.register
 
 // Dispatcher dispatches the message to handler.
 // Helper method to register message with handler.
-var Dispatcher message.ProcessorMgr
-
-func init() {
-	Register = message.NewRegister()
-	Dispatcher = message.NewProcessorMgr(Register)
-}
+// It's the processor manager of default App.
+var Dispatcher message.ProcessorMgr = defaultApp.dispatcher
 
 // GetMetaByID is a helper method by using default register.
 func GetMetaByID(id interface{}) message.Meta {
-	return Register.GetMetaByID(id)
+	return defaultApp.GetMetaByID(id)
 }
 
 // GetMetaByMsg is a helper method by using default register.
 func GetMetaByMsg(msg interface{}) message.Meta {
-	return Register.GetMetaByMsg(msg)
+	return defaultApp.GetMetaByMsg(msg)
 }
 
 // RegisterMsg is a helper method by using default register.
 func RegisterMsg(msg interface{}) (meta message.Meta) {
-	return Register.RegisterMsg(msg)
+	return defaultApp.RegisterMsg(msg)
 }
 
 // RegisterMsgByID is a helper method by using default register.
 func RegisterMsgByID(assignID interface{}, msg interface{}) message.Meta {
-	return Register.RegisterMsgByID(assignID, msg)
+	return defaultApp.RegisterMsgByID(assignID, msg)
 }
 
 // RegisterProcessor is a helper method by using default Dispatcher.
 func RegisterProcessor(msg interface{}, hf message.ProcessorFunc) error {
-	return Dispatcher.RegisterProcessor(msg, hf)
+	return defaultApp.RegisterProcessor(msg, hf)
 }
 
 // RegisterProcessorByID is a helper method by using default Dispatcher.
 func RegisterProcessorByID(msgID interface{}, hf message.ProcessorFunc) error {
-	return Dispatcher.RegisterProcessorByID(msgID, hf)
+	return defaultApp.RegisterProcessorByID(msgID, hf)
 }
 
 // GetProcessorByID is a helper method by using default Dispatcher.
 func GetProcessorByID(msgID interface{}) *message.Processor {
-	return Dispatcher.GetProcessorByID(msgID)
+	return defaultApp.GetProcessorByID(msgID)
+}
+
+// MessageInfo describes a registered message.
+type MessageInfo struct {
+	ID        interface{} `json:"id"`
+	Type      string      `json:"type"`
+	Codec     string      `json:"codec"`
+	Processor bool        `json:"processor"`
+}
+
+// Messages is a helper method by using default App.
+func Messages() []MessageInfo {
+	return defaultApp.Messages()
+}
+
+// MessageType returns the type of message registered with id.
+func (a *App) MessageType(msgID interface{}) (reflect.Type, bool) {
+	r := a.messages.find(msgID)
+	if r == nil {
+		return nil, false
+	}
+	return r.typ, true
+}
+
+// Process runs the processor registered for the message with id, it's used to
+// dispatch the messages carried by other messages, such as the requests of RPC.
+func (a *App) Process(ctx *core.ChannelContext, msgID interface{}, msg interface{}, args ...interface{}) error {
+	r := a.messages.find(msgID)
+	if r == nil || r.hf == nil {
+		return fmt.Errorf("no processor registered for message %v", msgID)
+	}
+	r.hf(ctx, msg, args...)
+	return nil
+}
+
+// Messages returns the messages registered by the App and whether a processor is registered.
+func (a *App) Messages() []MessageInfo {
+	return a.messages.infos()
+}
+
+type messageRecord struct {
+	id        interface{}
+	typ       reflect.Type
+	meta      message.Meta
+	processor bool
+	hf        message.ProcessorFunc
+}
+
+// messageRecords records the registered messages for diagnostics.
+type messageRecords struct {
+	mutex   sync.Mutex
+	records []*messageRecord
+}
+
+func (m *messageRecords) add(id interface{}, msg interface{}, meta message.Meta) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if id == nil && meta != nil {
+		id = meta.ID()
+	}
+	m.records = append(m.records, &messageRecord{id: id, typ: reflect.TypeOf(msg), meta: meta})
+}
+
+func (m *messageRecords) setProcessor(match func(*messageRecord) bool, hf message.ProcessorFunc) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for _, r := range m.records {
+		if match(r) {
+			r.processor = true
+			r.hf = hf
+		}
+	}
+}
+
+// find returns the last registered message with id, ids are compared by
+// their labels so that an id decoded from JSON matches the registered one.
+func (m *messageRecords) find(msgID interface{}) *messageRecord {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	label := messageLabel(msgID)
+	for i := len(m.records) - 1; i >= 0; i-- {
+		if r := m.records[i]; r.id != nil && messageLabel(r.id) == label {
+			return r
+		}
+	}
+	return nil
+}
+
+func (m *messageRecords) infos() []MessageInfo {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	infos := make([]MessageInfo, len(m.records))
+	for i, r := range m.records {
+		infos[i] = MessageInfo{ID: r.id, Type: fmt.Sprintf("%v", r.typ), Processor: r.processor}
+		if m, ok := r.meta.(codecMeta); ok && m.Codec() != nil {
+			infos[i].Codec = fmt.Sprintf("%T", m.Codec())
+		}
+	}
+	return infos
+}
+
+// codecMeta is implemented by the metas exposing their codecs.
+type codecMeta interface {
+	Codec() encoding.Codec
 }
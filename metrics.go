@@ -0,0 +1,57 @@
+package engins
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/amsalt/engins/logging"
+	"github.com/amsalt/engins/monitor/metrics"
+	"github.com/amsalt/nginet/core"
+	"github.com/amsalt/nginet/message"
+)
+
+var (
+	messagesDispatched = metrics.NewCounter("engins_messages_dispatched_total",
+		"Total messages dispatched to processors.", "id")
+	messageProcessSeconds = metrics.NewHistogram("engins_message_process_seconds",
+		"Time spent by processors.", nil, "id")
+)
+
+var log = logging.New("engins")
+
+// instrument wraps the processor to record dispatched messages with label id,
+// and logs them for the traced connections.
+func instrument(id string, hf message.ProcessorFunc) message.ProcessorFunc {
+	dispatched := messagesDispatched.With(id)
+	duration := messageProcessSeconds.With(id)
+	return func(ctx *core.ChannelContext, msg interface{}, args ...interface{}) {
+		if log.Tracing() && ctx != nil && ctx.Channel() != nil {
+			log.With(traceKeys(ctx)...).Debugf("process message %s: %+v", id, msg)
+		}
+
+		start := time.Now()
+		defer func() {
+			dispatched.Inc()
+			duration.Observe(time.Since(start).Seconds())
+		}()
+		hf(ctx, msg, args...)
+	}
+}
+
+// traceKeys returns the trace keys of the connection and its user of ctx.
+func traceKeys(ctx *core.ChannelContext) []string {
+	keys := []string{logging.ConnKey(fmt.Sprint(ctx.Channel().RemoteAddr()))}
+	if id := ctx.Attr().Value(logging.UserAttr); id != nil {
+		keys = append(keys, logging.UserKey(id))
+	}
+	return keys
+}
+
+func messageLabel(msgID interface{}) string {
+	return fmt.Sprintf("%v", msgID)
+}
+
+func messageTypeLabel(msg interface{}) string {
+	return reflect.TypeOf(msg).String()
+}
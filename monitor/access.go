@@ -0,0 +1,189 @@
+package monitor
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The predefined roles.
+// RoleAdmin allows all commands, RoleViewer allows the commands only showing status.
+const (
+	RoleAdmin  = "admin"
+	RoleViewer = "viewer"
+)
+
+// Account is a user of monitor.
+// A user logs in with `<name> <password>`, or with the token only.
+type Account struct {
+	Name     string
+	Password string
+	Token    string
+	Roles    []string
+}
+
+// PermissionDenied represents the error of running a command not allowed by the roles of user.
+type PermissionDenied struct {
+	User    string
+	Command string
+}
+
+func (e *PermissionDenied) Error() string {
+	return fmt.Sprintf("permission denied: %s is not allowed to run %s", e.User, e.Command)
+}
+
+// Access authenticates the users of monitor and authorizes the commands they run.
+type Access struct {
+	mutex    sync.RWMutex
+	accounts []Account
+	roles    map[string][]string
+}
+
+// NewAccess creates an Access with accounts and the predefined roles.
+func NewAccess(accounts ...Account) *Access {
+	a := &Access{accounts: accounts, roles: make(map[string][]string)}
+	a.DefineRole(RoleAdmin, "*")
+	a.DefineRole(RoleViewer, "help", "components", "health", "messages", "metrics",
+		"goroutines", "memstats", "gc", "version", "uptime", "watch", "tail", "cluster")
+	return a
+}
+
+// AddAccount adds a user.
+func (a *Access) AddAccount(account Account) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.accounts = append(a.accounts, account)
+}
+
+// DefineRole defines the role with the commands it allows, replacing the existing one.
+// A command is written as its path, such as `gc` or `gc run`, which doesn't allow
+// the subcommands. `gc *` allows all subcommands of gc and `*` allows all commands.
+func (a *Access) DefineRole(name string, commands ...string) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.roles[name] = commands
+}
+
+// Login authenticates the credential `<name> <password>` or `<token>`.
+func (a *Access) Login(credential string) (*Account, error) {
+	fields := strings.Fields(credential)
+
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	for i := range a.accounts {
+		account := &a.accounts[i]
+		switch len(fields) {
+		case 1:
+			if account.Token != "" && secretEqual(account.Token, fields[0]) {
+				return account, nil
+			}
+		case 2:
+			if account.Name == fields[0] && account.Password != "" && secretEqual(account.Password, fields[1]) {
+				return account, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("authentication failed")
+}
+
+// LoginToken authenticates the token only.
+func (a *Access) LoginToken(token string) (*Account, error) {
+	if token == "" || strings.ContainsAny(token, " \t") {
+		return nil, fmt.Errorf("authentication failed")
+	}
+	return a.Login(token)
+}
+
+// Authorize checks whether account is allowed to run the command line of Default.
+func (a *Access) Authorize(account *Account, line string) error {
+	return a.AuthorizeIn(Default, account, line)
+}
+
+// AuthorizeIn checks whether account is allowed to run the command line of r.
+// Unknown commands are allowed only by the roles allowing all commands.
+func (a *Access) AuthorizeIn(r *Registry, account *Account, line string) error {
+	return a.authorize(r, account, split(line))
+}
+
+func (a *Access) authorize(r *Registry, account *Account, fields []string) error {
+	path := commandPath(r, fields)
+
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	for _, role := range account.Roles {
+		if allows(a.roles[role], path) {
+			return nil
+		}
+	}
+	if path == "" {
+		path = strings.Join(fields, " ")
+	}
+	return &PermissionDenied{User: account.Name, Command: path}
+}
+
+// Allowed returns whether the command in fields is one of the commands allowed,
+// which are written as the ones of Access.DefineRole.
+// Unknown commands are allowed only by `*`.
+func (r *Registry) Allowed(allowed []string, fields []string) bool {
+	return allows(allowed, commandPath(r, fields))
+}
+
+func allows(allowed []string, path string) bool {
+	for _, a := range allowed {
+		if a == "*" || (path != "" && a == path) || (strings.HasSuffix(a, " *") && strings.HasPrefix(path, strings.TrimSuffix(a, "*"))) {
+			return true
+		}
+	}
+	return false
+}
+
+// commandPath returns the names of command and its subcommands in fields, such as `gc run`.
+func commandPath(r *Registry, fields []string) string {
+	if len(fields) == 0 {
+		return ""
+	}
+	c := r.GetCommand(fields[0])
+	if c == nil {
+		return ""
+	}
+
+	path := []string{fields[0]}
+	for _, name := range fields[1:] {
+		if c = subcommand(c, name); c == nil {
+			break
+		}
+		path = append(path, name)
+	}
+	return strings.Join(path, " ")
+}
+
+func secretEqual(expected, actual string) bool {
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(actual)) == 1
+}
+
+// AuditRecord records a command run by a user.
+type AuditRecord struct {
+	Time    time.Time
+	Addr    string // address of the caller.
+	User    string // empty without authentication.
+	Command string
+	Err     error
+}
+
+// Auditor receives the record of every command run.
+type Auditor func(r AuditRecord)
+
+// LogAuditor writes the audit records to log.
+func LogAuditor(r AuditRecord) {
+	if r.Err != nil {
+		log.Infof("monitor audit: addr=%s user=%s command=%q error=%v", r.Addr, r.User, r.Command, r.Err)
+		return
+	}
+	log.Infof("monitor audit: addr=%s user=%s command=%q", r.Addr, r.User, r.Command)
+}
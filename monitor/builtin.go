@@ -0,0 +1,199 @@
+package monitor
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/amsalt/engins"
+	"github.com/amsalt/engins/components"
+	"github.com/amsalt/engins/health"
+	"github.com/amsalt/engins/monitor/metrics"
+)
+
+func init() {
+	RegisterCommand(NewCommand("help", "type `help [command]` for more information", help,
+		WithUsage("help [command]")))
+	registerAppCommands(Default, engins.Default())
+	RegisterCommand(NewCommand("metrics", "show the current value of metrics", showMetrics,
+		WithUsage("metrics [filter=prefix]"), WithGroup("status")))
+}
+
+// registerAppCommands registers the commands working with app to r.
+func registerAppCommands(r *Registry, app *engins.App) {
+	r.RegisterCommand(NewCommand("components", "show the running state of components", showComponents(app),
+		WithGroup("status")))
+	r.RegisterCommand(NewCommand("health", "show the liveness and readiness checks", showHealth(app),
+		WithGroup("status")))
+	r.RegisterCommand(NewCommand("messages", "show the registered messages and processors", showMessages(app),
+		WithUsage("messages [filter=text]"), WithGroup("status")))
+	r.RegisterCommand(NewCommand("drain", "drain the components, then shut down the process", drain(app),
+		WithUsage("drain [timeout=1m]"), WithGroup("runtime")))
+}
+
+var registries = struct {
+	sync.Mutex
+	byApp map[*engins.App]*Registry
+}{byApp: make(map[*engins.App]*Registry)}
+
+// RegistryOf returns the Registry of app, Default for the default App.
+// The Registry of other Apps has its own commands working with the App, such
+// as `components`, and looks up Default for the process wide ones, such as `gc`.
+func RegistryOf(app *engins.App) *Registry {
+	if app == nil || app == engins.Default() {
+		return Default
+	}
+
+	registries.Lock()
+	defer registries.Unlock()
+
+	r, ok := registries.byApp[app]
+	if !ok {
+		r = NewRegistry(Default)
+		registerAppCommands(r, app)
+		registries.byApp[app] = r
+	}
+	return r
+}
+
+func help(ctx context.Context, args *Args) (*Result, error) {
+	if name := args.Arg(0); name != "" {
+		c := RegistryFrom(ctx).GetCommand(name)
+		if c == nil {
+			return nil, &UnknownCommand{Name: name}
+		}
+		for _, sub := range args.Positional[1:] {
+			if c = subcommand(c, sub); c == nil {
+				return nil, &UnknownCommand{Name: sub}
+			}
+		}
+		return TextResult("%s", usageOf(c)), nil
+	}
+
+	groups := make(map[string][]Command)
+	for _, c := range RegistryFrom(ctx).Commands() {
+		groups[groupOf(c)] = append(groups[groupOf(c)], c)
+	}
+	names := make([]string, 0, len(groups))
+	for g := range groups {
+		names = append(names, g)
+	}
+	sort.Strings(names)
+
+	result := "The commands are:\n"
+	for _, g := range names {
+		result += fmt.Sprintf("\n%s:\n", g)
+		for _, c := range groups[g] {
+			result += fmt.Sprintf("    %-15s %s\n", c.Name(), c.Desc())
+		}
+	}
+
+	return TextResult("%s", result), nil
+}
+
+// componentStatus is the structured output of a component status.
+type componentStatus struct {
+	Name      string    `json:"name"`
+	State     string    `json:"state"`
+	Since     time.Time `json:"since"`
+	Restarts  int       `json:"restarts"`
+	LastError string    `json:"last_error,omitempty"`
+}
+
+func showComponents(app *engins.App) Action {
+	return func(ctx context.Context, args *Args) (*Result, error) {
+		return componentsResult(app.Components().Statuses())
+	}
+}
+
+func componentsResult(ss []components.Status) (*Result, error) {
+	var statuses []componentStatus
+	result := fmt.Sprintf("    %-20s %-12s %-10s %-10s %s\n", "NAME", "STATE", "SINCE", "RESTARTS", "LAST ERROR")
+	for _, s := range ss {
+		status := componentStatus{Name: s.Name, State: s.State.String(), Since: s.Since, Restarts: s.Restarts}
+		if s.LastError != nil {
+			status.LastError = s.LastError.Error()
+		}
+		statuses = append(statuses, status)
+
+		since := time.Since(s.Since).Truncate(time.Second)
+		result += fmt.Sprintf("    %-20s %-12s %-10v %-10d %s\n", s.Name, s.State, since, s.Restarts, status.LastError)
+	}
+
+	return NewResult(statuses, result), nil
+}
+
+func showHealth(app *engins.App) Action {
+	return func(ctx context.Context, args *Args) (*Result, error) {
+		reports := map[string]health.Report{
+			"liveness":  app.Health().Liveness(),
+			"readiness": app.Health().Readiness(),
+		}
+
+		result := ""
+		for _, kind := range []string{"liveness", "readiness"} {
+			report := reports[kind]
+			status := "UP"
+			if !report.Healthy {
+				status = "DOWN"
+			}
+			result += fmt.Sprintf("%s: %s\n", kind, status)
+			for _, c := range report.Results {
+				status := "ok"
+				if !c.Healthy {
+					status = "FAIL " + c.Error
+				}
+				result += fmt.Sprintf("    %-20s %-10v %s\n", c.Name, c.Latency.Truncate(time.Microsecond), status)
+			}
+		}
+
+		return NewResult(reports, result), nil
+	}
+}
+
+func showMessages(app *engins.App) Action {
+	return func(ctx context.Context, args *Args) (*Result, error) {
+		filter := args.Option("filter", "")
+		var messages []engins.MessageInfo
+		result := fmt.Sprintf("    %-10s %-40s %-20s %s\n", "ID", "TYPE", "CODEC", "PROCESSOR")
+		for _, m := range app.Messages() {
+			if !strings.Contains(m.Type, filter) {
+				continue
+			}
+			messages = append(messages, m)
+			result += fmt.Sprintf("    %-10v %-40s %-20s %v\n", m.ID, m.Type, m.Codec, m.Processor)
+		}
+
+		return NewResult(messages, result), nil
+	}
+}
+
+func showMetrics(ctx context.Context, args *Args) (*Result, error) {
+	filter := args.Option("filter", "")
+	var families []metrics.Family
+	var result strings.Builder
+	for _, f := range metrics.Default.Gather() {
+		if !strings.HasPrefix(f.Name, filter) {
+			continue
+		}
+		families = append(families, f)
+		fmt.Fprintf(&result, "%s (%s)\n", f.Name, f.Kind)
+		for _, s := range f.Samples {
+			labels := make([]string, 0, len(s.Labels))
+			for k, v := range s.Labels {
+				labels = append(labels, k+"="+v)
+			}
+			sort.Strings(labels)
+			if f.Kind == metrics.KindHistogram {
+				fmt.Fprintf(&result, "    %-50s count=%d sum=%g\n", strings.Join(labels, ","), s.Count, s.Value)
+			} else {
+				fmt.Fprintf(&result, "    %-50s %g\n", strings.Join(labels, ","), s.Value)
+			}
+		}
+	}
+
+	return NewResult(families, result.String()), nil
+}
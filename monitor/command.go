@@ -0,0 +1,448 @@
+package monitor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// DefaultGroup is the group of commands without group declared.
+const DefaultGroup = "general"
+
+// Command represents a command of monitor.
+type Command interface {
+	// Name the name of command
+	Name() string
+
+	// Desc the description of command
+	Desc() string
+
+	// Usage the usage of command, such as `goroutines [filter] debug=1`
+	Usage() string
+
+	// Run the action of this command
+	Run(ctx context.Context, args *Args) (*Result, error)
+}
+
+// Grouped is an optional interface for a Command to declare its group in help.
+type Grouped interface {
+	Group() string
+}
+
+// Streaming is an optional interface for a Command streaming its output to the
+// console until interrupted, such as `watch`. The console runs it in background
+// and stops it on next input.
+type Streaming interface {
+	Streaming() bool
+}
+
+// Parent is an optional interface for a Command with subcommands.
+// When the first argument matches the name of a subcommand, the subcommand
+// runs with the rest arguments.
+type Parent interface {
+	Subcommands() []Command
+}
+
+// Result represents the output of a command.
+type Result struct {
+	Text string      // human readable output.
+	Data interface{} // structured output, Text is rendered from it as JSON if empty.
+}
+
+// NewResult creates a Result with structured data and text.
+func NewResult(data interface{}, text string) *Result {
+	return &Result{Data: data, Text: text}
+}
+
+// TextResult creates a Result with formatted text only.
+func TextResult(format string, a ...interface{}) *Result {
+	return &Result{Text: fmt.Sprintf(format, a...)}
+}
+
+// String returns the human readable output.
+func (r *Result) String() string {
+	if r.Text != "" || r.Data == nil {
+		return r.Text
+	}
+	b, err := json.MarshalIndent(r.Data, "", "    ")
+	if err != nil {
+		return fmt.Sprintf("%+v", r.Data)
+	}
+	return string(b)
+}
+
+// Args represents the arguments of a command, parsed from `cmd arg1 key=val`.
+type Args struct {
+	Positional []string          // arguments without `=`.
+	Options    map[string]string // arguments as `key=val`.
+}
+
+// ParseArgs parses the arguments split from command line.
+func ParseArgs(fields []string) *Args {
+	args := &Args{Options: make(map[string]string)}
+	for _, f := range fields {
+		if i := strings.Index(f, "="); i > 0 {
+			args.Options[f[:i]] = f[i+1:]
+		} else {
+			args.Positional = append(args.Positional, f)
+		}
+	}
+	return args
+}
+
+// Rest returns the positional arguments from the i-th and the options as
+// `key=val` sorted by key, to run another command with them.
+func (a *Args) Rest(i int) []string {
+	var fields []string
+	if i < len(a.Positional) {
+		fields = append(fields, a.Positional[i:]...)
+	}
+	keys := make([]string, 0, len(a.Options))
+	for key := range a.Options {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fields = append(fields, key+"="+a.Options[key])
+	}
+	return fields
+}
+
+// Arg returns the i-th positional argument, or "" if absent.
+func (a *Args) Arg(i int) string {
+	if i < len(a.Positional) {
+		return a.Positional[i]
+	}
+	return ""
+}
+
+// Option returns the value of option key, or def if absent.
+func (a *Args) Option(key string, def string) string {
+	if v, ok := a.Options[key]; ok {
+		return v
+	}
+	return def
+}
+
+// Int returns the value of option key as int, or def if absent.
+func (a *Args) Int(key string, def int) (int, error) {
+	v, ok := a.Options[key]
+	if !ok {
+		return def, nil
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		return def, fmt.Errorf("option %s must be an integer: %v", key, v)
+	}
+	return i, nil
+}
+
+// Bool returns the value of option key as bool, or def if absent.
+func (a *Args) Bool(key string, def bool) (bool, error) {
+	v, ok := a.Options[key]
+	if !ok {
+		return def, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return def, fmt.Errorf("option %s must be a boolean: %v", key, v)
+	}
+	return b, nil
+}
+
+// split splits the command line by spaces, double quotes can be used to keep spaces.
+func split(line string) []string {
+	var fields []string
+	var field strings.Builder
+	var quoted, hasField bool
+	for _, r := range line {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			hasField = true
+		case unicode.IsSpace(r) && !quoted:
+			if hasField {
+				fields = append(fields, field.String())
+				field.Reset()
+				hasField = false
+			}
+		default:
+			field.WriteRune(r)
+			hasField = true
+		}
+	}
+	if hasField {
+		fields = append(fields, field.String())
+	}
+	return fields
+}
+
+// CommandOption helper method to build a new command.
+type CommandOption func(*command)
+
+// WithUsage sets the usage of command.
+func WithUsage(usage string) CommandOption {
+	return func(c *command) {
+		c.usage = usage
+	}
+}
+
+// WithGroup sets the group of command in help.
+func WithGroup(group string) CommandOption {
+	return func(c *command) {
+		c.group = group
+	}
+}
+
+// WithSubcommands sets the subcommands of command.
+func WithSubcommands(subs ...Command) CommandOption {
+	return func(c *command) {
+		c.subs = append(c.subs, subs...)
+	}
+}
+
+// WithStreaming marks the command streaming its output by Stream.
+func WithStreaming() CommandOption {
+	return func(c *command) {
+		c.streaming = true
+	}
+}
+
+// Action is the action of a command built by NewCommand.
+type Action func(ctx context.Context, args *Args) (*Result, error)
+
+type command struct {
+	name   string
+	desc   string
+	usage  string
+	group  string
+	subs   []Command
+	action Action
+
+	streaming bool
+}
+
+// NewCommand builds a new command with name, description and action.
+// A command with subcommands only can have nil action.
+func NewCommand(name string, desc string, action Action, opt ...CommandOption) Command {
+	c := &command{name: name, desc: desc, usage: name, action: action}
+	for _, o := range opt {
+		o(c)
+	}
+	return c
+}
+
+func (c *command) Name() string           { return c.name }
+func (c *command) Desc() string           { return c.desc }
+func (c *command) Usage() string          { return c.usage }
+func (c *command) Group() string          { return c.group }
+func (c *command) Subcommands() []Command { return c.subs }
+func (c *command) Streaming() bool        { return c.streaming }
+
+func (c *command) Run(ctx context.Context, args *Args) (*Result, error) {
+	if c.action == nil {
+		return TextResult("%s", usageOf(c)), nil
+	}
+	return c.action(ctx, args)
+}
+
+// Registry is a set of commands. Every engins.App has its own Registry, see RegistryOf.
+type Registry struct {
+	parent *Registry // looked up for the commands not registered.
+
+	mutex    sync.RWMutex
+	commands map[string]Command
+}
+
+// Default is the Registry of the default App, used by the package level functions.
+var Default = NewRegistry(nil)
+
+// NewRegistry creates a Registry looking up parent for the commands not registered to it.
+func NewRegistry(parent *Registry) *Registry {
+	return &Registry{parent: parent, commands: make(map[string]Command)}
+}
+
+// RegisterCommand is a helper method by using Default registry.
+func RegisterCommand(c Command) {
+	Default.RegisterCommand(c)
+}
+
+// UnregisterCommand is a helper method by using Default registry.
+func UnregisterCommand(name string) {
+	Default.UnregisterCommand(name)
+}
+
+// GetCommand is a helper method by using Default registry.
+func GetCommand(name string) Command {
+	return Default.GetCommand(name)
+}
+
+// Commands is a helper method by using Default registry.
+func Commands() []Command {
+	return Default.Commands()
+}
+
+// RegisterCommand registers new Command to the registry.
+func (r *Registry) RegisterCommand(c Command) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	_, exist := r.commands[c.Name()]
+	if exist {
+		panic(fmt.Errorf("command with name %+v has been registered", c.Name()))
+	}
+	r.commands[c.Name()] = c
+}
+
+// UnregisterCommand removes the registered command with name,
+// it's used to disable the built-in commands.
+func (r *Registry) UnregisterCommand(name string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	delete(r.commands, name)
+}
+
+// Registered returns whether the command with name is registered to the
+// registry, not looking up its parent.
+func (r *Registry) Registered(name string) bool {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	_, ok := r.commands[name]
+	return ok
+}
+
+// GetCommand returns the registered command with name.
+func (r *Registry) GetCommand(name string) Command {
+	r.mutex.RLock()
+	c := r.commands[name]
+	r.mutex.RUnlock()
+	if c == nil && r.parent != nil {
+		return r.parent.GetCommand(name)
+	}
+	return c
+}
+
+// Commands returns all registered commands sorted by name.
+func (r *Registry) Commands() []Command {
+	byName := make(map[string]Command)
+	if r.parent != nil {
+		for _, c := range r.parent.Commands() {
+			byName[c.Name()] = c
+		}
+	}
+	r.mutex.RLock()
+	for name, c := range r.commands {
+		byName[name] = c
+	}
+	r.mutex.RUnlock()
+
+	cs := make([]Command, 0, len(byName))
+	for _, c := range byName {
+		cs = append(cs, c)
+	}
+	sort.Slice(cs, func(i, j int) bool {
+		return cs[i].Name() < cs[j].Name()
+	})
+	return cs
+}
+
+type registryKey struct{}
+
+func withRegistry(ctx context.Context, r *Registry) context.Context {
+	return context.WithValue(ctx, registryKey{}, r)
+}
+
+// RegistryFrom returns the Registry running the command of ctx, Default if none.
+func RegistryFrom(ctx context.Context) *Registry {
+	if r, ok := ctx.Value(registryKey{}).(*Registry); ok {
+		return r
+	}
+	return Default
+}
+
+// UnknownCommand represents the error of executing an unregistered command.
+type UnknownCommand struct {
+	Name string
+}
+
+func (e *UnknownCommand) Error() string {
+	return "unknown command: " + e.Name
+}
+
+// Execute is a helper method by using Default registry.
+func Execute(ctx context.Context, line string) (*Result, error) {
+	return Default.Execute(ctx, line)
+}
+
+// ExecuteFields is a helper method by using Default registry.
+func ExecuteFields(ctx context.Context, fields []string) (*Result, error) {
+	return Default.ExecuteFields(ctx, fields)
+}
+
+// Execute parses and runs the command line such as `cmd sub arg1 key=val`.
+func (r *Registry) Execute(ctx context.Context, line string) (*Result, error) {
+	return r.ExecuteFields(ctx, split(line))
+}
+
+// ExecuteFields runs the command line already split into fields.
+func (r *Registry) ExecuteFields(ctx context.Context, fields []string) (*Result, error) {
+	if len(fields) == 0 {
+		return nil, &UnknownCommand{}
+	}
+
+	c := r.GetCommand(fields[0])
+	if c == nil {
+		return nil, &UnknownCommand{Name: fields[0]}
+	}
+	return Run(withRegistry(ctx, r), c, fields[1:])
+}
+
+// Run runs the command with arguments, dispatching to subcommands.
+func Run(ctx context.Context, c Command, fields []string) (*Result, error) {
+	for len(fields) > 0 {
+		sub := subcommand(c, fields[0])
+		if sub == nil {
+			break
+		}
+		c, fields = sub, fields[1:]
+	}
+	return c.Run(ctx, ParseArgs(fields))
+}
+
+func subcommand(c Command, name string) Command {
+	p, ok := c.(Parent)
+	if !ok {
+		return nil
+	}
+	for _, sub := range p.Subcommands() {
+		if sub.Name() == name {
+			return sub
+		}
+	}
+	return nil
+}
+
+func groupOf(c Command) string {
+	if g, ok := c.(Grouped); ok && g.Group() != "" {
+		return g.Group()
+	}
+	return DefaultGroup
+}
+
+func usageOf(c Command) string {
+	result := fmt.Sprintf("usage: %s\n\n    %s\n", c.Usage(), c.Desc())
+	if p, ok := c.(Parent); ok && len(p.Subcommands()) > 0 {
+		result += "\nThe subcommands are:\n\n"
+		for _, sub := range p.Subcommands() {
+			result += fmt.Sprintf("    %-15s %s\n", sub.Name(), sub.Desc())
+		}
+	}
+	return result
+}
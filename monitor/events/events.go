@@ -0,0 +1,112 @@
+// Package events provides a live feed of events, such as component state
+// changes, cluster connections and slow database commands, for the monitor
+// to tail. It's kept free of engins dependencies so that any package can publish.
+package events
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultBuffer is the number of events a Subscription buffers before dropping.
+const DefaultBuffer = 256
+
+// Default is the default Feed used by the package level functions.
+var Default = NewFeed()
+
+// Event represents something happened.
+type Event struct {
+	Time   time.Time `json:"time"`
+	Source string    `json:"source"` // such as `components`, `cluster` and `redis`.
+	Text   string    `json:"text"`
+}
+
+// String formats the event as a line.
+func (e Event) String() string {
+	return fmt.Sprintf("%s [%s] %s", e.Time.Format("15:04:05.000"), e.Source, e.Text)
+}
+
+// Feed dispatches the published events to subscriptions.
+// Publishing never blocks, the events are dropped for the subscriptions full.
+type Feed struct {
+	mutex sync.RWMutex
+	subs  map[*Subscription]struct{}
+}
+
+// NewFeed creates a Feed without subscriptions.
+func NewFeed() *Feed {
+	return &Feed{subs: make(map[*Subscription]struct{})}
+}
+
+// Publish is a helper method by using Default feed.
+func Publish(source string, format string, a ...interface{}) {
+	Default.Publish(source, format, a...)
+}
+
+// Subscribe is a helper method by using Default feed.
+func Subscribe(buffer int) *Subscription {
+	return Default.Subscribe(buffer)
+}
+
+// Publish publishes the event formatted to all subscriptions.
+func (f *Feed) Publish(source string, format string, a ...interface{}) {
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+
+	if len(f.subs) == 0 {
+		return
+	}
+
+	e := Event{Time: time.Now(), Source: source, Text: fmt.Sprintf(format, a...)}
+	for s := range f.subs {
+		select {
+		case s.c <- e:
+		default:
+			atomic.AddUint64(&s.dropped, 1)
+		}
+	}
+}
+
+// Subscribe subscribes the events published later, buffering at most buffer events.
+func (f *Feed) Subscribe(buffer int) *Subscription {
+	if buffer <= 0 {
+		buffer = DefaultBuffer
+	}
+	s := &Subscription{feed: f, c: make(chan Event, buffer)}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.subs[s] = struct{}{}
+	return s
+}
+
+// Subscription receives the events of a Feed.
+type Subscription struct {
+	feed    *Feed
+	c       chan Event
+	dropped uint64
+	once    sync.Once
+}
+
+// Events returns the channel of events, it's closed when the Subscription closed.
+func (s *Subscription) Events() <-chan Event {
+	return s.c
+}
+
+// Dropped returns and resets the number of events dropped since last call.
+func (s *Subscription) Dropped() uint64 {
+	return atomic.SwapUint64(&s.dropped, 0)
+}
+
+// Close unsubscribes the events.
+func (s *Subscription) Close() {
+	s.once.Do(func() {
+		s.feed.mutex.Lock()
+		delete(s.feed.subs, s)
+		s.feed.mutex.Unlock()
+		close(s.c)
+	})
+}
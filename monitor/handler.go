@@ -1,33 +1,254 @@
 package monitor
 
 import (
+	"context"
 	"fmt"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
-	"github.com/amsalt/log"
 	"github.com/amsalt/nginet/core"
 )
 
+// TextHandler serves a session of monitor.
 type TextHandler struct {
 	*core.DefaultInboundHandler
+
+	registry    *Registry
+	access      *Access
+	idleTimeout time.Duration
+	auditor     Auditor
+
+	mutex    sync.Mutex
+	addr     string
+	account  *Account // logged in user.
+	attempts int      // failed logins.
+	idle     *time.Timer
+
+	stopStream context.CancelFunc // stops the running streaming command.
+	streamID   int
 }
 
+// NewTextHandler creates a TextHandler allowing all commands without login.
 func NewTextHandler() *TextHandler {
-	return &TextHandler{DefaultInboundHandler: core.NewDefaultInboundHandler()}
+	return &TextHandler{DefaultInboundHandler: core.NewDefaultInboundHandler(), registry: Default}
+}
+
+func (m *Monitor) newTextHandler() *TextHandler {
+	t := NewTextHandler()
+	t.registry = m.registry
+	t.access = m.access
+	t.idleTimeout = m.idleTimeout
+	t.auditor = m.auditor
+	return t
+}
+
+func (t *TextHandler) OnConnect(ctx *core.ChannelContext, channel core.Channel) {
+	t.mutex.Lock()
+	if addr := channel.RemoteAddr(); addr != nil {
+		t.addr = addr.String()
+	}
+	t.mutex.Unlock()
+
+	t.touch(ctx)
+	if t.access != nil {
+		ctx.Write("login: ")
+	}
+	t.DefaultInboundHandler.OnConnect(ctx, channel)
+}
+
+func (t *TextHandler) OnDisconnect(ctx *core.ChannelContext) {
+	t.mutex.Lock()
+	if t.idle != nil {
+		t.idle.Stop()
+	}
+	if t.stopStream != nil {
+		t.stopStream()
+	}
+	t.mutex.Unlock()
+
+	t.DefaultInboundHandler.OnDisconnect(ctx)
 }
 
 func (t *TextHandler) OnRead(ctx *core.ChannelContext, msg interface{}) {
-	cmd := t.filter(msg)
-	metric := getMetric(cmd)
+	t.touch(ctx)
+	line := t.filter(msg)
+
+	// any input interrupts the streaming command.
+	if t.interrupt() {
+		return
+	}
+
+	account, ok := t.login(ctx, line)
+	if !ok {
+		return
+	}
+
+	if line == "" {
+		line = "help"
+	}
+	if account != nil {
+		if err := t.access.AuthorizeIn(t.registry, account, line); err != nil {
+			t.audit(account, line, err)
+			ctx.Write(fmt.Sprintf("error: %v\n", err))
+			return
+		}
+	}
 
-	if metric == nil {
-		metric = getMetric("help")
+	s := &session{access: t.access, account: account}
+	if isStreaming(t.registry, split(line)) {
+		t.audit(account, line, nil)
+		t.runStream(ctx, s, line)
+		return
 	}
 
-	result := metric.run()
+	result, err := t.registry.Execute(withSession(context.Background(), s), line)
+	t.audit(account, line, err)
+	if err != nil {
+		if _, unknown := err.(*UnknownCommand); unknown {
+			result, _ = t.registry.Execute(context.Background(), "help")
+			ctx.Write(fmt.Sprintf("%v\n\n%s\n", err, result))
+			return
+		}
+		ctx.Write(fmt.Sprintf("error: %v\n", err))
+		return
+	}
 	ctx.Write(fmt.Sprintf("%s\n", result))
 }
 
+// runStream runs the streaming command in background until interrupted.
+// The outputs are buffered up to StreamBuffer and dropped if the client is slow.
+func (t *TextHandler) runStream(ctx *core.ChannelContext, s *session, line string) {
+	runCtx, cancel := context.WithCancel(context.Background())
+	t.mutex.Lock()
+	t.stopStream = cancel
+	t.streamID++
+	id := t.streamID
+	t.mutex.Unlock()
+
+	outputs := make(chan string, StreamBuffer)
+	var dropped int64
+	s.stream = func(text string) {
+		if len(text) > WriteBufferSize {
+			text = text[:WriteBufferSize-4] + "...\n"
+		}
+		select {
+		case outputs <- text:
+		default:
+			atomic.AddInt64(&dropped, 1)
+		}
+	}
+
+	go func() {
+		for text := range outputs {
+			if n := atomic.SwapInt64(&dropped, 0); n > 0 {
+				ctx.Write(fmt.Sprintf("(%d outputs dropped)\n", n))
+			}
+			ctx.Write(text)
+			t.touch(ctx)
+		}
+	}()
+
+	go func() {
+		defer close(outputs)
+
+		result, err := t.registry.Execute(withSession(runCtx, s), line)
+		switch {
+		case err != nil:
+			s.stream(fmt.Sprintf("error: %v\n", err))
+		case runCtx.Err() != nil:
+			s.stream("stopped\n")
+		case result != nil:
+			s.stream(fmt.Sprintf("%s\n", result))
+		}
+
+		t.mutex.Lock()
+		if t.streamID == id {
+			t.stopStream = nil
+		}
+		t.mutex.Unlock()
+		cancel()
+	}()
+}
+
+// interrupt stops the running streaming command, returns false if none.
+func (t *TextHandler) interrupt() bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.stopStream == nil {
+		return false
+	}
+	t.stopStream()
+	t.stopStream = nil
+	return true
+}
+
+// login returns the logged in user, ok is false if line is consumed for login.
+func (t *TextHandler) login(ctx *core.ChannelContext, line string) (account *Account, ok bool) {
+	if t.access == nil {
+		return nil, true
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.account != nil {
+		return t.account, true
+	}
+
+	account, err := t.access.Login(line)
+	if err != nil {
+		t.attempts++
+		log.Errorf("monitor login from %s failed, attempts: %d", t.addr, t.attempts)
+		if t.attempts >= MaxLoginAttempts {
+			ctx.Write("too many failed attempts\n")
+			ctx.Close()
+			return nil, false
+		}
+		ctx.Write("login failed\nlogin: ")
+		return nil, false
+	}
+
+	t.account = account
+	log.Infof("monitor user %s logged in from %s", account.Name, t.addr)
+	ctx.Write(fmt.Sprintf("welcome %s, type `help` for the commands\n", account.Name))
+	return nil, false
+}
+
+// touch resets the idle timer of session.
+func (t *TextHandler) touch(ctx *core.ChannelContext) {
+	if t.idleTimeout <= 0 {
+		return
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.idle != nil {
+		t.idle.Reset(t.idleTimeout)
+		return
+	}
+	t.idle = time.AfterFunc(t.idleTimeout, func() {
+		log.Infof("monitor session from %s idle timeout", t.addr)
+		ctx.Write("idle timeout\n")
+		ctx.Close()
+	})
+}
+
+func (t *TextHandler) audit(account *Account, line string, err error) {
+	if t.auditor == nil {
+		return
+	}
+
+	r := AuditRecord{Time: time.Now(), Addr: t.addr, Command: line, Err: err}
+	if account != nil {
+		r.User = account.Name
+	}
+	t.auditor(r)
+}
+
 func (t *TextHandler) filter(text interface{}) string {
 	log.Debugf("filter msg %+v", text)
 	str, ok := text.(string)
@@ -0,0 +1,296 @@
+package monitor
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/amsalt/engins/pprof"
+)
+
+// HTTPPrefix is the path prefix of the HTTP admin API.
+//
+//	GET  /admin/commands                 lists the commands.
+//	GET  /admin/help/<cmd>[/<sub>...]    shows the usage of command.
+//	POST /admin/run/<cmd>[/<sub>...]     runs the command.
+//
+// The arguments of command are passed as form values, `arg` for the positional
+// ones in order and others as `key=val` options, such as
+// `/admin/run/goroutines?arg=stack&filter=engins`.
+const HTTPPrefix = "/admin/"
+
+// WithHTTP serves the HTTP admin API on addr while the Monitor is running.
+// Without WithAccess, the API is served on a loopback addr only, such as
+// `127.0.0.1:7879`.
+func WithHTTP(addr string) Option {
+	return func(m *Monitor) {
+		m.httpAddr = addr
+	}
+}
+
+// WithPprofHTTP serves the HTTP admin API on the http server for pprof,
+// the Monitor must be created before pprof.StartHTTPPprofServer.
+// It requires WithAccess, the API isn't served without.
+func WithPprofHTTP() Option {
+	return func(m *Monitor) {
+		m.pprofHTTP = true
+	}
+}
+
+// handlePprofHTTP serves the HTTP admin API on the http server for pprof if required.
+func (m *Monitor) handlePprofHTTP() {
+	if !m.pprofHTTP {
+		return
+	}
+	if m.access == nil {
+		log.Errorf("monitor http admin API not served on pprof server without access")
+		return
+	}
+	pprof.Handle(HTTPPrefix, m.HTTPHandler())
+}
+
+// serveHTTP serves the HTTP admin API on httpAddr if required.
+func (m *Monitor) serveHTTP() {
+	if m.httpAddr == "" {
+		return
+	}
+	if m.access == nil && !isLoopback(m.httpAddr) {
+		log.Errorf("monitor http admin API not served on %s without access, only on loopback", m.httpAddr)
+		return
+	}
+	m.http.start(m.httpAddr, m.HTTPHandler())
+}
+
+// isLoopback returns whether addr listens on loopback interface only.
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// commandInfo is the JSON form of a Command.
+type commandInfo struct {
+	Name        string        `json:"name"`
+	Desc        string        `json:"desc"`
+	Usage       string        `json:"usage"`
+	Group       string        `json:"group"`
+	Subcommands []commandInfo `json:"subcommands,omitempty"`
+}
+
+func infoOf(c Command) commandInfo {
+	info := commandInfo{Name: c.Name(), Desc: c.Desc(), Usage: c.Usage(), Group: groupOf(c)}
+	if p, ok := c.(Parent); ok {
+		for _, sub := range p.Subcommands() {
+			info.Subcommands = append(info.Subcommands, infoOf(sub))
+		}
+	}
+	return info
+}
+
+// httpResult is the JSON response of a command.
+type httpResult struct {
+	Command string      `json:"command,omitempty"`
+	Text    string      `json:"text,omitempty"`
+	Data    interface{} `json:"data,omitempty"`
+	Error   string      `json:"error,omitempty"`
+}
+
+// HTTPHandler returns the handler of HTTP admin API, sharing the commands,
+// the access and the auditor with the telnet console.
+// Users authenticate with `Authorization: Bearer <token>` or basic auth when
+// access is required.
+func (m *Monitor) HTTPHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(HTTPPrefix+"commands", m.serveCommands)
+	mux.HandleFunc(HTTPPrefix+"help/", m.serveHelp)
+	mux.HandleFunc(HTTPPrefix+"run/", m.serveRun)
+	return mux
+}
+
+func (m *Monitor) serveCommands(w http.ResponseWriter, r *http.Request) {
+	if _, ok := m.authenticate(w, r); !ok {
+		return
+	}
+
+	cs := m.registry.Commands()
+	infos := make([]commandInfo, len(cs))
+	for i, c := range cs {
+		infos[i] = infoOf(c)
+	}
+	sort.SliceStable(infos, func(i, j int) bool {
+		return infos[i].Group < infos[j].Group
+	})
+	writeJSON(w, http.StatusOK, infos)
+}
+
+func (m *Monitor) serveHelp(w http.ResponseWriter, r *http.Request) {
+	if _, ok := m.authenticate(w, r); !ok {
+		return
+	}
+
+	fields := pathFields(r, "help/")
+	if len(fields) == 0 {
+		writeJSON(w, http.StatusNotFound, httpResult{Error: (&UnknownCommand{}).Error()})
+		return
+	}
+	c := m.registry.GetCommand(fields[0])
+	if c == nil {
+		writeJSON(w, http.StatusNotFound, httpResult{Error: (&UnknownCommand{Name: fields[0]}).Error()})
+		return
+	}
+	for _, name := range fields[1:] {
+		if c = subcommand(c, name); c == nil {
+			writeJSON(w, http.StatusNotFound, httpResult{Error: (&UnknownCommand{Name: name}).Error()})
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, httpResult{Command: strings.Join(fields, " "), Text: usageOf(c), Data: infoOf(c)})
+}
+
+func (m *Monitor) serveRun(w http.ResponseWriter, r *http.Request) {
+	// commands change the state of process, not to be run by links.
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSON(w, http.StatusMethodNotAllowed, httpResult{Error: "method not allowed"})
+		return
+	}
+	account, ok := m.authenticate(w, r)
+	if !ok {
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		writeJSON(w, http.StatusBadRequest, httpResult{Error: err.Error()})
+		return
+	}
+
+	fields := pathFields(r, "run/")
+	fields = append(fields, r.Form["arg"]...)
+	keys := make([]string, 0, len(r.Form))
+	for key := range r.Form {
+		if key != "arg" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fields = append(fields, key+"="+r.Form.Get(key))
+	}
+
+	line := strings.Join(fields, " ")
+	result, err := m.run(r.Context(), account, fields)
+	m.audit(r.RemoteAddr, account, line, err)
+	if err != nil {
+		status := http.StatusBadRequest
+		switch err.(type) {
+		case *UnknownCommand:
+			status = http.StatusNotFound
+		case *PermissionDenied:
+			status = http.StatusForbidden
+		}
+		writeJSON(w, status, httpResult{Command: line, Error: err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, httpResult{Command: line, Text: result.Text, Data: result.Data})
+}
+
+// run authorizes and runs the command in fields.
+func (m *Monitor) run(ctx context.Context, account *Account, fields []string) (*Result, error) {
+	if len(fields) == 0 {
+		return nil, &UnknownCommand{}
+	}
+	c := m.registry.GetCommand(fields[0])
+	if c == nil {
+		return nil, &UnknownCommand{Name: fields[0]}
+	}
+	if account != nil {
+		if err := m.access.authorize(m.registry, account, fields); err != nil {
+			return nil, err
+		}
+	}
+	return Run(withRegistry(withSession(ctx, &session{access: m.access, account: account}), m.registry), c, fields[1:])
+}
+
+// authenticate returns the user of request, ok is false if the request is rejected.
+func (m *Monitor) authenticate(w http.ResponseWriter, r *http.Request) (account *Account, ok bool) {
+	if m.access == nil {
+		return nil, true
+	}
+
+	var err error
+	if name, password, basic := r.BasicAuth(); basic {
+		account, err = m.access.Login(name + " " + password)
+	} else {
+		account, err = m.access.LoginToken(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+	}
+	if err != nil {
+		log.Errorf("monitor http login from %s failed", r.RemoteAddr)
+		w.Header().Set("WWW-Authenticate", `Basic realm="monitor"`)
+		writeJSON(w, http.StatusUnauthorized, httpResult{Error: err.Error()})
+		return nil, false
+	}
+	return account, true
+}
+
+func (m *Monitor) audit(addr string, account *Account, line string, err error) {
+	if m.auditor == nil {
+		return
+	}
+
+	r := AuditRecord{Time: time.Now(), Addr: addr, Command: line, Err: err}
+	if account != nil {
+		r.User = account.Name
+	}
+	m.auditor(r)
+}
+
+// pathFields returns the path segments of request after HTTPPrefix+sub.
+func pathFields(r *http.Request, sub string) []string {
+	return strings.FieldsFunc(strings.TrimPrefix(r.URL.Path, HTTPPrefix+sub), func(c rune) bool {
+		return c == '/'
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// httpServer is the http server of admin API.
+type httpServer struct {
+	mutex  sync.Mutex
+	server *http.Server
+}
+
+func (s *httpServer) start(addr string, handler http.Handler) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.server = &http.Server{Addr: addr, Handler: handler}
+	go func(server *http.Server) {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Errorf("monitor http server on %s failed: %v", addr, err)
+		}
+	}(s.server)
+}
+
+func (s *httpServer) stop() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.server != nil {
+		s.server.Close()
+		s.server = nil
+	}
+}
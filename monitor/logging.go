@@ -0,0 +1,127 @@
+package monitor
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/amsalt/engins/logging"
+)
+
+var log = logging.New("monitor")
+
+func init() {
+	RegisterCommand(NewCommand("log", "show and change the log settings, reverted after the time window", showLog,
+		WithGroup("runtime"),
+		WithUsage("log [level|trace|untrace|file]"),
+		WithSubcommands(
+			NewCommand("level", "set the global or package log level", setLogLevel,
+				WithUsage("log level <debug|info|error> [package=name] [for=10m]")),
+			NewCommand("trace", "enable all logs of a connection or user, such as `conn:10.0.0.1:5000` or `user:1001`", traceLog,
+				WithUsage("log trace <key> [for=10m]")),
+			NewCommand("untrace", "disable the logs enabled by trace", untraceLog,
+				WithUsage("log untrace <key>")),
+			NewCommand("file", "write logs to a rotating file, `off` to restore", redirectLog,
+				WithUsage("log file <path|off> [for=10m] [max=megabytes] [backups=3]")),
+		)))
+}
+
+func showLog(ctx context.Context, args *Args) (*Result, error) {
+	s := logging.Default.Settings()
+
+	text := fmt.Sprintf("    %-10s %s\n", "level", s.Level)
+	for pkg, level := range s.Packages {
+		text += fmt.Sprintf("    %-10s %s=%s\n", "package", pkg, level)
+	}
+	for _, key := range s.Traces {
+		text += fmt.Sprintf("    %-10s %s\n", "trace", key)
+	}
+	text += fmt.Sprintf("    %-10s %s\n", "output", s.Output)
+	if len(s.Reverts) > 0 {
+		text += fmt.Sprintf("    %-10s %s\n", "reverting", strings.Join(s.Reverts, ", "))
+	}
+	return NewResult(s, text), nil
+}
+
+// window returns the time window of change, `for=0` keeps the change.
+func window(args *Args) (time.Duration, error) {
+	d, err := time.ParseDuration(args.Option("for", logging.DefaultWindow.String()))
+	if err != nil {
+		return 0, fmt.Errorf("bad option for: %v", err)
+	}
+	return d, nil
+}
+
+func setLogLevel(ctx context.Context, args *Args) (*Result, error) {
+	level, err := logging.ParseLevel(args.Arg(0))
+	if err != nil {
+		return nil, err
+	}
+	d, err := window(args)
+	if err != nil {
+		return nil, err
+	}
+
+	if pkg := args.Option("package", ""); pkg != "" {
+		logging.SetPackageLevel(pkg, level, d)
+		return TextResult("log level of %s set to %s for %v", pkg, level, d), nil
+	}
+	logging.SetLevel(level, d)
+	return TextResult("log level set to %s for %v", level, d), nil
+}
+
+func traceLog(ctx context.Context, args *Args) (*Result, error) {
+	key := args.Arg(0)
+	if key == "" {
+		return nil, fmt.Errorf("trace key is required")
+	}
+	d, err := window(args)
+	if err != nil {
+		return nil, err
+	}
+
+	logging.Trace(key, d)
+	return TextResult("tracing %s for %v", key, d), nil
+}
+
+func untraceLog(ctx context.Context, args *Args) (*Result, error) {
+	key := args.Arg(0)
+	if key == "" {
+		return nil, fmt.Errorf("trace key is required")
+	}
+
+	logging.Untrace(key)
+	return TextResult("untraced %s", key), nil
+}
+
+func redirectLog(ctx context.Context, args *Args) (*Result, error) {
+	path := args.Arg(0)
+	switch path {
+	case "":
+		return nil, fmt.Errorf("file path is required")
+	case "off":
+		logging.Redirect(nil, 0)
+		return TextResult("log output restored"), nil
+	}
+
+	d, err := window(args)
+	if err != nil {
+		return nil, err
+	}
+	maxMB, err := args.Int("max", 100)
+	if err != nil {
+		return nil, err
+	}
+	backups, err := args.Int("backups", 3)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := logging.OpenRotatingFile(path, int64(maxMB)<<20, backups)
+	if err != nil {
+		return nil, err
+	}
+	logging.Redirect(f, d)
+	return TextResult("log output redirected to %s for %v", path, d), nil
+}
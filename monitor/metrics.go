@@ -1,37 +1,11 @@
 package monitor
 
 import (
-	"fmt"
-	"sync"
+	"context"
 )
 
-var metrics map[string]Metric
-var mutex sync.RWMutex
-
-func init() {
-	metrics = make(map[string]Metric)
-	RegisterMetric(&HelpMetric{})
-}
-
-// RegisterMetric registers new Metric to monitor.
-func RegisterMetric(metric Metric) {
-	mutex.Lock()
-	defer mutex.Unlock()
-
-	_, exist := metrics[metric.cmd()]
-	if exist {
-		panic(fmt.Errorf("metric with cmd name %+v has been registered", metric.cmd()))
-	}
-	metrics[metric.cmd()] = metric
-}
-
-func getMetric(cmd string) Metric {
-	mutex.RLock()
-	defer mutex.RUnlock()
-	return metrics[cmd]
-}
-
 // Metric represents a kind of metric.
+// Deprecated: use Command.
 type Metric interface {
 	// cmd the name of command
 	cmd() string
@@ -43,6 +17,16 @@ type Metric interface {
 	run() string
 }
 
+// RegisterMetric registers new Metric to monitor.
+// Deprecated: use RegisterCommand.
+func RegisterMetric(metric Metric) {
+	RegisterCommand(NewCommand(metric.cmd(), metric.desc(), func(ctx context.Context, args *Args) (*Result, error) {
+		return TextResult("%s", metric.run()), nil
+	}))
+}
+
+// HelpMetric lists the commands.
+// Deprecated: use the `help` Command.
 type HelpMetric struct {
 }
 
@@ -51,14 +35,20 @@ func (h *HelpMetric) cmd() string {
 }
 
 func (h *HelpMetric) desc() string {
-	return "type `help` for more information"
+	if c := GetCommand("help"); c != nil {
+		return c.Desc()
+	}
+	return ""
 }
 
 func (h *HelpMetric) run() string {
-	result := "The commands are:\n\n"
-	for n, m := range metrics {
-		result += fmt.Sprintf("    %-15s %s\n", n, m.desc())
+	c := GetCommand("help")
+	if c == nil {
+		return (&UnknownCommand{Name: "help"}).Error()
 	}
-
-	return result
+	result, err := c.Run(withRegistry(context.Background(), Default), ParseArgs(nil))
+	if err != nil {
+		return err.Error()
+	}
+	return result.String()
 }
@@ -0,0 +1,327 @@
+package metrics
+
+// package metrics provides a registry of counters, gauges and histograms with
+// labels, which can be exposed in Prometheus text format.
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultBuckets are the default histogram buckets in seconds.
+var DefaultBuckets = []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Default is the default Registry used by the package level functions.
+var Default = NewRegistry()
+
+// Kind is the kind of a metric.
+type Kind string
+
+const (
+	KindCounter   Kind = "counter"
+	KindGauge     Kind = "gauge"
+	KindHistogram Kind = "histogram"
+)
+
+// Registry holds the metrics by name.
+type Registry struct {
+	mutex   sync.RWMutex
+	metrics map[string]*vec
+}
+
+// NewRegistry creates an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{metrics: make(map[string]*vec)}
+}
+
+// NewCounter is a helper method by using Default registry.
+func NewCounter(name, help string, labels ...string) *CounterVec {
+	return Default.Counter(name, help, labels...)
+}
+
+// NewGauge is a helper method by using Default registry.
+func NewGauge(name, help string, labels ...string) *GaugeVec {
+	return Default.Gauge(name, help, labels...)
+}
+
+// NewHistogram is a helper method by using Default registry.
+func NewHistogram(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	return Default.Histogram(name, help, buckets, labels...)
+}
+
+// Counter returns the counter with name, registers it if not exist.
+// It panics if a metric of another kind or labels registered with the name.
+func (r *Registry) Counter(name, help string, labels ...string) *CounterVec {
+	return &CounterVec{r.register(name, help, KindCounter, nil, labels)}
+}
+
+// Gauge returns the gauge with name, registers it if not exist.
+// It panics if a metric of another kind or labels registered with the name.
+func (r *Registry) Gauge(name, help string, labels ...string) *GaugeVec {
+	return &GaugeVec{r.register(name, help, KindGauge, nil, labels)}
+}
+
+// Histogram returns the histogram with name, registers it if not exist.
+// DefaultBuckets is used if buckets is nil.
+// It panics if a metric of another kind or labels registered with the name.
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	return &HistogramVec{r.register(name, help, KindHistogram, buckets, labels)}
+}
+
+func (r *Registry) register(name, help string, kind Kind, buckets []float64, labels []string) *vec {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if v, exist := r.metrics[name]; exist {
+		if v.kind != kind || strings.Join(v.labels, ",") != strings.Join(labels, ",") {
+			panic(fmt.Errorf("metric %s has been registered as %s with labels %v", name, v.kind, v.labels))
+		}
+		return v
+	}
+
+	v := &vec{name: name, help: help, kind: kind, buckets: buckets, labels: labels, series: make(map[string]*series)}
+	r.metrics[name] = v
+	return v
+}
+
+// Sample represents the value of a metric with labels.
+type Sample struct {
+	Labels map[string]string `json:"labels,omitempty"`
+	Value  float64           `json:"value"`
+
+	// histogram only.
+	Count   uint64    `json:"count,omitempty"`
+	Buckets []float64 `json:"buckets,omitempty"` // upper bounds.
+	Counts  []uint64  `json:"counts,omitempty"`  // cumulative counts of buckets.
+}
+
+// Family represents a metric with all its samples.
+type Family struct {
+	Name    string   `json:"name"`
+	Help    string   `json:"help"`
+	Kind    Kind     `json:"kind"`
+	Samples []Sample `json:"samples"`
+}
+
+// Gather returns the snapshot of all metrics sorted by name.
+func (r *Registry) Gather() []Family {
+	r.mutex.RLock()
+	vecs := make([]*vec, 0, len(r.metrics))
+	for _, v := range r.metrics {
+		vecs = append(vecs, v)
+	}
+	r.mutex.RUnlock()
+
+	sort.Slice(vecs, func(i, j int) bool {
+		return vecs[i].name < vecs[j].name
+	})
+
+	families := make([]Family, len(vecs))
+	for i, v := range vecs {
+		families[i] = v.gather()
+	}
+	return families
+}
+
+// vec is a metric with a series for each combination of label values.
+type vec struct {
+	name    string
+	help    string
+	kind    Kind
+	buckets []float64
+	labels  []string
+
+	mutex  sync.RWMutex
+	series map[string]*series
+}
+
+// series holds the value of a metric with label values.
+type series struct {
+	values []string
+	value  uint64   // float64 bits, the value of counter or gauge, the sum of histogram.
+	counts []uint64 // non-cumulative counts of buckets, the last one is +Inf.
+}
+
+func (v *vec) with(values []string) *series {
+	if len(values) != len(v.labels) {
+		panic(fmt.Errorf("metric %s expects %d label values, found %d", v.name, len(v.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+
+	v.mutex.RLock()
+	s, exist := v.series[key]
+	v.mutex.RUnlock()
+	if exist {
+		return s
+	}
+
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	if s, exist = v.series[key]; !exist {
+		s = &series{values: append([]string(nil), values...)}
+		if v.kind == KindHistogram {
+			s.counts = make([]uint64, len(v.buckets)+1)
+		}
+		v.series[key] = s
+	}
+	return s
+}
+
+func (v *vec) gather() Family {
+	f := Family{Name: v.name, Help: v.help, Kind: v.kind}
+
+	v.mutex.RLock()
+	keys := make([]string, 0, len(v.series))
+	for k := range v.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		s := v.series[k]
+		sample := Sample{Value: s.load()}
+		if len(v.labels) > 0 {
+			sample.Labels = make(map[string]string, len(v.labels))
+			for i, l := range v.labels {
+				sample.Labels[l] = s.values[i]
+			}
+		}
+		if v.kind == KindHistogram {
+			sample.Buckets = v.buckets
+			sample.Counts = make([]uint64, len(v.buckets))
+			var cumulative uint64
+			for i := range s.counts {
+				cumulative += atomic.LoadUint64(&s.counts[i])
+				if i < len(v.buckets) {
+					sample.Counts[i] = cumulative
+				}
+			}
+			sample.Count = cumulative
+		}
+		f.Samples = append(f.Samples, sample)
+	}
+	v.mutex.RUnlock()
+	return f
+}
+
+func (s *series) load() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&s.value))
+}
+
+func (s *series) store(val float64) {
+	atomic.StoreUint64(&s.value, math.Float64bits(val))
+}
+
+func (s *series) add(delta float64) {
+	for {
+		old := atomic.LoadUint64(&s.value)
+		val := math.Float64bits(math.Float64frombits(old) + delta)
+		if atomic.CompareAndSwapUint64(&s.value, old, val) {
+			return
+		}
+	}
+}
+
+// CounterVec is a counter partitioned by labels.
+type CounterVec struct {
+	v *vec
+}
+
+// With returns the counter with label values in the order of label names.
+func (c *CounterVec) With(values ...string) *Counter {
+	return &Counter{c.v.with(values)}
+}
+
+// Counter is a metric only goes up.
+type Counter struct {
+	s *series
+}
+
+// Inc increases the counter by 1.
+func (c *Counter) Inc() {
+	c.s.add(1)
+}
+
+// Add increases the counter by delta, which must not be negative.
+func (c *Counter) Add(delta float64) {
+	if delta < 0 {
+		panic(fmt.Errorf("counter can not decrease: %v", delta))
+	}
+	c.s.add(delta)
+}
+
+// Value returns the current value.
+func (c *Counter) Value() float64 {
+	return c.s.load()
+}
+
+// GaugeVec is a gauge partitioned by labels.
+type GaugeVec struct {
+	v *vec
+}
+
+// With returns the gauge with label values in the order of label names.
+func (g *GaugeVec) With(values ...string) *Gauge {
+	return &Gauge{g.v.with(values)}
+}
+
+// Gauge is a metric can go up and down.
+type Gauge struct {
+	s *series
+}
+
+// Set sets the gauge to val.
+func (g *Gauge) Set(val float64) {
+	g.s.store(val)
+}
+
+// Inc increases the gauge by 1.
+func (g *Gauge) Inc() {
+	g.s.add(1)
+}
+
+// Dec decreases the gauge by 1.
+func (g *Gauge) Dec() {
+	g.s.add(-1)
+}
+
+// Add adds delta to the gauge.
+func (g *Gauge) Add(delta float64) {
+	g.s.add(delta)
+}
+
+// Value returns the current value.
+func (g *Gauge) Value() float64 {
+	return g.s.load()
+}
+
+// HistogramVec is a histogram partitioned by labels.
+type HistogramVec struct {
+	v *vec
+}
+
+// With returns the histogram with label values in the order of label names.
+func (h *HistogramVec) With(values ...string) *Histogram {
+	return &Histogram{s: h.v.with(values), buckets: h.v.buckets}
+}
+
+// Histogram counts observations in buckets.
+type Histogram struct {
+	s       *series
+	buckets []float64
+}
+
+// Observe adds an observation.
+func (h *Histogram) Observe(val float64) {
+	i := sort.SearchFloat64s(h.buckets, val)
+	atomic.AddUint64(&h.s.counts[i], 1)
+	h.s.add(val)
+}
@@ -0,0 +1,89 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/amsalt/engins/pprof"
+)
+
+func init() {
+	pprof.Handle("/metrics", Handler(Default))
+}
+
+// Handler serves the metrics of r in Prometheus text format.
+func Handler(r *Registry) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.WritePrometheus(w)
+	})
+}
+
+// WritePrometheus writes all metrics in Prometheus text format.
+func (r *Registry) WritePrometheus(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	for _, f := range r.Gather() {
+		fmt.Fprintf(bw, "# HELP %s %s\n", f.Name, escape(f.Help, false))
+		fmt.Fprintf(bw, "# TYPE %s %s\n", f.Name, f.Kind)
+		for _, s := range f.Samples {
+			if f.Kind != KindHistogram {
+				fmt.Fprintf(bw, "%s%s %s\n", f.Name, labelsText(s.Labels, "", 0), formatFloat(s.Value))
+				continue
+			}
+			for i, le := range s.Buckets {
+				fmt.Fprintf(bw, "%s_bucket%s %d\n", f.Name, labelsText(s.Labels, "le", le), s.Counts[i])
+			}
+			fmt.Fprintf(bw, "%s_bucket%s %d\n", f.Name, labelsText(s.Labels, "le", math.Inf(1)), s.Count)
+			fmt.Fprintf(bw, "%s_sum%s %s\n", f.Name, labelsText(s.Labels, "", 0), formatFloat(s.Value))
+			fmt.Fprintf(bw, "%s_count%s %d\n", f.Name, labelsText(s.Labels, "", 0), s.Count)
+		}
+	}
+	return bw.Flush()
+}
+
+// labelsText formats labels as `{k="v"}`, with the extra label if not empty.
+func labelsText(labels map[string]string, extra string, extraVal float64) string {
+	if len(labels) == 0 && extra == "" {
+		return ""
+	}
+
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys)+1)
+	for _, k := range keys {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, k, escape(labels[k], true)))
+	}
+	if extra != "" {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, extra, formatFloat(extraVal)))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+func escape(s string, quote bool) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, "\n", `\n`, -1)
+	if quote {
+		s = strings.Replace(s, `"`, `\"`, -1)
+	}
+	return s
+}
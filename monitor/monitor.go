@@ -1,9 +1,10 @@
 package monitor
 
 import (
-	"fmt"
 	"net"
+	"time"
 
+	"github.com/amsalt/engins"
 	"github.com/amsalt/nginet/core"
 	"github.com/amsalt/nginet/core/tcp"
 	"github.com/amsalt/nginet/handler"
@@ -17,16 +18,82 @@ const (
 	MaxConnectionNum = 5
 )
 
+// DefaultIdleTimeout is the time a session is closed after its last command.
+const DefaultIdleTimeout = time.Minute * 10
+
+// MaxLoginAttempts is the number of failed logins before a session is closed.
+const MaxLoginAttempts = 3
+
+// Name is the component name of Monitor.
+const Name = "monitor"
+
 type Monitor struct {
 	server core.AcceptorChannel
 	port   string
+
+	registry    *Registry
+	bind        string
+	access      *Access
+	idleTimeout time.Duration
+	auditor     Auditor
+
+	httpAddr  string
+	http      httpServer
+	pprofHTTP bool
 }
 
-func NewMonitor(port string) *Monitor {
-	m := &Monitor{port: port}
+// Option configures the Monitor.
+type Option func(m *Monitor)
+
+// WithApp serves the commands of app, the ones of the default App by default.
+func WithApp(app *engins.App) Option {
+	return func(m *Monitor) {
+		m.registry = RegistryOf(app)
+	}
+}
+
+// WithBindAddr listens on the host only, such as `127.0.0.1`, all interfaces by default.
+func WithBindAddr(host string) Option {
+	return func(m *Monitor) {
+		m.bind = host
+	}
+}
+
+// WithAccess requires the users to login before running the commands allowed by their roles.
+func WithAccess(access *Access) Option {
+	return func(m *Monitor) {
+		m.access = access
+	}
+}
+
+// WithIdleTimeout closes the sessions idle for d, 0 disables it.
+func WithIdleTimeout(d time.Duration) Option {
+	return func(m *Monitor) {
+		m.idleTimeout = d
+	}
+}
+
+// WithAuditor receives the record of every command run, LogAuditor by default.
+func WithAuditor(auditor Auditor) Option {
+	return func(m *Monitor) {
+		m.auditor = auditor
+	}
+}
+
+func NewMonitor(port string, opts ...Option) *Monitor {
+	m := &Monitor{port: port, registry: Default, idleTimeout: DefaultIdleTimeout, auditor: LogAuditor}
+	for _, opt := range opts {
+		opt(m)
+	}
+	m.handlePprofHTTP()
 	return m
 }
 
+// Name returns the component name of the Monitor.
+func (m *Monitor) Name() string {
+	return Name
+}
+
 func (m *Monitor) Init() {
 	m.server = core.GetAcceptorBuilder(core.TCPServBuilder).Build(
 		tcp.WithReadBufSize(ReadBufferSize),
@@ -36,20 +103,23 @@ func (m *Monitor) Init() {
 
 	m.server.InitSubChannel(func(channel core.SubChannel) {
 		channel.Pipeline().AddLast(nil, "StringEncoder", handler.NewStringEncoder())
-		channel.Pipeline().AddLast(nil, "TextHandler", NewTextHandler())
+		channel.Pipeline().AddLast(nil, "TextHandler", m.newTextHandler())
 	})
 }
 
 func (m *Monitor) Start() {
-	addr, err := net.ResolveTCPAddr("tcp", fmt.Sprintf(":%s", m.port))
+	addr, err := net.ResolveTCPAddr("tcp", net.JoinHostPort(m.bind, m.port))
 	if err != nil {
 		panic("bad net addr")
 	}
 
 	m.server.Listen(addr)
 	go m.server.Accept()
+
+	m.serveHTTP()
 }
 
 func (m *Monitor) Stop() {
+	m.http.stop()
 	m.server.Close()
 }
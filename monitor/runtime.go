@@ -0,0 +1,161 @@
+package monitor
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"runtime/pprof"
+	"strings"
+	"time"
+
+	"github.com/amsalt/engins"
+)
+
+// startTime is the time the process started, approximately.
+var startTime = time.Now()
+
+func init() {
+	RegisterCommand(NewCommand("goroutines", "show the number of goroutines, or their stacks", goroutines,
+		WithUsage("goroutines [stack] [filter=text]"), WithGroup("runtime")))
+	RegisterCommand(NewCommand("memstats", "show the summary of memory statistics", memstats,
+		WithGroup("runtime")))
+	RegisterCommand(NewCommand("gc", "show the statistics of garbage collection", gcstats,
+		WithGroup("runtime"),
+		WithSubcommands(NewCommand("run", "force a garbage collection", gc, WithUsage("gc run")))))
+	RegisterCommand(NewCommand("version", "show the version and build information", version,
+		WithGroup("runtime")))
+	RegisterCommand(NewCommand("uptime", "show how long the process has been running", uptime,
+		WithGroup("runtime")))
+}
+
+func goroutines(ctx context.Context, args *Args) (*Result, error) {
+	count := runtime.NumGoroutine()
+	if args.Arg(0) != "stack" {
+		return NewResult(count, fmt.Sprintf("goroutines: %d", count)), nil
+	}
+
+	var buf bytes.Buffer
+	if err := pprof.Lookup("goroutine").WriteTo(&buf, 2); err != nil {
+		return nil, err
+	}
+
+	filter := args.Option("filter", "")
+	var stacks []string
+	for _, stack := range strings.Split(buf.String(), "\n\n") {
+		if stack = strings.TrimSpace(stack); stack != "" && strings.Contains(stack, filter) {
+			stacks = append(stacks, stack)
+		}
+	}
+	text := fmt.Sprintf("goroutines: %d, matched: %d\n\n%s", count, len(stacks), strings.Join(stacks, "\n\n"))
+	return NewResult(stacks, text), nil
+}
+
+func memstats(ctx context.Context, args *Args) (*Result, error) {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+
+	text := fmt.Sprintf("    %-15s %s\n", "Alloc", bytesSize(m.Alloc))
+	text += fmt.Sprintf("    %-15s %s\n", "TotalAlloc", bytesSize(m.TotalAlloc))
+	text += fmt.Sprintf("    %-15s %s\n", "Sys", bytesSize(m.Sys))
+	text += fmt.Sprintf("    %-15s %s\n", "HeapAlloc", bytesSize(m.HeapAlloc))
+	text += fmt.Sprintf("    %-15s %s\n", "HeapSys", bytesSize(m.HeapSys))
+	text += fmt.Sprintf("    %-15s %s\n", "HeapIdle", bytesSize(m.HeapIdle))
+	text += fmt.Sprintf("    %-15s %s\n", "HeapInuse", bytesSize(m.HeapInuse))
+	text += fmt.Sprintf("    %-15s %s\n", "HeapReleased", bytesSize(m.HeapReleased))
+	text += fmt.Sprintf("    %-15s %d\n", "HeapObjects", m.HeapObjects)
+	text += fmt.Sprintf("    %-15s %s\n", "StackInuse", bytesSize(m.StackInuse))
+	text += fmt.Sprintf("    %-15s %d\n", "Mallocs", m.Mallocs)
+	text += fmt.Sprintf("    %-15s %d\n", "Frees", m.Frees)
+	text += fmt.Sprintf("    %-15s %d\n", "NumGC", m.NumGC)
+
+	return NewResult(m, text), nil
+}
+
+func gcstats(ctx context.Context, args *Args) (*Result, error) {
+	var s debug.GCStats
+	s.PauseQuantiles = make([]time.Duration, 5)
+	debug.ReadGCStats(&s)
+
+	text := fmt.Sprintf("    %-15s %d\n", "NumGC", s.NumGC)
+	text += fmt.Sprintf("    %-15s %v\n", "LastGC", s.LastGC.Format(time.RFC3339))
+	text += fmt.Sprintf("    %-15s %v\n", "PauseTotal", s.PauseTotal)
+	text += fmt.Sprintf("    %-15s %v\n", "PauseMin", s.PauseQuantiles[0])
+	text += fmt.Sprintf("    %-15s %v\n", "PauseMedian", s.PauseQuantiles[2])
+	text += fmt.Sprintf("    %-15s %v\n", "PauseMax", s.PauseQuantiles[4])
+
+	return NewResult(s, text), nil
+}
+
+func gc(ctx context.Context, args *Args) (*Result, error) {
+	start := time.Now()
+	runtime.GC()
+	elapsed := time.Since(start)
+	return NewResult(elapsed, fmt.Sprintf("gc done in %v", elapsed)), nil
+}
+
+// versionInfo is the structured output of version.
+type versionInfo struct {
+	Engins    string            `json:"engins"`
+	GoVersion string            `json:"go_version"`
+	Path      string            `json:"path,omitempty"`
+	Version   string            `json:"version,omitempty"`
+	Settings  map[string]string `json:"settings,omitempty"`
+}
+
+func version(ctx context.Context, args *Args) (*Result, error) {
+	info := versionInfo{Engins: engins.Version, GoVersion: runtime.Version()}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		info.Path = bi.Main.Path
+		info.Version = bi.Main.Version
+		info.Settings = make(map[string]string)
+		for _, s := range bi.Settings {
+			info.Settings[s.Key] = s.Value
+		}
+	}
+
+	text := fmt.Sprintf("    %-15s %s\n", "engins", info.Engins)
+	text += fmt.Sprintf("    %-15s %s\n", "go", info.GoVersion)
+	text += fmt.Sprintf("    %-15s %s %s\n", "main", info.Path, info.Version)
+	for _, key := range []string{"vcs.revision", "vcs.time", "vcs.modified"} {
+		if v, ok := info.Settings[key]; ok {
+			text += fmt.Sprintf("    %-15s %s\n", key, v)
+		}
+	}
+	return NewResult(info, text), nil
+}
+
+func drain(app *engins.App) Action {
+	return func(ctx context.Context, args *Args) (*Result, error) {
+		timeout, err := time.ParseDuration(args.Option("timeout", engins.DrainTimeout.String()))
+		if err != nil {
+			return nil, fmt.Errorf("bad option timeout: %v", err)
+		}
+		if app.ShuttingDown() {
+			return nil, fmt.Errorf("already shutting down")
+		}
+
+		go app.GracefulShutdown(timeout)
+		return TextResult("draining, shutting down within %v", timeout), nil
+	}
+}
+
+func uptime(ctx context.Context, args *Args) (*Result, error) {
+	d := time.Since(startTime)
+	text := fmt.Sprintf("up %v, since %s", d.Truncate(time.Second), startTime.Format(time.RFC3339))
+	return NewResult(d.Seconds(), text), nil
+}
+
+func bytesSize(b uint64) string {
+	const unit = 1024
+	if b < unit {
+		return fmt.Sprintf("%d B", b)
+	}
+	div, exp := uint64(unit), 0
+	for n := b / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.2f %cB", float64(b)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,70 @@
+package monitor
+
+import (
+	"context"
+	"fmt"
+)
+
+// StreamBuffer is the number of outputs a console buffers for a streaming
+// command, the outputs are dropped rather than blocking when it's full.
+const StreamBuffer = 64
+
+type sessionKey struct{}
+
+// session is the caller of a command, carried by the context of command.
+type session struct {
+	access  *Access
+	account *Account
+	stream  func(text string)
+}
+
+func withSession(ctx context.Context, s *session) context.Context {
+	return context.WithValue(ctx, sessionKey{}, s)
+}
+
+func sessionOf(ctx context.Context) *session {
+	s, _ := ctx.Value(sessionKey{}).(*session)
+	return s
+}
+
+// Stream returns the function to write the output of a streaming command to
+// the console, nil if the caller doesn't support streaming such as HTTP.
+func Stream(ctx context.Context) func(text string) {
+	if s := sessionOf(ctx); s != nil {
+		return s.stream
+	}
+	return nil
+}
+
+// Authorize checks whether the caller of ctx is allowed to run the command in
+// fields, for the commands running other commands such as `watch`.
+func Authorize(ctx context.Context, fields []string) error {
+	s := sessionOf(ctx)
+	if s == nil || s.access == nil || s.account == nil {
+		return nil
+	}
+	return s.access.authorize(RegistryFrom(ctx), s.account, fields)
+}
+
+// isStreaming returns whether the command in fields is streaming.
+func isStreaming(r *Registry, fields []string) bool {
+	if len(fields) == 0 {
+		return false
+	}
+	c := r.GetCommand(fields[0])
+	if c == nil {
+		return false
+	}
+	for _, name := range fields[1:] {
+		sub := subcommand(c, name)
+		if sub == nil {
+			break
+		}
+		c = sub
+	}
+	s, ok := c.(Streaming)
+	return ok && s.Streaming()
+}
+
+// errNoStream is returned by streaming commands called without console.
+var errNoStream = fmt.Errorf("streaming is only supported by console")
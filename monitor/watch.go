@@ -0,0 +1,113 @@
+package monitor
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/amsalt/engins/components"
+	"github.com/amsalt/engins/monitor/events"
+)
+
+// MinWatchInterval is the minimum interval of `watch`.
+const MinWatchInterval = time.Millisecond * 100
+
+func init() {
+	RegisterCommand(NewCommand("watch", "run a command periodically until next input", watch,
+		WithUsage("watch <interval> <command> [args...]"), WithGroup("console"), WithStreaming()))
+	RegisterCommand(NewCommand("tail", "show the live events until next input", tail,
+		WithUsage("tail [source=prefix]"), WithGroup("console"), WithStreaming()))
+
+	components.OnStateChange(func(name string, from, to components.State) {
+		events.Publish("components", "%s %s -> %s", name, from, to)
+	})
+}
+
+func watch(ctx context.Context, args *Args) (*Result, error) {
+	stream := Stream(ctx)
+	if stream == nil {
+		return nil, errNoStream
+	}
+	if len(args.Positional) < 2 {
+		return nil, fmt.Errorf("usage: watch <interval> <command> [args...]")
+	}
+
+	interval, err := parseInterval(args.Arg(0))
+	if err != nil {
+		return nil, err
+	}
+
+	fields := args.Rest(1)
+	c := RegistryFrom(ctx).GetCommand(fields[0])
+	if c == nil {
+		return nil, &UnknownCommand{Name: fields[0]}
+	}
+	if isStreaming(RegistryFrom(ctx), fields) {
+		return nil, fmt.Errorf("can't watch streaming command %s", fields[0])
+	}
+	if err := Authorize(ctx, fields); err != nil {
+		return nil, err
+	}
+
+	line := strings.Join(fields, " ")
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		header := fmt.Sprintf("every %v: %s    %s\n", interval, line, time.Now().Format("15:04:05"))
+		result, err := Run(ctx, c, fields[1:])
+		if err != nil {
+			stream(fmt.Sprintf("%serror: %v\n\n", header, err))
+		} else {
+			stream(fmt.Sprintf("%s%s\n\n", header, result))
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// parseInterval parses the interval such as `500ms`, `2s` or `2` in seconds.
+func parseInterval(s string) (time.Duration, error) {
+	interval, err := time.ParseDuration(s)
+	if err != nil {
+		seconds, convErr := strconv.ParseFloat(s, 64)
+		if convErr != nil {
+			return 0, fmt.Errorf("bad interval %s", s)
+		}
+		interval = time.Duration(seconds * float64(time.Second))
+	}
+	if interval < MinWatchInterval {
+		return 0, fmt.Errorf("interval must be at least %v", MinWatchInterval)
+	}
+	return interval, nil
+}
+
+func tail(ctx context.Context, args *Args) (*Result, error) {
+	stream := Stream(ctx)
+	if stream == nil {
+		return nil, errNoStream
+	}
+
+	filter := args.Option("source", "")
+	sub := events.Subscribe(events.DefaultBuffer)
+	defer sub.Close()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, nil
+		case e := <-sub.Events():
+			if dropped := sub.Dropped(); dropped > 0 {
+				stream(fmt.Sprintf("(%d events dropped)\n", dropped))
+			}
+			if strings.HasPrefix(e.Source, filter) {
+				stream(e.String() + "\n")
+			}
+		}
+	}
+}
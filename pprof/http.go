@@ -4,8 +4,21 @@ import (
 	"log"
 	"net/http"
 	"net/http/pprof"
+	"sync"
 )
 
+var handlers = make(map[string]http.Handler)
+var mutex sync.RWMutex
+
+// Handle registers the handler for the pattern to the http server for pprof,
+// it must be called before the server started.
+func Handle(pattern string, handler http.Handler) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	handlers[pattern] = handler
+}
+
 func httpServePprof(addr string) {
 	pprofServer := http.NewServeMux()
 	pprofServer.HandleFunc("/debug/pprof/", pprof.Index)
@@ -14,6 +27,12 @@ func httpServePprof(addr string) {
 	pprofServer.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
 	pprofServer.HandleFunc("/debug/pprof/trace", pprof.Trace)
 
+	mutex.RLock()
+	for pattern, handler := range handlers {
+		pprofServer.Handle(pattern, handler)
+	}
+	mutex.RUnlock()
+
 	if err := http.ListenAndServe(addr, pprofServer); err != nil {
 		log.Printf("http.ListenAndServe init %+v error: %+v ", addr, err)
 		panic(err)
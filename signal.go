@@ -0,0 +1,159 @@
+package engins
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime/pprof"
+	"time"
+)
+
+// DumpDir is the directory where DumpHandler writes dump files.
+// If empty, os.TempDir() is used.
+var DumpDir string
+
+// DrainTimeout is the deadline for components to drain by DrainHandler and GracefulShutdownHandler.
+var DrainTimeout = time.Minute
+
+// SignalHandler handles a signal received by a running App.
+type SignalHandler func(app *App, sig os.Signal)
+
+// HandleSignal is a helper method by using default App.
+func HandleSignal(sig os.Signal, h SignalHandler) {
+	defaultApp.HandleSignal(sig, h)
+}
+
+// HandleSignal registers the handler of sig, replacing the previous one.
+// A nil handler stops handling sig, and restores its default behavior if the App is running.
+func (a *App) HandleSignal(sig os.Signal, h SignalHandler) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if h == nil {
+		delete(a.signals, sig)
+		if a.sigChan != nil {
+			signal.Reset(sig)
+		}
+		return
+	}
+	a.signals[sig] = h
+	if a.sigChan != nil {
+		signal.Notify(a.sigChan, sig)
+	}
+}
+
+// signalHandler returns the handler of sig.
+func (a *App) signalHandler(sig os.Signal) SignalHandler {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	return a.signals[sig]
+}
+
+// handledSignals returns all signals with a handler.
+func (a *App) handledSignals() []os.Signal {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	sigs := make([]os.Signal, 0, len(a.signals))
+	for sig := range a.signals {
+		sigs = append(sigs, sig)
+	}
+	return sigs
+}
+
+// dispatchSignals calls the handlers of received signals until done is closed.
+func (a *App) dispatchSignals(c <-chan os.Signal, done <-chan struct{}) {
+	for {
+		select {
+		case sig := <-c:
+			h := a.signalHandler(sig)
+			if h == nil {
+				continue
+			}
+			log.Infof("engins received signal: %v", sig)
+			go h(a, sig)
+		case <-done:
+			return
+		}
+	}
+}
+
+// ShutdownHandler stops the App gracefully.
+func ShutdownHandler(app *App, sig os.Signal) {
+	app.Shutdown()
+}
+
+// InterruptHandler stops the App gracefully, and exits the process immediately
+// when received again during shutting down.
+func InterruptHandler(app *App, sig os.Signal) {
+	if app.ShuttingDown() {
+		log.Errorf("engins forced to exit (signal: %v)", sig)
+		os.Exit(1)
+	}
+	app.Shutdown()
+}
+
+// ReloadHandler reloads the components implementing components.Reloadable.
+func ReloadHandler(app *App, sig os.Signal) {
+	if err := app.Components().Reload(); err != nil {
+		log.Errorf("engins reload failed: %v", err)
+	}
+}
+
+// DumpHandler dumps goroutine stacks and components state to a file in DumpDir.
+func DumpHandler(app *App, sig os.Signal) {
+	path, err := app.Dump(DumpDir)
+	if err != nil {
+		log.Errorf("engins dump failed: %v", err)
+		return
+	}
+	log.Infof("engins dumped to %s", path)
+}
+
+// DrainHandler drains the components implementing components.Drainable within DrainTimeout.
+func DrainHandler(app *App, sig os.Signal) {
+	ctx, cancel := context.WithTimeout(context.Background(), DrainTimeout)
+	defer cancel()
+
+	if err := app.Components().Drain(ctx); err != nil {
+		log.Errorf("engins drain failed: %v", err)
+	}
+}
+
+// GracefulShutdownHandler drains the components within DrainTimeout, then stops the App.
+// It handles no signal by default, register it by HandleSignal, such as for SIGTERM.
+func GracefulShutdownHandler(app *App, sig os.Signal) {
+	app.GracefulShutdown(DrainTimeout)
+}
+
+// Dump writes goroutine stacks and components state to a new file in dir,
+// and returns the path of the file.
+func (a *App) Dump(dir string) (string, error) {
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	now := time.Now()
+	path := filepath.Join(dir, fmt.Sprintf("engins-%d-%s.dump", os.Getpid(), now.Format("20060102-150405")))
+
+	f, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	fmt.Fprintf(f, "engins %s dump at %s\n\n", Version, now.Format(time.RFC3339))
+	fmt.Fprintf(f, "components:\n")
+	for _, s := range a.Components().Statuses() {
+		fmt.Fprintf(f, "    %-20s %-12s since %s, restarts %d, last error: %v\n",
+			s.Name, s.State, s.Since.Format(time.RFC3339), s.Restarts, s.LastError)
+	}
+
+	fmt.Fprintf(f, "\ngoroutines:\n")
+	if err := pprof.Lookup("goroutine").WriteTo(f, 2); err != nil {
+		return "", err
+	}
+	return path, nil
+}
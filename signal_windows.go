@@ -0,0 +1,15 @@
+//go:build windows
+
+package engins
+
+import (
+	"os"
+	"syscall"
+)
+
+func defaultSignalHandlers() map[os.Signal]SignalHandler {
+	return map[os.Signal]SignalHandler{
+		syscall.SIGINT:  InterruptHandler,
+		syscall.SIGTERM: ShutdownHandler,
+	}
+}
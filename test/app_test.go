@@ -0,0 +1,76 @@
+package test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/amsalt/engins"
+	"github.com/amsalt/engins/cluster"
+	"github.com/amsalt/engins/components"
+	"github.com/amsalt/engins/monitor"
+	"github.com/amsalt/ngicluster/resolver/static"
+)
+
+func TestAppIsolation(t *testing.T) {
+	gate := engins.NewApp()
+	game := engins.NewApp()
+
+	// components with the same name run in isolated Apps.
+	if err := gate.Start(&namedComponent{name: "test-cluster"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := game.Start(&namedComponent{name: "test-cluster"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := gate.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if s := game.Components().Statuses(); len(s) != 1 || s[0].State != components.StateRunning {
+		t.Fatalf("expect component of game running, found %+v", s)
+	}
+	if err := game.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if gate.Register() == engins.Default().Register() || gate.Components() == engins.Default().Components() {
+		t.Fatal("expect App not sharing with the default App")
+	}
+}
+
+func TestAppMonitorIsolation(t *testing.T) {
+	gate, game := engins.NewApp(), engins.NewApp()
+	cluster.NewClusterWithApp(gate, static.NewConfigBasedResolver())
+	cluster.NewClusterWithApp(game, static.NewConfigBasedResolver())
+	if err := game.Start(&namedComponent{name: "test-game"}); err != nil {
+		t.Fatal(err)
+	}
+	defer game.Stop()
+
+	gateCommands, gameCommands := monitor.RegistryOf(gate), monitor.RegistryOf(game)
+	if gateCommands == gameCommands || gateCommands == monitor.Default || monitor.RegistryOf(engins.Default()) != monitor.Default {
+		t.Fatal("expect a registry of every App")
+	}
+	if gateCommands.GetCommand("cluster") == gameCommands.GetCommand("cluster") {
+		t.Fatal("expect cluster command of every App")
+	}
+
+	result, err := gameCommands.Execute(context.Background(), "components")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(result.String(), "test-game") {
+		t.Fatalf("expect components of game, found %q", result.String())
+	}
+	if result, _ = gateCommands.Execute(context.Background(), "components"); strings.Contains(result.String(), "test-game") {
+		t.Fatalf("expect no components of game in gate, found %q", result.String())
+	}
+
+	// process wide commands are shared.
+	if _, err := gateCommands.Execute(context.Background(), "goroutines"); err != nil {
+		t.Fatal(err)
+	}
+	if result, _ = gameCommands.Execute(context.Background(), "help"); !strings.Contains(result.String(), "cluster") {
+		t.Fatalf("expect help of game listing cluster, found %q", result.String())
+	}
+}
@@ -0,0 +1,1332 @@
+package test
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/amsalt/engins"
+	"github.com/amsalt/engins/cluster"
+	"github.com/amsalt/engins/database"
+	"github.com/amsalt/engins/errs"
+	"github.com/amsalt/engins/monitor"
+	"github.com/amsalt/ngicluster/balancer"
+	"github.com/amsalt/ngicluster/balancer/stickiness"
+	"github.com/amsalt/ngicluster/resolver/static"
+	"github.com/amsalt/nginet/core"
+)
+
+func TestClusterExec(t *testing.T) {
+	app := engins.NewApp()
+	c := cluster.NewClusterWithApp(app, static.NewConfigBasedResolver())
+	if results := c.Exec(context.Background(), "game", []string{"version"}, time.Second); len(results) != 0 {
+		t.Fatalf("expect no results without nodes, found %+v", results)
+	}
+
+	commands := monitor.RegistryOf(app)
+	result, err := commands.Execute(context.Background(), "cluster exec game version")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(result.String(), "no connected nodes") {
+		t.Fatalf("unexpected result: %s", result)
+	}
+
+	if _, err := commands.Execute(context.Background(), "cluster exec game unknown"); err == nil {
+		t.Fatal("expect unknown command")
+	}
+
+	// the nodes run status commands only by default.
+	for line, allowed := range map[string]bool{"version": true, "gc": true, "gc run": false, "drain": false,
+		"log file /tmp/x": false} {
+		if commands.Allowed(cluster.DefaultRemoteCommands, strings.Fields(line)) != allowed {
+			t.Fatalf("expect %s allowed %v", line, allowed)
+		}
+	}
+	opts := &cluster.ConfigOpts{}
+	cluster.WithRemoteMonitor()(opts)
+	if len(opts.RemoteMonitor) != len(cluster.DefaultRemoteCommands) {
+		t.Fatalf("expect default remote commands, found %v", opts.RemoteMonitor)
+	}
+	cluster.WithRemoteMonitor("gc *")(opts)
+	if !commands.Allowed(opts.RemoteMonitor, []string{"gc", "run"}) || commands.Allowed(opts.RemoteMonitor, []string{"drain"}) {
+		t.Fatalf("expect gc subcommands allowed only, found %v", opts.RemoteMonitor)
+	}
+}
+
+type echoRequest struct {
+	Text string
+}
+
+func TestClusterCall(t *testing.T) {
+	app := engins.NewApp()
+	app.RegisterMsgByID(9101, &echoRequest{})
+
+	var received *echoRequest
+	var responder *cluster.Responder
+	app.RegisterProcessorByID(9101, func(ctx *core.ChannelContext, msg interface{}, args ...interface{}) {
+		received, _ = msg.(*echoRequest)
+		responder = cluster.ResponderOf(args)
+	})
+
+	if typ, ok := app.MessageType(9101.0); !ok || typ != reflect.TypeOf(&echoRequest{}) {
+		t.Fatalf("expect message type by decoded id, found %v", typ)
+	}
+	if err := app.Process(nil, "9101", &echoRequest{Text: "hi"}); err != nil {
+		t.Fatal(err)
+	}
+	if received == nil || received.Text != "hi" || responder != nil {
+		t.Fatalf("unexpected processed message %+v, responder %v", received, responder)
+	}
+	if err := app.Process(nil, 9102, &echoRequest{}); err == nil {
+		t.Fatal("expect no processor")
+	}
+
+	c := cluster.NewClusterWithApp(app, static.NewConfigBasedResolver())
+	if _, err := c.Call("game", &echoRequest{Text: "hi"}, time.Second); err == nil {
+		t.Fatal("expect no route")
+	} else if _, ok := err.(*errs.NoRoute); !ok {
+		t.Fatalf("expect *errs.NoRoute, found %T", err)
+	}
+	if _, err := c.Call("game", &tcpChannel{}, time.Second); err == nil {
+		t.Fatal("expect unregistered message")
+	}
+}
+
+type echoResponse struct {
+	Text string
+}
+
+// waitFor waits at most 3 seconds for cond.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second * 3); !cond(); time.Sleep(time.Millisecond * 10) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %s", what)
+		}
+	}
+}
+
+// serveNode starts app with a Cluster serving servName on addr.
+func serveNode(t *testing.T, app *engins.App, servName string, addr string, opt ...cluster.BuildOption) *cluster.Cluster {
+	t.Helper()
+	c := cluster.NewClusterWithApp(app, static.NewConfigBasedResolver())
+	c.BuildServer(servName, addr, core.TCPServBuilder, opt...)
+	if err := app.Start(c); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// connectNode starts app with a Cluster connecting to servName on addr as name,
+// and waits for connected.
+func connectNode(t *testing.T, app *engins.App, servName string, addr string, name string, opt ...cluster.BuildOption) *cluster.Cluster {
+	t.Helper()
+	resolver := static.NewConfigBasedResolver()
+	resolver.Register(servName, addr)
+	c := cluster.NewClusterWithApp(app, resolver)
+	b := balancer.GetBuilder(stickiness.Name).Build(stickiness.WithServName(servName), stickiness.WithResolver(resolver))
+	c.BuildClient(servName, name, append([]cluster.BuildOption{cluster.WithBalancer(b)}, opt...)...)
+	if err := app.Start(c); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "connected to "+servName, func() bool { return len(c.Clients(servName)) > 0 })
+	return c
+}
+
+// inlineExecutor runs the functions in place and counts them.
+type inlineExecutor struct {
+	executed int32
+}
+
+func (e *inlineExecutor) Execute(f func()) {
+	atomic.AddInt32(&e.executed, 1)
+	f()
+}
+
+func registerEcho(app *engins.App) {
+	app.RegisterMsgByID(9101, &echoRequest{})
+	app.RegisterMsgByID(9103, &echoResponse{})
+}
+
+func TestClusterCallRoundTrip(t *testing.T) {
+	game := engins.NewApp()
+	registerEcho(game)
+	game.RegisterProcessorByID(9101, func(ctx *core.ChannelContext, msg interface{}, args ...interface{}) {
+		req, r := msg.(*echoRequest), cluster.ResponderOf(args)
+		switch req.Text {
+		case "fail":
+			r.Fail(errors.New("bad request"))
+		case "ignore":
+		default:
+			r.Reply(&echoResponse{Text: "echo " + req.Text})
+		}
+	})
+	serveNode(t, game, "game", "127.0.0.1:17911")
+	defer game.Stop()
+
+	gate := engins.NewApp()
+	registerEcho(gate)
+	executor := &inlineExecutor{}
+	c := connectNode(t, gate, "game", "127.0.0.1:17911", "gate", cluster.WithExecutor(executor))
+	defer gate.Stop()
+
+	resp, err := c.Call("game", &echoRequest{Text: "hi"}, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, ok := resp.(*echoResponse); !ok || r.Text != "echo hi" {
+		t.Fatalf("unexpected response %+v", resp)
+	}
+
+	if _, err := c.Call("game", &echoRequest{Text: "fail"}, time.Second); err == nil {
+		t.Fatal("expect remote error")
+	} else if e, ok := err.(*errs.RemoteError); !ok || !strings.Contains(e.Error(), "bad request") {
+		t.Fatalf("expect *errs.RemoteError, found %T %v", err, err)
+	}
+	if _, err := c.Call("game", &echoRequest{Text: "ignore"}, time.Millisecond*200); err == nil {
+		t.Fatal("expect timeout")
+	} else if _, ok := err.(*errs.CallTimeout); !ok {
+		t.Fatalf("expect *errs.CallTimeout, found %T %v", err, err)
+	}
+
+	// Go calls back on the executor of client.
+	done := make(chan interface{}, 1)
+	before := atomic.LoadInt32(&executor.executed)
+	c.Go("game", &echoRequest{Text: "async"}, time.Second, func(resp interface{}, err error) {
+		if err != nil {
+			done <- err
+			return
+		}
+		done <- resp
+	})
+	select {
+	case resp := <-done:
+		if r, ok := resp.(*echoResponse); !ok || r.Text != "echo async" {
+			t.Fatalf("unexpected response %+v", resp)
+		}
+	case <-time.After(time.Second * 2):
+		t.Fatal("expect callback of Go")
+	}
+	if atomic.LoadInt32(&executor.executed) == before {
+		t.Fatal("expect callback run on the executor")
+	}
+}
+
+func TestClusterCallSpoofed(t *testing.T) {
+	held := make(chan *cluster.Responder, 1)
+	game := engins.NewApp()
+	registerEcho(game)
+	game.RegisterProcessorByID(9101, func(ctx *core.ChannelContext, msg interface{}, args ...interface{}) {
+		held <- cluster.ResponderOf(args)
+	})
+	serveNode(t, game, "game", "127.0.0.1:17935")
+	defer game.Stop()
+	chat := engins.NewApp()
+	chatCluster := serveNode(t, chat, "chat", "127.0.0.1:17936")
+	defer chat.Stop()
+
+	gate := engins.NewApp()
+	registerEcho(gate)
+	resolver := static.NewConfigBasedResolver()
+	resolver.Register("game", "127.0.0.1:17935")
+	resolver.Register("chat", "127.0.0.1:17936")
+	c := cluster.NewClusterWithApp(gate, resolver)
+	c.BuildClient("game", "gate")
+	c.BuildClient("chat", "gate")
+	if err := gate.Start(c); err != nil {
+		t.Fatal(err)
+	}
+	defer gate.Stop()
+	waitFor(t, "connected to game and chat", func() bool { return len(c.Clients("game")) > 0 && len(c.Clients("chat")) > 0 })
+
+	done := make(chan interface{}, 1)
+	go func() {
+		resp, err := c.Call("game", &echoRequest{Text: "hi"}, time.Second*2)
+		if err != nil {
+			done <- err
+			return
+		}
+		done <- resp
+	}()
+	var r *cluster.Responder
+	select {
+	case r = <-held:
+	case <-time.After(time.Second):
+		t.Fatal("expect request held by game")
+	}
+
+	// chat responds to the calls of gate sent to game.
+	waitFor(t, "gate identified by chat", func() bool {
+		return chatCluster.Write("gate", &cluster.RPCResponse{ID: 1, Error: "spoofed"}) == nil
+	})
+	for id := uint64(2); id <= 10; id++ {
+		chatCluster.Write("gate", &cluster.RPCResponse{ID: id, Error: "spoofed"})
+	}
+	time.Sleep(time.Millisecond * 100)
+	r.Reply(&echoResponse{Text: "echo hi"})
+
+	select {
+	case resp := <-done:
+		if e, ok := resp.(*echoResponse); !ok || e.Text != "echo hi" {
+			t.Fatalf("expect response of game only, found %v", resp)
+		}
+	case <-time.After(time.Second * 3):
+		t.Fatal("expect response of call")
+	}
+}
+
+func TestClusterCallRelayed(t *testing.T) {
+	game := engins.NewApp()
+	registerEcho(game)
+	game.RegisterProcessorByID(9101, func(ctx *core.ChannelContext, msg interface{}, args ...interface{}) {
+		cluster.ResponderOf(args).Reply(&echoResponse{Text: "game " + msg.(*echoRequest).Text})
+	})
+	serveNode(t, game, "game", "127.0.0.1:17913")
+	defer game.Stop()
+
+	// the gate relays the requests of 9101 from players to game.
+	gate := engins.NewApp()
+	registerEcho(gate)
+	resolver := static.NewConfigBasedResolver()
+	resolver.Register("game", "127.0.0.1:17913")
+	gateCluster := cluster.NewClusterWithApp(gate, resolver)
+	gateCluster.RegisterRelayRouter(9101, "game")
+	gateCluster.BuildServer("gate", "127.0.0.1:17914", core.TCPServBuilder, cluster.WithServerRelay(true))
+	gateCluster.BuildClient("game", "gate", cluster.WithBalancer(balancer.GetBuilder(stickiness.Name).Build(
+		stickiness.WithServName("game"), stickiness.WithResolver(resolver))))
+	if err := gate.Start(gateCluster); err != nil {
+		t.Fatal(err)
+	}
+	defer gate.Stop()
+	waitFor(t, "gate connected to game", func() bool { return len(gateCluster.Clients("game")) > 0 })
+
+	player := engins.NewApp()
+	registerEcho(player)
+	c := connectNode(t, player, "gate", "127.0.0.1:17914", "player")
+	defer player.Stop()
+
+	resp, err := c.Call("gate", &echoRequest{Text: "hi"}, time.Second, uint64(1001))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, ok := resp.(*echoResponse); !ok || r.Text != "game hi" {
+		t.Fatalf("unexpected relayed response %+v", resp)
+	}
+}
+
+func TestClusterRelayRoutePicked(t *testing.T) {
+	// the room nodes reply the requests and report the messages received by their names.
+	received := make(chan string, 16)
+	for name, addr := range map[string]string{"room-1": "127.0.0.1:17927", "room-2": "127.0.0.1:17928"} {
+		name := name
+		room := engins.NewApp()
+		registerEcho(room)
+		room.RegisterProcessorByID(9101, func(ctx *core.ChannelContext, msg interface{}, args ...interface{}) {
+			if r := cluster.ResponderOf(args); r != nil {
+				r.Reply(&echoResponse{Text: name})
+				return
+			}
+			received <- name
+		})
+		serveNode(t, room, "room", addr)
+		defer room.Stop()
+	}
+
+	// the gate relays 9101 to the service picked, sticky by the key of route.
+	gate := engins.NewApp()
+	registerEcho(gate)
+	resolver := static.NewConfigBasedResolver()
+	resolver.Register("room", "127.0.0.1:17927")
+	resolver.Register("room", "127.0.0.1:17928")
+	gateCluster := cluster.NewClusterWithApp(gate, resolver)
+	gateCluster.AddRelayRoute(cluster.RelayRoute{
+		Match: cluster.MatchIDs(9101),
+		Pick: func(ctx *core.ChannelContext, msgID string, msg interface{}) (string, error) {
+			return "room", nil
+		},
+		Key: func(ctx *core.ChannelContext, msg interface{}) interface{} { return "room-7" },
+	})
+	gateCluster.BuildServer("gate", "127.0.0.1:17929", core.TCPServBuilder, cluster.WithServerRelay(true))
+	gateCluster.BuildClient("room", "gate", cluster.WithBalancer(balancer.GetBuilder(stickiness.Name).Build(
+		stickiness.WithServName("room"), stickiness.WithResolver(resolver))))
+	if err := gate.Start(gateCluster); err != nil {
+		t.Fatal(err)
+	}
+	defer gate.Stop()
+	waitFor(t, "gate connected to rooms", func() bool { return len(gateCluster.Clients("room")) == 2 })
+
+	player := engins.NewApp()
+	registerEcho(player)
+	c := connectNode(t, player, "gate", "127.0.0.1:17929", "player")
+	defer player.Stop()
+
+	var picked string
+	for i := 0; i < 6; i++ {
+		resp, err := c.Call("gate", &echoRequest{Text: "hi"}, time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, ok := resp.(*echoResponse)
+		if !ok || (picked != "" && r.Text != picked) {
+			t.Fatalf("expect requests forwarded to the same room, found %+v after %s", resp, picked)
+		}
+		picked = r.Text
+	}
+	for i := 0; i < 6; i++ {
+		if err := c.Write("gate", &echoRequest{Text: "hi"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expectReceived(t, picked, received, picked, picked, picked, picked, picked, picked)
+}
+
+func TestClusterBroadcast(t *testing.T) {
+	c := cluster.NewClusterWithApp(engins.NewApp(), static.NewConfigBasedResolver())
+	sent, err := c.Multicast([]string{"game", "chat"}, &echoRequest{Text: "announcement"})
+	if sent != 0 {
+		t.Fatalf("expect sent to no nodes, found %d", sent)
+	}
+	if e, ok := err.(*errs.NoRoute); !ok || e.Service != "game" {
+		t.Fatalf("expect no route to game, found %v", err)
+	}
+
+	zone := cluster.MatchLabels(map[string]string{"zone": "eu"})
+	if !zone(&cluster.Node{Labels: map[string]string{"zone": "eu", "role": "cache"}}) {
+		t.Fatal("expect node in zone eu matched")
+	}
+	if zone(&cluster.Node{Labels: map[string]string{"zone": "us"}}) || zone(&cluster.Node{}) {
+		t.Fatal("expect nodes out of zone eu not matched")
+	}
+}
+
+// receiver returns the texts of echoRequest received by app.
+func receiver(app *engins.App) chan string {
+	received := make(chan string, 16)
+	app.RegisterProcessorByID(9101, func(ctx *core.ChannelContext, msg interface{}, args ...interface{}) {
+		received <- msg.(*echoRequest).Text
+	})
+	return received
+}
+
+// expectReceived checks the texts received, and nothing else.
+func expectReceived(t *testing.T, node string, received chan string, texts ...string) {
+	t.Helper()
+	for _, text := range texts {
+		select {
+		case r := <-received:
+			if r != text {
+				t.Fatalf("expect %s received %s, found %s", node, text, r)
+			}
+		case <-time.After(time.Second * 2):
+			t.Fatalf("expect %s received %s", node, text)
+		}
+	}
+	select {
+	case r := <-received:
+		t.Fatalf("expect %s received nothing else, found %s", node, r)
+	case <-time.After(time.Millisecond * 100):
+	}
+}
+
+func TestClusterBroadcastNodes(t *testing.T) {
+	world := engins.NewApp()
+	registerEcho(world)
+	w := serveNode(t, world, "world", "127.0.0.1:17915")
+	defer world.Stop()
+
+	nodes := make(map[string]chan string)
+	for _, node := range []struct{ name, service, zone string }{{"eu", "game", "eu"}, {"us", "game", "us"}, {"chat", "chat", "eu"}} {
+		app := engins.NewApp()
+		registerEcho(app)
+		nodes[node.name] = receiver(app)
+		connectNode(t, app, "world", "127.0.0.1:17915", node.service, cluster.WithLabels(map[string]string{"zone": node.zone}))
+		defer app.Stop()
+	}
+	waitFor(t, "nodes identified", func() bool {
+		topology := w.Topology()
+		return len(topology["game"]) == 2 && len(topology["chat"]) == 1
+	})
+
+	if sent, err := w.Broadcast("game", &echoRequest{Text: "game"}); sent != 2 || err != nil {
+		t.Fatalf("expect broadcast to 2 nodes, found %d %v", sent, err)
+	}
+	if sent, err := w.BroadcastFilter([]string{"game", "chat"}, &echoRequest{Text: "eu"},
+		cluster.MatchLabels(map[string]string{"zone": "eu"})); sent != 2 || err != nil {
+		t.Fatalf("expect broadcast to 2 nodes in eu, found %d %v", sent, err)
+	}
+	if sent, err := w.Multicast([]string{"game", "chat"}, &echoRequest{Text: "all"}); sent != 3 || err != nil {
+		t.Fatalf("expect multicast to 3 nodes, found %d %v", sent, err)
+	}
+
+	expectReceived(t, "eu", nodes["eu"], "game", "eu", "all")
+	expectReceived(t, "us", nodes["us"], "game", "all")
+	expectReceived(t, "chat", nodes["chat"], "eu", "all")
+}
+
+func TestClusterTopology(t *testing.T) {
+	app := engins.NewApp()
+	c := cluster.NewClusterWithApp(app, static.NewConfigBasedResolver())
+	c.OnMembershipChange(func(e cluster.MembershipEvent) {
+		t.Errorf("unexpected membership event %v of %s", e.Type, e.Service)
+	})
+	if topology := c.Topology(); len(topology) != 0 {
+		t.Fatalf("expect empty topology, found %v", topology)
+	}
+
+	if cluster.ServiceUp.String() != "ServiceUp" || cluster.NodeLeft.String() != "NodeLeft" {
+		t.Fatalf("bad event type names %s %s", cluster.ServiceUp, cluster.NodeLeft)
+	}
+
+	result, err := monitor.RegistryOf(app).ExecuteFields(context.Background(), []string{"cluster", "game"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(result.String(), "game (0)") {
+		t.Fatalf("expect game with no nodes, found %q", result.String())
+	}
+}
+
+func TestClusterIdentity(t *testing.T) {
+	if cluster.NodeID == "" {
+		t.Fatal("expect default node id")
+	}
+
+	opts := &cluster.ConfigOpts{}
+	for _, o := range []cluster.BuildOption{
+		cluster.WithNodeID("game-1"),
+		cluster.WithZone("eu-west-1a", "eu-west-1"),
+		cluster.WithWeight(200),
+		cluster.WithAdvertiseAddr("10.0.0.1:9000"),
+	} {
+		o(opts)
+	}
+	if opts.NodeID != "game-1" || opts.Zone != "eu-west-1a" || opts.Region != "eu-west-1" ||
+		opts.Weight != 200 || opts.AdvertiseAddr != "10.0.0.1:9000" {
+		t.Fatalf("bad identity options %+v", opts)
+	}
+}
+
+func TestClusterIdentityHandshake(t *testing.T) {
+	world := engins.NewApp()
+	w := serveNode(t, world, "world", "127.0.0.1:17916", cluster.WithNodeID("world-1"),
+		cluster.WithZone("eu-west-1a", "eu-west-1"), cluster.WithWeight(200),
+		cluster.WithLabels(map[string]string{"role": "hub"}))
+	defer world.Stop()
+
+	// the game node serves on unspecified hosts and identifies itself with the first server.
+	game := engins.NewApp()
+	resolver := static.NewConfigBasedResolver()
+	resolver.Register("world", "127.0.0.1:17916")
+	g := cluster.NewClusterWithApp(game, resolver)
+	g.BuildServer("game", ":17917", core.TCPServBuilder)
+	g.BuildServer("game-admin", ":17918", core.TCPServBuilder)
+	b := balancer.GetBuilder(stickiness.Name).Build(stickiness.WithServName("world"), stickiness.WithResolver(resolver))
+	g.BuildClient("world", "game", cluster.WithBalancer(b))
+	if err := game.Start(g); err != nil {
+		t.Fatal(err)
+	}
+	defer game.Stop()
+
+	waitFor(t, "game identified", func() bool { return len(w.Topology()["game"]) == 1 })
+	waitFor(t, "world identified", func() bool { return len(g.Topology()["world"]) == 1 })
+
+	m := w.Topology()["game"][0]
+	if m.ID != cluster.NodeID || m.Weight != cluster.DefaultWeight || m.Version != engins.Version || m.Build != cluster.Build {
+		t.Fatalf("expect game identified with defaults, found %+v", m)
+	}
+	if m.Addr != "127.0.0.1:17917" || m.Direction != "inbound" {
+		t.Fatalf("expect game reachable on its first server, found %+v", m)
+	}
+
+	m = g.Topology()["world"][0]
+	if m.ID != "world-1" || m.Zone != "eu-west-1a" || m.Region != "eu-west-1" || m.Weight != 200 ||
+		m.Labels["role"] != "hub" || m.Addr != "127.0.0.1:17916" || m.Direction != "outbound" {
+		t.Fatalf("expect world identified with its options, found %+v", m)
+	}
+}
+
+func TestClusterAuth(t *testing.T) {
+	identify := &cluster.IdentifySelf{Name: "gate", ID: "gate-1"}
+	auth := cluster.NewHMACAuth("secret")
+	proof, err := auth.Prove(identify, "nonce")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := auth.Verify(identify, "nonce", proof); err != nil {
+		t.Fatalf("expect proof verified, found %v", err)
+	}
+	if err := auth.Verify(identify, "another", proof); err == nil {
+		t.Fatal("expect proof of another nonce rejected")
+	}
+	if err := cluster.NewHMACAuth("guess").Verify(identify, "nonce", proof); err == nil {
+		t.Fatal("expect proof of another secret rejected")
+	}
+	if _, err := cluster.NewHMACAuth("").Prove(identify, "nonce"); err == nil {
+		t.Fatal("expect empty secret refused")
+	}
+
+	token := cluster.NewTokenAuth("token", nil)
+	if err := token.Verify(identify, "nonce", "token"); err != nil {
+		t.Fatalf("expect token verified, found %v", err)
+	}
+	if err := token.Verify(identify, "nonce", "forged"); err == nil {
+		t.Fatal("expect forged token rejected")
+	}
+}
+
+// notifier returns a channel and the function notifying it without blocking.
+func notifier() (chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+	return ch, func() {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// blockingAuth proves with the shared secret after released.
+type blockingAuth struct {
+	*cluster.HMACAuth
+	release chan struct{}
+}
+
+func (a *blockingAuth) Prove(identify *cluster.IdentifySelf, nonce string) (string, error) {
+	<-a.release
+	return a.HMACAuth.Prove(identify, nonce)
+}
+
+func TestClusterAuthHandshake(t *testing.T) {
+	world := engins.NewApp()
+	registerEcho(world)
+	received := receiver(world)
+	serveNode(t, world, "world", "127.0.0.1:17919", cluster.WithHMACAuth("secret"))
+	defer world.Stop()
+
+	// messages are dispatched once authenticated.
+	game := engins.NewApp()
+	registerEcho(game)
+	ready, onReady := notifier()
+	left, onLeft := notifier()
+	c := connectNode(t, game, "world", "127.0.0.1:17919", "game", cluster.WithHMACAuth("secret"), cluster.WithNodeID("game-1"),
+		cluster.WithOnConnect(func(*core.ChannelContext, core.Channel) { onReady() }),
+		cluster.WithOnDisConnect(func(*core.ChannelContext) { onLeft() }))
+	defer game.Stop()
+	select {
+	case <-ready:
+	case <-time.After(time.Second * 2):
+		t.Fatal("expect game authenticated")
+	}
+	if err := c.Write("world", &echoRequest{Text: "authenticated"}); err != nil {
+		t.Fatal(err)
+	}
+	expectReceived(t, "world", received, "authenticated")
+
+	// identified again as the one authenticated only.
+	if err := c.Write("world", &cluster.IdentifySelf{Name: "game", ID: "game-1", Labels: map[string]string{"room": "1"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Write("world", &echoRequest{Text: "identified again"}); err != nil {
+		t.Fatal(err)
+	}
+	expectReceived(t, "world", received, "identified again")
+	if err := c.Write("world", &cluster.IdentifySelf{Name: "chat", ID: "game-1"}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-left:
+	case <-time.After(time.Second * 2):
+		t.Fatal("expect game rejected identified again as chat")
+	}
+
+	// messages before authenticated close the connection.
+	chat := engins.NewApp()
+	registerEcho(chat)
+	auth := &blockingAuth{HMACAuth: cluster.NewHMACAuth("secret"), release: make(chan struct{})}
+	closed, onClosed := notifier()
+	c = connectNode(t, chat, "world", "127.0.0.1:17919", "chat", cluster.WithAuth(auth),
+		cluster.WithOnDisConnect(func(*core.ChannelContext) { onClosed() }))
+	defer chat.Stop()
+	if err := c.Write("world", &echoRequest{Text: "early"}); err != nil {
+		t.Fatal(err)
+	}
+	expectReceived(t, "world", received)
+	close(auth.release)
+	select {
+	case <-closed:
+	case <-time.After(time.Second * 2):
+		t.Fatal("expect chat rejected before authenticated")
+	}
+}
+
+func TestClusterAuthHandshakeTimeout(t *testing.T) {
+	timeout := cluster.HandshakeTimeout
+	cluster.HandshakeTimeout = time.Millisecond * 200
+	defer func() { cluster.HandshakeTimeout = timeout }()
+
+	// the authenticating server closes the connections not proving in time.
+	world := engins.NewApp()
+	serveNode(t, world, "world", "127.0.0.1:17920", cluster.WithHMACAuth("secret"))
+	defer world.Stop()
+	conn, err := net.Dial("tcp", "127.0.0.1:17920")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(time.Second * 2))
+	if _, err := io.Copy(ioutil.Discard, conn); err != nil {
+		t.Fatalf("expect connection closed by world, found %v", err)
+	}
+
+	// the client closes the connection to a server never challenging.
+	chat := engins.NewApp()
+	serveNode(t, chat, "chat", "127.0.0.1:17921")
+	defer chat.Stop()
+	gate := engins.NewApp()
+	ready, onReady := notifier()
+	closed, onClosed := notifier()
+	connectNode(t, gate, "chat", "127.0.0.1:17921", "gate", cluster.WithHMACAuth("secret"),
+		cluster.WithOnConnect(func(*core.ChannelContext, core.Channel) { onReady() }),
+		cluster.WithOnDisConnect(func(*core.ChannelContext) { onClosed() }))
+	defer gate.Stop()
+	select {
+	case <-closed:
+	case <-ready:
+		t.Fatal("expect gate not ready without challenged")
+	case <-time.After(time.Second * 2):
+		t.Fatal("expect gate closed the connection")
+	}
+}
+
+func TestClusterBackoff(t *testing.T) {
+	b := cluster.Backoff{Initial: time.Millisecond * 100, Max: time.Second, Multiplier: 2}
+	for attempt, expected := range map[int]time.Duration{
+		1:  time.Millisecond * 100,
+		2:  time.Millisecond * 200,
+		4:  time.Millisecond * 800,
+		5:  time.Second,
+		50: time.Second,
+	} {
+		if d := b.Delay(attempt); d != expected {
+			t.Fatalf("expect delay %v of attempt %d, found %v", expected, attempt, d)
+		}
+	}
+
+	b.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if d := b.Delay(3); d < time.Millisecond*200 || d > time.Millisecond*600 {
+			t.Fatalf("expect delay in 400ms±50%%, found %v", d)
+		}
+	}
+
+	if b := (cluster.Backoff{}).WithDefaults(); b.Jitter != cluster.DefaultBackoff.Jitter {
+		t.Fatalf("expect default jitter, found %v", b.Jitter)
+	}
+	b = cluster.Backoff{Jitter: cluster.NoJitter}.WithDefaults()
+	for i := 0; i < 100; i++ {
+		if d := b.Delay(2); d != cluster.DefaultBackoff.Initial*2 {
+			t.Fatalf("expect delay without jitter, found %v", d)
+		}
+	}
+
+	opts := &cluster.ConfigOpts{}
+	cluster.WithHeartbeat(time.Second, 2)(opts)
+	cluster.WithReconnect(cluster.Backoff{MaxAttempts: 5})(opts)
+	if opts.Heartbeat == nil || opts.Heartbeat.Interval != time.Second || opts.Heartbeat.Misses != 2 {
+		t.Fatalf("bad heartbeat option %+v", opts.Heartbeat)
+	}
+	if opts.Reconnect == nil || opts.Reconnect.MaxAttempts != 5 {
+		t.Fatalf("bad reconnect option %+v", opts.Reconnect)
+	}
+
+	c := cluster.NewClusterWithApp(engins.NewApp(), static.NewConfigBasedResolver())
+	if states := c.Reconnects(); len(states) != 0 {
+		t.Fatalf("expect no reconnecting, found %v", states)
+	}
+}
+
+// pausableExecutor runs the functions in place, or drops them if paused.
+type pausableExecutor struct {
+	paused int32
+}
+
+func (e *pausableExecutor) Execute(f func()) {
+	if atomic.LoadInt32(&e.paused) == 0 {
+		f()
+	}
+}
+
+// nextEvent returns the next event of typ, skipping the other ones.
+func nextEvent(t *testing.T, events chan cluster.MembershipEvent, typ cluster.MembershipEventType) cluster.MembershipEvent {
+	t.Helper()
+	timeout := time.After(time.Second * 3)
+	for {
+		select {
+		case e := <-events:
+			if e.Type == typ {
+				return e
+			}
+		case <-timeout:
+			t.Fatalf("expect event %v", typ)
+		}
+	}
+}
+
+// membershipEvents returns the chan receiving the membership events of c.
+func membershipEvents(c *cluster.Cluster) chan cluster.MembershipEvent {
+	events := make(chan cluster.MembershipEvent, 64)
+	c.OnMembershipChange(func(e cluster.MembershipEvent) {
+		select {
+		case events <- e:
+		default:
+		}
+	})
+	return events
+}
+
+func TestClusterHeartbeatEviction(t *testing.T) {
+	world := engins.NewApp()
+	resolver := static.NewConfigBasedResolver()
+	w := cluster.NewClusterWithApp(world, resolver)
+	w.BuildServer("world", "127.0.0.1:17924", core.TCPServBuilder, cluster.WithHeartbeat(time.Millisecond*50, 2))
+	events := membershipEvents(w)
+	if err := world.Start(w); err != nil {
+		t.Fatal(err)
+	}
+	defer world.Stop()
+
+	// the game node stops replying heartbeats once its executor paused.
+	game := engins.NewApp()
+	executor := &pausableExecutor{}
+	connectNode(t, game, "world", "127.0.0.1:17924", "game", cluster.WithExecutor(executor))
+	defer game.Stop()
+	waitFor(t, "game resolved", func() bool { return len(resolver.Resolve("game")) == 1 })
+	time.Sleep(time.Millisecond * 200)
+	if len(resolver.Resolve("game")) != 1 {
+		t.Fatal("expect game replying heartbeats kept")
+	}
+
+	atomic.StoreInt32(&executor.paused, 1)
+	e := nextEvent(t, events, cluster.NodeEvicted)
+	if e.Service != "game" || e.Member.Direction != "inbound" || e.Err == nil {
+		t.Fatalf("unexpected eviction %+v", e)
+	}
+	waitFor(t, "game unregistered", func() bool {
+		return len(resolver.Resolve("game")) == 0 && len(w.Topology()["game"]) == 0
+	})
+}
+
+func TestClusterReconnectEvents(t *testing.T) {
+	game := engins.NewApp()
+	serveNode(t, game, "game", "127.0.0.1:17925")
+
+	gate := engins.NewApp()
+	c := connectNode(t, gate, "game", "127.0.0.1:17925", "gate",
+		cluster.WithReconnect(cluster.Backoff{Initial: time.Millisecond * 50, Max: time.Millisecond * 200}))
+	defer gate.Stop()
+	events := membershipEvents(c)
+
+	game.Stop()
+	if e := nextEvent(t, events, cluster.Reconnecting); e.Attempt != 1 || e.Member.Addr != "127.0.0.1:17925" {
+		t.Fatalf("unexpected reconnecting %+v", e)
+	}
+	if e := nextEvent(t, events, cluster.ReconnectFailed); e.Attempt != 1 || e.Err == nil {
+		t.Fatalf("unexpected reconnect failure %+v", e)
+	}
+	if states := c.Reconnects(); len(states) != 1 || states[0].Service != "game" || states[0].Err == "" {
+		t.Fatalf("expect reconnecting to game, found %+v", states)
+	}
+
+	game = engins.NewApp()
+	serveNode(t, game, "game", "127.0.0.1:17925")
+	defer game.Stop()
+	if e := nextEvent(t, events, cluster.Reconnected); e.Attempt < 2 {
+		t.Fatalf("unexpected reconnected %+v", e)
+	}
+	waitFor(t, "reconnected to game", func() bool { return len(c.Clients("game")) > 0 && len(c.Reconnects()) == 0 })
+}
+
+func TestClusterDrain(t *testing.T) {
+	app := engins.NewApp()
+	c := cluster.NewClusterWithApp(app, static.NewConfigBasedResolver())
+	if err := app.Start(c); err != nil {
+		t.Fatal(err)
+	}
+
+	var sessions int32 = 3
+	c.SetSessionCounter(func() int {
+		return int(atomic.AddInt32(&sessions, -1) + 1)
+	})
+	if err := app.GracefulShutdown(time.Second * 3); err != nil {
+		t.Fatalf("expect drained, found %v", err)
+	}
+	if !c.Draining() || c.DrainDeadline().IsZero() || !app.ShuttingDown() {
+		t.Fatal("expect draining with deadline and shutting down")
+	}
+
+	c = cluster.NewClusterWithApp(engins.NewApp(), static.NewConfigBasedResolver())
+	c.SetSessionCounter(func() int { return 1 })
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+	if err := c.Drain(ctx); err == nil {
+		t.Fatal("expect drain stopped by deadline with sessions")
+	}
+
+	// the sessions unknown without counter are drained at the deadline.
+	c = cluster.NewClusterWithApp(engins.NewApp(), static.NewConfigBasedResolver())
+	ctx, cancel = context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+	start := time.Now()
+	if err := c.Drain(ctx); err != nil || time.Since(start) < time.Millisecond*100 {
+		t.Fatalf("expect drained at deadline, found %v after %v", err, time.Since(start))
+	}
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(time.Millisecond*100, cancel)
+	start = time.Now()
+	if err := c.Drain(ctx); err == nil || time.Since(start) < time.Millisecond*100 {
+		t.Fatalf("expect drain without deadline stopped by cancel, found %v after %v", err, time.Since(start))
+	}
+}
+
+func TestClusterDrainSticky(t *testing.T) {
+	world := engins.NewApp()
+	registerEcho(world)
+	w := serveNode(t, world, "world", "127.0.0.1:17926")
+	defer world.Stop()
+
+	nodes := make(map[string]*cluster.Cluster)
+	received := make(map[string]chan string)
+	for _, name := range []string{"game-1", "game-2"} {
+		app := engins.NewApp()
+		registerEcho(app)
+		received[name] = receiver(app)
+		nodes[name] = connectNode(t, app, "world", "127.0.0.1:17926", "game", cluster.WithNodeID(name))
+		defer app.Stop()
+	}
+	waitFor(t, "games identified", func() bool { return len(w.Topology()["game"]) == 2 })
+
+	// which receives the messages of key.
+	receiverOf := func(key string) string {
+		t.Helper()
+		if err := w.Write("game", &echoRequest{Text: key}, key); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case <-received["game-1"]:
+			return "game-1"
+		case <-received["game-2"]:
+			return "game-2"
+		case <-time.After(time.Second * 2):
+			t.Fatalf("expect %s received", key)
+		}
+		return ""
+	}
+
+	picked := receiverOf("user-1")
+	other := "game-1"
+	if picked == other {
+		other = "game-2"
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+	if err := nodes[picked].Drain(ctx); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, picked+" draining", func() bool {
+		for _, m := range w.Topology()["game"] {
+			if m.ID == picked {
+				return m.Draining
+			}
+		}
+		return false
+	})
+
+	if r := receiverOf("user-1"); r != picked {
+		t.Fatalf("expect user-1 kept on draining %s, found %s", picked, r)
+	}
+	for _, key := range []string{"user-2", "user-3", "user-4", "user-5"} {
+		if r := receiverOf(key); r != other {
+			t.Fatalf("expect new %s picked %s, found %s", key, other, r)
+		}
+	}
+}
+
+type enterRoom struct {
+	RoomID uint64
+	name   string
+}
+
+// parsedMessage is a message parsed with its id and payload not decoded.
+type parsedMessage struct {
+	id      interface{}
+	payload []byte
+}
+
+func (m *parsedMessage) ID() interface{} { return m.id }
+func (m *parsedMessage) Payload() []byte { return m.payload }
+
+func TestClusterRelayRoute(t *testing.T) {
+	match := cluster.MatchRange(1000, 1999)
+	for id, expected := range map[string]bool{"999": false, "1000": true, "1500": true, "1999": true, "2000": false, "abc": false} {
+		if match(id) != expected {
+			t.Fatalf("expect range match %s %v", id, expected)
+		}
+	}
+	match = cluster.MatchIDs(1, "login")
+	if !match("1") || !match("login") || match("2") {
+		t.Fatalf("bad ids match")
+	}
+
+	app := engins.NewApp()
+	app.RegisterMsgByID(9201, &enterRoom{})
+	c := cluster.NewClusterWithApp(app, static.NewConfigBasedResolver())
+
+	// the field is read from the message decoded by the type registered.
+	key := c.FieldKey("RoomID")
+	if k := key(nil, &cluster.RPCRequest{MsgID: "9201", Payload: []byte(`{"RoomID":7}`)}); k != uint64(7) {
+		t.Fatalf("expect key 7 of request forwarded, found %v", k)
+	}
+	if k := key(nil, &parsedMessage{id: 9201, payload: []byte(`{"RoomID":8}`)}); k != uint64(8) {
+		t.Fatalf("expect key 8 of message parsed, found %v", k)
+	}
+	if k := key(nil, enterRoom{RoomID: 9}); k != uint64(9) {
+		t.Fatalf("expect key 9 of message decoded, found %v", k)
+	}
+	if k := key(nil, &cluster.RPCRequest{MsgID: "9299", Payload: []byte(`{"RoomID":7}`)}); k != nil {
+		t.Fatalf("expect no key of message not registered, found %v", k)
+	}
+	if k := c.FieldKey("name")(nil, &enterRoom{name: "x"}); k != nil {
+		t.Fatalf("expect no key of unexported field, found %v", k)
+	}
+	if k := c.FieldKey("GuildID")(nil, &enterRoom{}); k != nil {
+		t.Fatalf("expect no key of missing field, found %v", k)
+	}
+
+	c.AddRelayRoute(cluster.RelayRoute{Match: match, Service: "room", Key: key})
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("expect panic of route without service")
+		}
+	}()
+	c.AddRelayRoute(cluster.RelayRoute{Match: match})
+}
+
+func TestClusterBalancers(t *testing.T) {
+	r := static.NewConfigBasedResolver()
+	for _, name := range []string{cluster.ConsistentHashName, cluster.WeightedRoundRobinName, cluster.LeastLoadName} {
+		builder := balancer.GetBuilder(name)
+		if builder == nil || builder.Name() != name {
+			t.Fatalf("expect balancer %s registered", name)
+		}
+		b := builder.Build(cluster.WithBalancerService("game"), cluster.WithBalancerResolver(r), cluster.WithHashReplicas(10))
+		if _, err := b.Pick(uint64(1)); err == nil {
+			t.Fatalf("expect balancer %s no route without nodes", name)
+		} else if _, ok := err.(*errs.NoRoute); !ok {
+			t.Fatalf("expect NoRoute, found %v", err)
+		}
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatalf("expect panic of balancer without resolver")
+			}
+		}()
+		balancer.GetBuilder(cluster.ConsistentHashName).Build(cluster.WithBalancerService("game"))
+	}()
+
+	opts := &cluster.ConfigOpts{}
+	cluster.WithServerBalancer(cluster.LeastLoadName)(opts)
+	if opts.ServerBalancer != cluster.LeastLoadName {
+		t.Fatalf("bad server balancer option %s", opts.ServerBalancer)
+	}
+}
+
+func TestClusterBalancersPick(t *testing.T) {
+	world := engins.NewApp()
+	resolver := static.NewConfigBasedResolver()
+	w := cluster.NewClusterWithApp(world, resolver)
+	w.BuildServer("world", "127.0.0.1:17930", core.TCPServBuilder)
+	if err := world.Start(w); err != nil {
+		t.Fatal(err)
+	}
+	defer world.Stop()
+
+	// the game nodes report loads by heartbeats, their load per weight are 0.5, 0.3 and 0.1.
+	join := func(id string, weight int, load int64) *engins.App {
+		app := engins.NewApp()
+		connectNode(t, app, "world", "127.0.0.1:17930", "game", cluster.WithNodeID(id), cluster.WithWeight(weight),
+			cluster.WithHeartbeat(time.Millisecond*20, 0)).SetLoadReporter(func() int64 { return load })
+		return app
+	}
+	apps := map[string]*engins.App{"game-1": join("game-1", 100, 50), "game-2": join("game-2", 300, 90), "game-3": join("game-3", 100, 10)}
+	defer func() {
+		for _, app := range apps {
+			app.Stop()
+		}
+	}()
+	waitFor(t, "games resolved", func() bool { return len(resolver.Resolve("game")) == 3 })
+
+	build := func(name string) balancer.Balancer {
+		return balancer.GetBuilder(name).Build(cluster.WithBalancerService("game"), cluster.WithBalancerResolver(resolver))
+	}
+	pick := func(b balancer.Balancer, key interface{}) string {
+		t.Helper()
+		node, err := b.Pick(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return node.Attr().Value(cluster.NodeKey).(*cluster.Node).ID
+	}
+
+	// the keys of consistent hash move only from the node left, and back once rejoined.
+	hash := build(cluster.ConsistentHashName)
+	picked := make(map[int]string)
+	for key := 0; key < 300; key++ {
+		picked[key] = pick(hash, key)
+	}
+	apps["game-3"].Stop()
+	waitFor(t, "game-3 left", func() bool { return len(resolver.Resolve("game")) == 2 })
+	for key, id := range picked {
+		if p := pick(hash, key); (id != "game-3" && p != id) || p == "game-3" {
+			t.Fatalf("expect key %d of %s kept or moved from game-3, found %s", key, id, p)
+		}
+	}
+	apps["game-3"] = join("game-3", 100, 10)
+	waitFor(t, "game-3 rejoined", func() bool { return len(resolver.Resolve("game")) == 3 })
+	for key, id := range picked {
+		if p := pick(hash, key); p != id {
+			t.Fatalf("expect key %d back to %s, found %s", key, id, p)
+		}
+	}
+
+	// weighted round-robin picks proportional to weights.
+	wrr := build(cluster.WeightedRoundRobinName)
+	counts := make(map[string]int)
+	for i := 0; i < 500; i++ {
+		counts[pick(wrr, nil)]++
+	}
+	if counts["game-1"] != 100 || counts["game-2"] != 300 || counts["game-3"] != 100 {
+		t.Fatalf("expect picks proportional to weights, found %v", counts)
+	}
+
+	// least load picks the node with the least load per weight reported.
+	least := build(cluster.LeastLoadName)
+	waitFor(t, "loads reported", func() bool { return pick(least, nil) == "game-3" })
+	for i := 0; i < 10; i++ {
+		if id := pick(least, nil); id != "game-3" {
+			t.Fatalf("expect least loaded game-3 picked, found %s", id)
+		}
+	}
+}
+
+// fakeRedis serves in memory the string commands of redis used by RedisStorage.
+type fakeRedis struct {
+	mutex   sync.Mutex
+	values  map[string]string
+	expires map[string]time.Time
+}
+
+// newFakeRedis serves a fakeRedis on loopback, and returns the address.
+func newFakeRedis(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	r := &fakeRedis{values: make(map[string]string), expires: make(map[string]time.Time)}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go r.serve(conn)
+		}
+	}()
+	return l.Addr().String()
+}
+
+func (r *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	rd := bufio.NewReader(conn)
+	for {
+		args, err := readRedisCommand(rd)
+		if err != nil {
+			return
+		}
+		if _, err := conn.Write([]byte(r.exec(args))); err != nil {
+			return
+		}
+	}
+}
+
+// readRedisCommand reads a command in the array of bulk strings.
+func readRedisCommand(rd *bufio.Reader) ([]string, error) {
+	line, err := rd.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, n)
+	for i := range args {
+		if line, err = rd.ReadString('\n'); err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(rd, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
+
+func (r *fakeRedis) exec(args []string) string {
+	if len(args) < 2 {
+		return "-ERR wrong number of arguments\r\n"
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	key := args[1]
+	if expire, ok := r.expires[key]; ok && time.Now().After(expire) {
+		delete(r.values, key)
+		delete(r.expires, key)
+	}
+	_, exist := r.values[key]
+	switch cmd := strings.ToLower(args[0]); cmd {
+	case "get":
+		if !exist {
+			return "$-1\r\n"
+		}
+		return fmt.Sprintf("$%d\r\n%s\r\n", len(r.values[key]), r.values[key])
+	case "set":
+		var ttl time.Duration
+		var nx bool
+		for i := 3; i < len(args); i++ {
+			switch strings.ToLower(args[i]) {
+			case "nx":
+				nx = true
+			case "ex", "px":
+				n, _ := strconv.Atoi(args[i+1])
+				ttl = time.Duration(n) * time.Second
+				if strings.ToLower(args[i]) == "px" {
+					ttl = time.Duration(n) * time.Millisecond
+				}
+				i++
+			}
+		}
+		if exist && nx {
+			return "$-1\r\n"
+		}
+		r.values[key] = args[2]
+		delete(r.expires, key)
+		if ttl > 0 {
+			r.expires[key] = time.Now().Add(ttl)
+		}
+		return "+OK\r\n"
+	case "expire", "pexpire":
+		if !exist {
+			return ":0\r\n"
+		}
+		n, _ := strconv.Atoi(args[2])
+		unit := time.Second
+		if cmd == "pexpire" {
+			unit = time.Millisecond
+		}
+		r.expires[key] = time.Now().Add(time.Duration(n) * unit)
+		return ":1\r\n"
+	case "pttl":
+		if !exist {
+			return ":-2\r\n"
+		}
+		expire, ok := r.expires[key]
+		if !ok {
+			return ":-1\r\n"
+		}
+		return fmt.Sprintf(":%d\r\n", time.Until(expire)/time.Millisecond)
+	case "del":
+		delete(r.values, key)
+		delete(r.expires, key)
+		if exist {
+			return ":1\r\n"
+		}
+		return ":0\r\n"
+	}
+	return "-ERR unknown command\r\n"
+}
+
+func TestClusterRedisStorageShared(t *testing.T) {
+	client := database.NewRedisClient(&database.RedisOption{Addr: newFakeRedis(t)}, nil)
+	storage := func() *cluster.RedisStorage {
+		return cluster.NewRedisStorage(client, "game", static.NewConfigBasedResolver(), time.Minute)
+	}
+
+	// the node set first is agreed, even if not resolved by the others yet.
+	gate1, gate2 := storage(), storage()
+	gate1.Set(uint64(1), "game-1")
+	gate2.Set(uint64(1), "game-2")
+	if v1, v2, v := gate1.Get(uint64(1)), gate2.Get(uint64(1)), storage().Get(uint64(1)); v1 != "game-1" || v2 != "game-1" || v != "game-1" {
+		t.Fatalf("expect storages agreed on game-1, found %v %v %v", v1, v2, v)
+	}
+
+	// the node not refreshed by any storage is replaced.
+	gate2 = storage()
+	gate2.CacheTTL = time.Millisecond
+	time.Sleep(time.Millisecond * 10)
+	gate2.Set(uint64(1), "game-2")
+	waitFor(t, "game-2 stored", func() bool { return storage().Get(uint64(1)) == "game-2" })
+}
+
+func TestClusterRedisStorage(t *testing.T) {
+	// no redis listening, the keys set are kept in the local cache.
+	client := database.NewRedisClient(&database.RedisOption{Addr: "127.0.0.1:1"}, nil)
+	s := cluster.NewRedisStorage(client, "game", static.NewConfigBasedResolver(), time.Minute)
+	if v := s.Get(uint64(1)); v != nil {
+		t.Fatalf("expect no value, found %v", v)
+	}
+	s.Set(uint64(1), "10.0.0.1:7879")
+	if v := s.Get(uint64(1)); v != "10.0.0.1:7879" {
+		t.Fatalf("expect cached value, found %v", v)
+	}
+	s.Del(uint64(1))
+	if v := s.Get(uint64(1)); v != nil {
+		t.Fatalf("expect value deleted, found %v", v)
+	}
+
+	s.CacheTTL = time.Millisecond
+	s.Set(uint64(2), "10.0.0.2:7879")
+	time.Sleep(time.Millisecond * 5)
+	if v := s.Get(uint64(2)); v != nil {
+		t.Fatalf("expect cache expired, found %v", v)
+	}
+
+	opts := &cluster.ConfigOpts{}
+	cluster.WithRedisStorage(client, time.Minute)(opts)
+	if opts.Storage == nil {
+		t.Fatalf("expect storage builder set")
+	}
+	if _, ok := opts.Storage("game", static.NewConfigBasedResolver()).(*cluster.RedisStorage); !ok {
+		t.Fatalf("expect redis storage built")
+	}
+}
@@ -0,0 +1,280 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/amsalt/engins"
+	"github.com/amsalt/engins/monitor"
+	"github.com/amsalt/engins/monitor/events"
+	"github.com/amsalt/nginet/core"
+)
+
+func TestMonitorCommand(t *testing.T) {
+	kick := monitor.NewCommand("kick", "kick a player", func(ctx context.Context, args *monitor.Args) (*monitor.Result, error) {
+		if _, err := args.Int("delay", 0); err != nil {
+			return nil, err
+		}
+		return monitor.NewResult(args.Positional, "kicked "+args.Arg(0)+" "+args.Option("reason", "")), nil
+	}, monitor.WithUsage("player kick <id> [reason=text] [delay=seconds]"))
+	monitor.RegisterCommand(monitor.NewCommand("player", "manage players", nil,
+		monitor.WithGroup("game"), monitor.WithSubcommands(kick)))
+
+	result, err := monitor.Execute(context.Background(), `player kick 1001 reason="bad words" delay=5`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.String() != "kicked 1001 bad words" {
+		t.Fatalf("unexpected result: %s", result)
+	}
+
+	if _, err := monitor.Execute(context.Background(), "player kick 1001 delay=soon"); err == nil {
+		t.Fatal("expect error of bad option")
+	}
+	if _, err := monitor.Execute(context.Background(), "unknown"); err == nil {
+		t.Fatal("expect unknown command")
+	}
+
+	result, err = monitor.Execute(context.Background(), "help")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if help := result.String(); !strings.Contains(help, "game:\n    player") || strings.Index(help, "game:") > strings.Index(help, "general:") {
+		t.Fatalf("expect grouped and sorted help, found %s", help)
+	}
+
+	result, err = monitor.Execute(context.Background(), "help player kick")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(result.String(), "player kick <id>") {
+		t.Fatalf("expect usage of subcommand, found %s", result)
+	}
+}
+
+func TestMonitorBuiltinCommands(t *testing.T) {
+	engins.RegisterMsgByID(9001, &tcpChannel{})
+	engins.RegisterProcessorByID(9001, func(ctx *core.ChannelContext, msg interface{}, args ...interface{}) {})
+
+	for line, expected := range map[string]string{
+		"goroutines":                       "goroutines:",
+		"goroutines stack filter=testing.": "testing.tRunner",
+		"memstats":                         "HeapAlloc",
+		"gc run":                           "gc done",
+		"version":                          engins.Version,
+		"uptime":                           "up ",
+		"messages filter=tcpChannel":       "9001",
+	} {
+		result, err := monitor.Execute(context.Background(), line)
+		if err != nil {
+			t.Fatalf("execute %s: %v", line, err)
+		}
+		if !strings.Contains(result.String(), expected) {
+			t.Fatalf("expect result of %s contains %s, found %s", line, expected, result)
+		}
+	}
+
+	uptime := monitor.GetCommand("uptime")
+	monitor.UnregisterCommand("uptime")
+	defer monitor.RegisterCommand(uptime)
+	if _, err := monitor.Execute(context.Background(), "uptime"); err == nil {
+		t.Fatal("expect uptime disabled")
+	}
+}
+
+func TestMonitorMetricCompat(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expect builtin help metric registered")
+		}
+	}()
+	monitor.RegisterMetric(&monitor.HelpMetric{})
+}
+
+func TestMonitorAccess(t *testing.T) {
+	access := monitor.NewAccess(
+		monitor.Account{Name: "ops", Password: "secret", Roles: []string{monitor.RoleAdmin}},
+		monitor.Account{Name: "dashboard", Token: "t0ken", Roles: []string{monitor.RoleViewer}},
+	)
+	access.DefineRole("gc-operator", "gc *")
+
+	if _, err := access.Login("ops wrong"); err == nil {
+		t.Fatal("expect login failed with wrong password")
+	}
+	if _, err := access.Login("secret"); err == nil {
+		t.Fatal("expect password not accepted as token")
+	}
+	ops, err := access.Login("ops secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	viewer, err := access.Login("t0ken")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := access.Authorize(ops, "gc run"); err != nil {
+		t.Fatalf("expect admin allowed: %v", err)
+	}
+	if err := access.Authorize(viewer, "gc"); err != nil {
+		t.Fatalf("expect viewer allowed to show gc: %v", err)
+	}
+	if err, ok := access.Authorize(viewer, "gc run").(*monitor.PermissionDenied); !ok || err.Command != "gc run" {
+		t.Fatalf("expect viewer denied to run gc, found %v", err)
+	}
+
+	operator := &monitor.Account{Name: "operator", Roles: []string{"gc-operator"}}
+	if err := access.Authorize(operator, "gc run"); err != nil {
+		t.Fatalf("expect operator allowed to run gc: %v", err)
+	}
+	if err := access.Authorize(operator, "gc"); err == nil {
+		t.Fatal("expect operator denied to show gc")
+	}
+
+	// the commands are resolved against the registry running them.
+	app := monitor.NewRegistry(monitor.Default)
+	app.RegisterCommand(monitor.NewCommand("ban", "ban a player", func(ctx context.Context, args *monitor.Args) (*monitor.Result, error) {
+		return monitor.NewResult(nil, "banned"), nil
+	}))
+	if err, ok := access.AuthorizeIn(app, viewer, "ban 1001").(*monitor.PermissionDenied); !ok || err.Command != "ban" {
+		t.Fatalf("expect viewer denied to run ban of app, found %v", err)
+	}
+	if err := access.AuthorizeIn(app, viewer, "gc"); err != nil {
+		t.Fatalf("expect viewer allowed to show gc of parent: %v", err)
+	}
+	if err, ok := access.Authorize(viewer, "ban 1001").(*monitor.PermissionDenied); !ok || err.Command != "ban 1001" {
+		t.Fatalf("expect viewer denied to run unknown command, found %v", err)
+	}
+	if err := access.AuthorizeIn(app, ops, "ban 1001"); err != nil {
+		t.Fatalf("expect admin allowed to run ban of app: %v", err)
+	}
+}
+
+func TestMonitorHTTP(t *testing.T) {
+	var audits []monitor.AuditRecord
+	access := monitor.NewAccess(monitor.Account{Name: "dashboard", Token: "t0ken", Roles: []string{monitor.RoleViewer}})
+	m := monitor.NewMonitor("0", monitor.WithAccess(access), monitor.WithAuditor(func(r monitor.AuditRecord) {
+		audits = append(audits, r)
+	}))
+	server := httptest.NewServer(m.HTTPHandler())
+	defer server.Close()
+
+	do := func(method, path, token string, v interface{}) int {
+		req, _ := http.NewRequest(method, server.URL+path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if v != nil {
+			json.NewDecoder(resp.Body).Decode(v)
+		}
+		return resp.StatusCode
+	}
+	get := func(path, token string, v interface{}) int {
+		return do(http.MethodGet, path, token, v)
+	}
+	post := func(path, token string, v interface{}) int {
+		return do(http.MethodPost, path, token, v)
+	}
+
+	if status := get("/admin/commands", "", nil); status != http.StatusUnauthorized {
+		t.Fatalf("expect unauthorized, found %d", status)
+	}
+
+	var commands []struct{ Name string }
+	if status := get("/admin/commands", "t0ken", &commands); status != http.StatusOK || len(commands) == 0 {
+		t.Fatalf("expect commands, found %d %v", status, commands)
+	}
+
+	var result struct {
+		Command string
+		Data    int
+		Error   string
+	}
+	if status := post("/admin/run/goroutines?filter=x", "t0ken", &result); status != http.StatusOK || result.Data <= 0 {
+		t.Fatalf("expect goroutines, found %d %+v", status, result)
+	}
+	if result.Command != "goroutines filter=x" {
+		t.Fatalf("unexpected command: %s", result.Command)
+	}
+	if status := post("/admin/run/gc/run", "t0ken", &result); status != http.StatusForbidden {
+		t.Fatalf("expect forbidden, found %d %+v", status, result)
+	}
+	if status := post("/admin/run/unknown", "t0ken", nil); status != http.StatusNotFound {
+		t.Fatalf("expect not found, found %d", status)
+	}
+	if status := get("/admin/run/goroutines", "t0ken", nil); status != http.StatusMethodNotAllowed {
+		t.Fatalf("expect method not allowed, found %d", status)
+	}
+
+	var help struct{ Text string }
+	if status := get("/admin/help/gc/run", "t0ken", &help); status != http.StatusOK || !strings.Contains(help.Text, "gc run") {
+		t.Fatalf("expect usage of gc run, found %d %+v", status, help)
+	}
+
+	if len(audits) != 3 || audits[0].User != "dashboard" || audits[1].Err == nil {
+		t.Fatalf("unexpected audits: %+v", audits)
+	}
+}
+
+func TestMonitorHTTPLoopback(t *testing.T) {
+	commands := func(addr string) int {
+		resp, err := http.Get("http://" + addr + monitor.HTTPPrefix + "commands")
+		if err != nil {
+			return 0
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// the API without access is served on loopback only.
+	public := monitor.NewMonitor("17931", monitor.WithBindAddr("127.0.0.1"), monitor.WithHTTP(":17932"))
+	public.Init()
+	public.Start()
+	defer public.Stop()
+	local := monitor.NewMonitor("17933", monitor.WithBindAddr("127.0.0.1"), monitor.WithHTTP("127.0.0.1:17934"))
+	local.Init()
+	local.Start()
+	defer local.Stop()
+
+	waitFor(t, "http admin API on loopback", func() bool { return commands("127.0.0.1:17934") == http.StatusOK })
+	if status := commands("127.0.0.1:17932"); status != 0 {
+		t.Fatalf("expect http admin API not served on all interfaces without access, found %d", status)
+	}
+}
+
+func TestMonitorEvents(t *testing.T) {
+	feed := events.NewFeed()
+	feed.Publish("test", "nobody listening")
+
+	sub := feed.Subscribe(1)
+	feed.Publish("test", "event %d", 1)
+	feed.Publish("test", "event %d", 2)
+	feed.Publish("test", "event %d", 3)
+
+	e := <-sub.Events()
+	if e.Source != "test" || e.Text != "event 1" {
+		t.Fatalf("unexpected event: %+v", e)
+	}
+	if dropped := sub.Dropped(); dropped != 2 {
+		t.Fatalf("expect 2 events dropped, found %d", dropped)
+	}
+
+	sub.Close()
+	feed.Publish("test", "after closed")
+	if _, ok := <-sub.Events(); ok {
+		t.Fatal("expect events closed")
+	}
+
+	if _, err := monitor.Execute(context.Background(), "watch 1s version"); err == nil {
+		t.Fatal("expect watch unsupported without console")
+	}
+}
@@ -0,0 +1,324 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/amsalt/engins/components"
+	"github.com/amsalt/engins/errs"
+)
+
+var lifecycle []string
+
+type namedComponent struct {
+	name     string
+	requires []string
+}
+
+func (n *namedComponent) Name() string       { return n.name }
+func (n *namedComponent) Requires() []string { return n.requires }
+func (n *namedComponent) Init()              { lifecycle = append(lifecycle, "init "+n.name) }
+func (n *namedComponent) Start()             { lifecycle = append(lifecycle, "start "+n.name) }
+func (n *namedComponent) Stop()              { lifecycle = append(lifecycle, "stop "+n.name) }
+
+type failingComponent struct {
+	namedComponent
+	err error
+}
+
+func (f *failingComponent) InitContext(ctx context.Context) error {
+	f.Init()
+	return nil
+}
+
+func (f *failingComponent) StartContext(ctx context.Context, ready func()) error {
+	f.Start()
+	if f.err != nil {
+		return f.err
+	}
+	ready()
+	<-ctx.Done()
+	return nil
+}
+
+func (f *failingComponent) StopContext(ctx context.Context) error {
+	f.Stop()
+	return nil
+}
+
+func TestComponentsOrder(t *testing.T) {
+	lifecycle = nil
+	m := components.NewManager()
+	m.Register(
+		&namedComponent{name: "test-monitor", requires: []string{"test-cluster"}},
+		&namedComponent{name: "test-cluster"},
+		&namedComponent{name: "test-database"},
+	)
+	m.Require("test-cluster", "test-database")
+
+	order, err := m.Order()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"test-database", "test-cluster", "test-monitor"}; !reflect.DeepEqual(order, expected) {
+		t.Fatalf("expect order %v, found %v", expected, order)
+	}
+
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"init test-database", "init test-cluster", "init test-monitor",
+		"start test-database", "start test-cluster", "start test-monitor",
+		"stop test-monitor", "stop test-cluster", "stop test-database",
+	}
+	if !reflect.DeepEqual(lifecycle, expected) {
+		t.Fatalf("expect lifecycle %v, found %v", expected, lifecycle)
+	}
+}
+
+func TestComponentsCycle(t *testing.T) {
+	m := components.NewManager()
+	m.Register(
+		&namedComponent{name: "test-a", requires: []string{"test-b"}},
+		&namedComponent{name: "test-b", requires: []string{"test-c"}},
+		&namedComponent{name: "test-c", requires: []string{"test-a"}},
+	)
+
+	_, err := m.Order()
+	e, ok := err.(*errs.DependencyCycle)
+	if !ok {
+		t.Fatalf("expect dependency cycle, found %v", err)
+	}
+	if expected := []string{"test-a", "test-b", "test-c", "test-a"}; !reflect.DeepEqual(e.Path, expected) {
+		t.Fatalf("expect cycle %v, found %v", expected, e.Path)
+	}
+	if err := m.Run(); err == nil {
+		t.Fatal("expect run fails with dependency cycle")
+	}
+}
+
+func TestComponentsRollback(t *testing.T) {
+	lifecycle = nil
+	failure := errors.New("bind failed")
+	m := components.NewManager()
+	m.Register(
+		&namedComponent{name: "test-database"},
+		&failingComponent{namedComponent: namedComponent{name: "test-listener", requires: []string{"test-cluster"}}, err: failure},
+		&namedComponent{name: "test-cluster", requires: []string{"test-database"}},
+	)
+
+	err := m.Run()
+	if e, ok := err.(*errs.ComponentFailure); !ok || e.Name != "test-listener" || e.Err != failure {
+		t.Fatalf("expect start failure of test-listener, found %v", err)
+	}
+
+	expected := []string{
+		"init test-database", "init test-cluster", "init test-listener",
+		"start test-database", "start test-cluster", "start test-listener",
+		"stop test-cluster", "stop test-database",
+	}
+	if !reflect.DeepEqual(lifecycle, expected) {
+		t.Fatalf("expect lifecycle %v, found %v", expected, lifecycle)
+	}
+}
+
+type flakyComponent struct {
+	namedComponent
+	failures int
+	policy   components.RestartPolicy
+}
+
+func (f *flakyComponent) RestartPolicy() components.RestartPolicy { return f.policy }
+func (f *flakyComponent) InitContext(ctx context.Context) error   { return nil }
+func (f *flakyComponent) StopContext(ctx context.Context) error   { return nil }
+func (f *flakyComponent) StartContext(ctx context.Context, ready func()) error {
+	ready()
+	if f.failures != 0 {
+		f.failures--
+		return errors.New("connection lost")
+	}
+	<-ctx.Done()
+	return nil
+}
+
+func TestComponentsSupervisor(t *testing.T) {
+	flaky := &flakyComponent{
+		namedComponent: namedComponent{name: "test-flaky"},
+		failures:       2,
+		policy:         components.RestartPolicy{Mode: components.RestartOnFailure, Backoff: time.Millisecond},
+	}
+	critical := &flakyComponent{
+		namedComponent: namedComponent{name: "test-critical", requires: []string{"test-flaky"}},
+		failures:       -1,
+		policy:         components.RestartPolicy{Mode: components.RestartOnFailure, MaxRestarts: 2, Window: time.Minute, Critical: true},
+	}
+	m := components.NewManager()
+	m.Register(flaky, critical)
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Stop()
+
+	select {
+	case err := <-m.Fatal():
+		if e, ok := err.(*errs.ComponentFailure); !ok || e.Name != "test-critical" {
+			t.Fatalf("expect failure of test-critical, found %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expect critical component escalates")
+	}
+
+	// wait for test-flaky to recover.
+	deadline := time.Now().Add(time.Second)
+	for {
+		var flakyStatus, criticalStatus components.Status
+		for _, s := range m.Statuses() {
+			switch s.Name {
+			case "test-flaky":
+				flakyStatus = s
+			case "test-critical":
+				criticalStatus = s
+			}
+		}
+		if criticalStatus.State != components.StateFailed || criticalStatus.Restarts != 2 {
+			t.Fatalf("expect test-critical failed after 2 restarts, found %+v", criticalStatus)
+		}
+		if flakyStatus.State == components.StateRunning && flakyStatus.Restarts == 2 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expect test-flaky running after 2 restarts, found %+v", flakyStatus)
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}
+
+type blockingComponent struct {
+	namedComponent
+	stopDelay time.Duration
+	stop      chan struct{}
+}
+
+func (b *blockingComponent) Start() { <-b.stop }
+func (b *blockingComponent) Stop() {
+	time.Sleep(b.stopDelay)
+	close(b.stop)
+}
+
+func TestComponentsLegacyBlocking(t *testing.T) {
+	startTimeout, stopTimeout := components.StartTimeout, components.StopTimeout
+	components.StartTimeout, components.StopTimeout = time.Millisecond*200, time.Millisecond*500
+	defer func() { components.StartTimeout, components.StopTimeout = startTimeout, stopTimeout }()
+
+	// each blocks longer than StartTimeout, and both stop in 4/5 of StopTimeout.
+	first := &blockingComponent{namedComponent: namedComponent{name: "test-first"}, stopDelay: time.Millisecond * 200, stop: make(chan struct{})}
+	second := &blockingComponent{namedComponent: namedComponent{name: "test-second", requires: []string{"test-first"}}, stopDelay: time.Millisecond * 200, stop: make(chan struct{})}
+	m := components.NewManager()
+	m.Register(first, second)
+	if err := m.Run(); err != nil {
+		t.Fatalf("expect legacy blocking components started, found %v", err)
+	}
+	time.Sleep(components.StartTimeout)
+	for _, s := range m.Statuses() {
+		if s.State != components.StateRunning {
+			t.Fatalf("expect %s running, found %s", s.Name, s.State)
+		}
+	}
+
+	if err := m.Stop(); err != nil {
+		t.Fatalf("expect every component stopped in time, found %v", err)
+	}
+	for _, s := range m.Statuses() {
+		if s.State != components.StateStopped {
+			t.Fatalf("expect %s stopped, found %s", s.Name, s.State)
+		}
+	}
+}
+
+func TestComponentsStopDeadline(t *testing.T) {
+	stopTimeout := components.StopTimeout
+	components.StopTimeout = time.Millisecond * 200
+	defer func() { components.StopTimeout = stopTimeout }()
+
+	// each stops in 3/4 of StopTimeout, so only the last started stops in time.
+	m := components.NewManager()
+	var names []string
+	for i := 1; i <= 3; i++ {
+		name := fmt.Sprintf("test-hung-%d", i)
+		names = append(names, name)
+		m.Register(&blockingComponent{namedComponent: namedComponent{name: name}, stopDelay: time.Millisecond * 150, stop: make(chan struct{})})
+	}
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	begin := time.Now()
+	err := m.Stop()
+	if elapsed := time.Since(begin); elapsed > components.StopTimeout*3/2 {
+		t.Fatalf("expect stopped in one StopTimeout, took %v", elapsed)
+	}
+	timeout, ok := err.(*errs.StopTimeout)
+	if !ok {
+		t.Fatalf("expect stop timeout, found %v", err)
+	}
+	if len(timeout.Hung) != 2 || timeout.Hung[0] != names[1] || timeout.Hung[1] != names[0] {
+		t.Fatalf("expect %s and %s hung, found %v", names[1], names[0], timeout.Hung)
+	}
+}
+
+type panickyComponent struct {
+	namedComponent
+	panics int32
+}
+
+func (p *panickyComponent) RestartPolicy() components.RestartPolicy {
+	return components.RestartPolicy{Mode: components.RestartOnFailure, Backoff: time.Millisecond}
+}
+
+func (p *panickyComponent) Start() {
+	if atomic.AddInt32(&p.panics, -1) >= 0 {
+		time.Sleep(time.Millisecond * 20)
+		panic("listener lost")
+	}
+}
+
+func TestComponentsLegacySupervisor(t *testing.T) {
+	grace := components.LegacyStartGrace
+	components.LegacyStartGrace = 0
+	defer func() { components.LegacyStartGrace = grace }()
+
+	panicky := &panickyComponent{namedComponent: namedComponent{name: "test-panicky"}}
+	m := components.NewManager()
+	m.Register(panicky)
+	// Start panics twice after LegacyStartGrace.
+	atomic.StoreInt32(&panicky.panics, 2)
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Stop()
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		s := m.Statuses()[0]
+		if s.State == components.StateRunning && s.Restarts == 2 {
+			if s.LastError == nil {
+				t.Fatalf("expect last error of panic recorded")
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expect test-panicky running after 2 restarts, found %+v", s)
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}
@@ -0,0 +1,92 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/amsalt/engins"
+	"github.com/amsalt/engins/components"
+	"github.com/amsalt/engins/database"
+	"github.com/amsalt/engins/health"
+)
+
+type checkedComponent struct {
+	namedComponent
+	err error
+}
+
+func (c *checkedComponent) CheckHealth(ctx context.Context) error {
+	return c.err
+}
+
+func TestHealthAggregator(t *testing.T) {
+	m := components.NewManager()
+	cluster := &checkedComponent{namedComponent: namedComponent{name: "test-cluster"}}
+	m.Register(cluster)
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Stop()
+
+	a := health.NewAggregator(m)
+	a.Timeout = time.Millisecond * 50
+	a.Register("test-redis", health.CheckFunc(func(ctx context.Context) error {
+		<-ctx.Done()
+		return nil
+	}))
+
+	report := a.Check()
+	if report.Healthy || len(report.Results) != 2 {
+		t.Fatalf("expect unhealthy with 2 results, found %+v", report)
+	}
+	if r := report.Results[1]; r.Name != "test-redis" || r.Healthy || r.Error != context.DeadlineExceeded.Error() {
+		t.Fatalf("expect test-redis timeout, found %+v", r)
+	}
+
+	a.Register("test-redis", health.CheckFunc(func(ctx context.Context) error { return nil }))
+	if report := a.Check(); !report.Healthy {
+		t.Fatalf("expect healthy, found %+v", report)
+	}
+
+	cluster.err = errors.New("no connected node of services: game")
+	w := httptest.NewRecorder()
+	health.ReadinessHandler(a).ServeHTTP(w, httptest.NewRequest("GET", "/health/ready", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expect cached healthy report within interval, found %d", w.Code)
+	}
+	a.Check()
+	w = httptest.NewRecorder()
+	health.ReadinessHandler(a).ServeHTTP(w, httptest.NewRequest("GET", "/health/ready", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expect status 503, found %d: %s", w.Code, w.Body)
+	}
+
+	w = httptest.NewRecorder()
+	health.LivenessHandler(a).ServeHTTP(w, httptest.NewRequest("GET", "/health/live", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expect alive, found %d: %s", w.Code, w.Body)
+	}
+}
+
+func TestAppDatabaseHealth(t *testing.T) {
+	app := engins.NewApp()
+	if app.Health() == health.Default || engins.Default().Health() != health.Default {
+		t.Fatal("expect health checks of every App")
+	}
+
+	// no redis listening.
+	app.SetRedis(database.NewRedisClient(&database.RedisOption{Addr: "127.0.0.1:1"}, nil))
+	report := app.Health().Check()
+	if report.Healthy || len(report.Results) != 1 || report.Results[0].Name != engins.RedisCheck {
+		t.Fatalf("expect redis check failed, found %+v", report)
+	}
+
+	app.SetRedis(nil)
+	if report = app.Health().Check(); !report.Healthy || len(report.Results) != 0 {
+		t.Fatalf("expect redis check unregistered, found %+v", report)
+	}
+}
@@ -0,0 +1,144 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/amsalt/engins/components"
+	"github.com/amsalt/engins/logging"
+	"github.com/amsalt/engins/monitor"
+)
+
+func TestLoggingControl(t *testing.T) {
+	c := logging.NewController()
+	var min logging.Level
+	c.OnLevelChange(func(level logging.Level) { min = level })
+
+	var buf bytes.Buffer
+	c.Redirect(&buf, 0)
+	game := c.Logger("game")
+	game.Debugf("hidden")
+
+	c.SetPackageLevel("game", logging.LevelDebug, 0)
+	game.Debugf("package debug")
+	c.Logger("gate").Debugf("hidden")
+	if min != logging.LevelDebug {
+		t.Fatalf("expect min level debug, found %v", min)
+	}
+
+	c.SetLevel(logging.LevelError, time.Millisecond*50)
+	c.Logger("gate").Infof("hidden")
+	c.Trace(logging.UserKey(1001), time.Millisecond*50)
+	c.Logger("gate").With(logging.UserKey(1001)).Debugf("traced debug")
+	c.Logger("gate").With(logging.UserKey(1002)).Debugf("hidden")
+
+	time.Sleep(time.Millisecond * 150)
+	if level := c.MinLevel(); level != logging.LevelDebug {
+		t.Fatalf("expect min level debug of package game, found %v", level)
+	}
+	s := c.Settings()
+	if s.Level != "info" || len(s.Traces) != 0 || len(s.Reverts) != 0 {
+		t.Fatalf("expect settings reverted, found %+v", s)
+	}
+
+	out := buf.String()
+	if strings.Contains(out, "hidden") || !strings.Contains(out, "[game] package debug") ||
+		!strings.Contains(out, "traced debug [user:1001]") {
+		t.Fatalf("unexpected logs:\n%s", out)
+	}
+}
+
+func TestLoggingSyncLevel(t *testing.T) {
+	var synced []logging.Level
+	var mutex sync.Mutex
+	prev := logging.SyncLevel
+	logging.SyncLevel = func(min logging.Level) {
+		mutex.Lock()
+		synced = append(synced, min)
+		mutex.Unlock()
+	}
+	defer func() { logging.SyncLevel = prev }()
+	last := func() logging.Level {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return synced[len(synced)-1]
+	}
+
+	// the level of github.com/amsalt/log follows the console, and its revert.
+	if _, err := monitor.Execute(context.Background(), "log level debug for=50ms"); err != nil {
+		t.Fatal(err)
+	}
+	if level := last(); level != logging.LevelDebug {
+		t.Fatalf("expect debug level synced, found %v", level)
+	}
+	time.Sleep(time.Millisecond * 150)
+	if level := last(); level != logging.LevelInfo {
+		t.Fatalf("expect info level synced after reverted, found %v", level)
+	}
+}
+
+func TestLoggingRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	f, err := logging.OpenRotatingFile(path, 64, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := logging.NewController()
+	c.Redirect(f, 0)
+	for i := 0; i < 10; i++ {
+		c.Logger("game").Infof("line %d", i)
+	}
+	c.Redirect(nil, 0)
+
+	if _, err := f.Write([]byte("closed")); err == nil {
+		t.Fatal("expect file closed when replaced")
+	}
+	for _, name := range []string{path, path + ".1", path + ".2"} {
+		if _, err := ioutil.ReadFile(name); err != nil {
+			t.Fatalf("expect rotated file %s: %v", name, err)
+		}
+	}
+	if _, err := ioutil.ReadFile(path + ".3"); err == nil {
+		t.Fatal("expect at most 2 backups")
+	}
+}
+
+func TestLoggingConsole(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "engins.log")
+	run := func(line string) {
+		if _, err := monitor.Execute(context.Background(), line); err != nil {
+			t.Fatalf("execute %s: %v", line, err)
+		}
+	}
+	start := func(name string) {
+		m := components.NewManager()
+		m.Register(&namedComponent{name: name})
+		if err := m.Run(); err != nil {
+			t.Fatal(err)
+		}
+		m.Stop()
+	}
+
+	run("log file " + path + " for=0")
+	defer run("log file off")
+	start("test-info")
+	run("log level debug for=0")
+	start("test-debug")
+	run("log level info for=0")
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(content), "start component test-info") ||
+		!strings.Contains(string(content), "[components] start component test-debug") {
+		t.Fatalf("expect debug logs of components after `log level debug`, found:\n%s", content)
+	}
+}
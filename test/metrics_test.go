@@ -0,0 +1,77 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/amsalt/engins/monitor"
+	"github.com/amsalt/engins/monitor/metrics"
+)
+
+func TestMetrics(t *testing.T) {
+	r := metrics.NewRegistry()
+	requests := r.Counter("test_requests_total", "Handled requests.", "code")
+	requests.With("200").Inc()
+	requests.With("200").Add(2)
+	requests.With("500").Inc()
+	if v := requests.With("200").Value(); v != 3 {
+		t.Fatalf("expect counter 3, found %v", v)
+	}
+	if r.Counter("test_requests_total", "Handled requests.", "code").With("500").Value() != 1 {
+		t.Fatal("expect the registered counter")
+	}
+
+	queue := r.Gauge("test_queue_depth", "Pending jobs.")
+	queue.With().Set(5)
+	queue.With().Dec()
+	if v := queue.With().Value(); v != 4 {
+		t.Fatalf("expect gauge 4, found %v", v)
+	}
+
+	latency := r.Histogram("test_latency_seconds", "Request latency.", []float64{0.1, 1})
+	latency.With().Observe(0.05)
+	latency.With().Observe(0.5)
+	latency.With().Observe(3)
+
+	var buf bytes.Buffer
+	if err := r.WritePrometheus(&buf); err != nil {
+		t.Fatal(err)
+	}
+	text := buf.String()
+	for _, expected := range []string{
+		"# TYPE test_requests_total counter",
+		`test_requests_total{code="200"} 3`,
+		`test_requests_total{code="500"} 1`,
+		"test_queue_depth 4",
+		"# TYPE test_latency_seconds histogram",
+		`test_latency_seconds_bucket{le="0.1"} 1`,
+		`test_latency_seconds_bucket{le="1"} 2`,
+		`test_latency_seconds_bucket{le="+Inf"} 3`,
+		"test_latency_seconds_sum 3.55",
+		"test_latency_seconds_count 3",
+	} {
+		if !strings.Contains(text, expected) {
+			t.Fatalf("expect %s in exposition, found\n%s", expected, text)
+		}
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("expect panic of kind mismatch")
+			}
+		}()
+		r.Gauge("test_requests_total", "Handled requests.", "code")
+	}()
+
+	metrics.NewCounter("test_monitor_total", "Counted by monitor.").With().Inc()
+	result, err := monitor.Execute(context.Background(), "metrics filter=test_monitor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(result.String(), "test_monitor_total (counter)") {
+		t.Fatalf("unexpected metrics result: %s", result)
+	}
+}
@@ -0,0 +1,132 @@
+//go:build !windows
+
+package test
+
+import (
+	"context"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/amsalt/engins"
+)
+
+type reloadableComponent struct {
+	namedComponent
+	started  chan struct{}
+	reloaded chan struct{}
+}
+
+func (r *reloadableComponent) Start() {
+	close(r.started)
+}
+
+func (r *reloadableComponent) Reload() error {
+	close(r.reloaded)
+	return nil
+}
+
+type drainableComponent struct {
+	reloadableComponent
+	drained chan struct{}
+}
+
+func (d *drainableComponent) Drain(ctx context.Context) error {
+	close(d.drained)
+	return nil
+}
+
+func TestAppSignals(t *testing.T) {
+	app := engins.NewApp()
+	c := &reloadableComponent{namedComponent: namedComponent{name: "test-config"},
+		started: make(chan struct{}), reloaded: make(chan struct{})}
+
+	dumped := make(chan string, 1)
+	app.HandleSignal(syscall.SIGUSR1, func(app *engins.App, sig os.Signal) {
+		path, err := app.Dump(t.TempDir())
+		if err != nil {
+			t.Error(err)
+		}
+		dumped <- path
+		app.Shutdown()
+	})
+
+	result := make(chan error, 1)
+	go func() {
+		result <- app.Run(c)
+	}()
+	// signals are notified to the App before starting components.
+	select {
+	case <-c.started:
+	case <-time.After(time.Second):
+		t.Fatal("expect component started")
+	}
+
+	syscall.Kill(os.Getpid(), syscall.SIGHUP)
+	select {
+	case <-c.reloaded:
+	case <-time.After(time.Second):
+		t.Fatal("expect component reloaded by SIGHUP")
+	}
+
+	syscall.Kill(os.Getpid(), syscall.SIGUSR1)
+	select {
+	case err := <-result:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expect app shutdown by custom handler")
+	}
+
+	content, err := os.ReadFile(<-dumped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(content), "test-config") || !strings.Contains(string(content), "goroutine") {
+		t.Fatalf("expect dump contains components and goroutines, found %s", content)
+	}
+}
+
+func TestAppSignalDrain(t *testing.T) {
+	app := engins.NewApp()
+	c := &drainableComponent{
+		reloadableComponent: reloadableComponent{namedComponent: namedComponent{name: "test-drain"}, started: make(chan struct{})},
+		drained:             make(chan struct{}),
+	}
+
+	result := make(chan error, 1)
+	go func() {
+		result <- app.Run(c)
+	}()
+	select {
+	case <-c.started:
+	case <-time.After(time.Second):
+		t.Fatal("expect component started")
+	}
+
+	// SIGUSR2 drains only, the App keeps running.
+	syscall.Kill(os.Getpid(), syscall.SIGUSR2)
+	select {
+	case <-c.drained:
+	case <-time.After(time.Second):
+		t.Fatal("expect component drained by SIGUSR2")
+	}
+	select {
+	case err := <-result:
+		t.Fatalf("expect app running after drained, found stopped: %v", err)
+	case <-time.After(time.Millisecond * 200):
+	}
+
+	app.Shutdown()
+	select {
+	case err := <-result:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expect app shutdown")
+	}
+}
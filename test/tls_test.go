@@ -0,0 +1,267 @@
+package test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/amsalt/engins"
+	"github.com/amsalt/engins/cluster"
+	"github.com/amsalt/ngicluster/resolver/static"
+	"github.com/amsalt/nginet/core"
+)
+
+// testCA issues certificates for tests.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	file string
+}
+
+func newTestCA(t *testing.T, dir string, name string) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	ca := &testCA{cert: cert, key: key, file: filepath.Join(dir, name+".pem")}
+	writePEM(t, ca.file, "CERTIFICATE", der)
+	return ca
+}
+
+// issue issues a certificate named name, returns the files of certificate and key.
+func (ca *testCA) issue(t *testing.T, dir string, name string, usage x509.ExtKeyUsage) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile := filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key")
+	writePEM(t, certFile, "CERTIFICATE", der)
+	writePEM(t, keyFile, "EC PRIVATE KEY", keyDER)
+	return certFile, keyFile
+}
+
+func writePEM(t *testing.T, file string, typ string, der []byte) {
+	if err := ioutil.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// connectTLS starts app with a Cluster connecting to servName on addr by TLS as name.
+func connectTLS(t *testing.T, app *engins.App, servName string, addr string, name string, opt ...cluster.BuildOption) (*cluster.Cluster, error) {
+	t.Helper()
+	c := cluster.NewClusterWithApp(app, static.NewConfigBasedResolver())
+	c.BuildClient(servName, name, opt...)
+	if err := app.Start(c); err != nil {
+		t.Fatal(err)
+	}
+	return c, c.ConnectTLS(servName, addr)
+}
+
+// copyFiles copies the files to the ones they map to.
+func copyFiles(t *testing.T, files map[string]string) {
+	for from, to := range files {
+		content, err := ioutil.ReadFile(from)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(to, content, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestClusterTLSServer(t *testing.T) {
+	dir := t.TempDir()
+	ca, gameCA, rogue := newTestCA(t, dir, "ca"), newTestCA(t, dir, "game-ca"), newTestCA(t, dir, "rogue")
+	serverCert, serverKey := ca.issue(t, dir, "world", x509.ExtKeyUsageServerAuth)
+	gateCert, gateKey := ca.issue(t, dir, "gate", x509.ExtKeyUsageClientAuth)
+	gameCert, gameKey := gameCA.issue(t, dir, "game", x509.ExtKeyUsageClientAuth)
+	rogueCert, rogueKey := rogue.issue(t, dir, "rogue-gate", x509.ExtKeyUsageClientAuth)
+
+	world := engins.NewApp()
+	w := serveNode(t, world, "world", "127.0.0.1:17922", cluster.WithTLS(serverCert, serverKey),
+		cluster.WithMutualTLS(ca.file, map[string]string{"game": gameCA.file}))
+	defer world.Stop()
+
+	config := func(root *testCA, certFile, keyFile string) *tls.Config {
+		pool := x509.NewCertPool()
+		pool.AddCert(root.cert)
+		cfg := &tls.Config{RootCAs: pool}
+		if certFile != "" {
+			cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cfg.Certificates = []tls.Certificate{cert}
+		}
+		return cfg
+	}
+	// accepted returns whether the connection is kept, the server sends nothing
+	// until the client identifies itself.
+	accepted := func(cfg *tls.Config) bool {
+		conn, err := tls.Dial("tcp", "127.0.0.1:17922", cfg)
+		if err != nil {
+			return false
+		}
+		defer conn.Close()
+		conn.SetReadDeadline(time.Now().Add(time.Millisecond * 500))
+		_, err = conn.Read(make([]byte, 1))
+		e, ok := err.(net.Error)
+		return ok && e.Timeout()
+	}
+
+	if !accepted(config(ca, gateCert, gateKey)) {
+		t.Fatal("expect client certificate issued by CA accepted")
+	}
+	if accepted(config(ca, "", "")) {
+		t.Fatal("expect client without certificate rejected")
+	}
+	if accepted(config(ca, rogueCert, rogueKey)) {
+		t.Fatal("expect untrusted client rejected")
+	}
+
+	// a certificate issued by the CA of game identifies only as game.
+	game := engins.NewApp()
+	defer game.Stop()
+	if _, err := connectTLS(t, game, "world", "127.0.0.1:17922", "game",
+		cluster.WithTLS(gameCert, gameKey), cluster.WithMutualTLS(ca.file, nil)); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "game identified", func() bool { return len(w.Topology()["game"]) == 1 })
+
+	chat := engins.NewApp()
+	defer chat.Stop()
+	closed, onClosed := notifier()
+	if _, err := connectTLS(t, chat, "world", "127.0.0.1:17922", "chat",
+		cluster.WithTLS(gameCert, gameKey), cluster.WithMutualTLS(ca.file, nil),
+		cluster.WithOnDisConnect(func(*core.ChannelContext) { onClosed() })); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-closed:
+	case <-time.After(time.Second * 2):
+		t.Fatal("expect certificate of game identifying as chat rejected")
+	}
+	if len(w.Topology()["chat"]) != 0 {
+		t.Fatal("expect chat not joined")
+	}
+
+	// the certificate of server reissued by another CA is presented after reloaded.
+	another := newTestCA(t, dir, "another")
+	reissuedCert, reissuedKey := another.issue(t, dir, "world-reissued", x509.ExtKeyUsageServerAuth)
+	copyFiles(t, map[string]string{reissuedCert: serverCert, reissuedKey: serverKey})
+	if err := w.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if !accepted(config(another, gateCert, gateKey)) || accepted(config(ca, gateCert, gateKey)) {
+		t.Fatal("expect certificate reissued presented")
+	}
+}
+
+func TestClusterTLSClient(t *testing.T) {
+	dir := t.TempDir()
+	ca, gameCA, rogue := newTestCA(t, dir, "ca"), newTestCA(t, dir, "game-ca"), newTestCA(t, dir, "rogue")
+	serverCert, serverKey := ca.issue(t, dir, "world", x509.ExtKeyUsageServerAuth)
+	gateCert, gateKey := ca.issue(t, dir, "gate", x509.ExtKeyUsageClientAuth)
+	rogueCert, rogueKey := rogue.issue(t, dir, "game-rogue", x509.ExtKeyUsageClientAuth)
+	gameCert, gameKey := gameCA.issue(t, dir, "game", x509.ExtKeyUsageClientAuth)
+
+	world := engins.NewApp()
+	w := serveNode(t, world, "world", "127.0.0.1:17937", cluster.WithTLS(serverCert, serverKey),
+		cluster.WithMutualTLS(ca.file, map[string]string{"game": gameCA.file}))
+	defer world.Stop()
+
+	// the server is verified by the CA of its service rather than the CA.
+	spoofed := engins.NewApp()
+	defer spoofed.Stop()
+	if _, err := connectTLS(t, spoofed, "world", "127.0.0.1:17937", "gate",
+		cluster.WithTLS(gateCert, gateKey), cluster.WithMutualTLS(ca.file, map[string]string{"world": rogue.file})); err == nil {
+		t.Fatal("expect server not verified by the CA of service rejected")
+	}
+
+	peers := make(chan *cluster.Peer, 1)
+	gate := engins.NewApp()
+	defer gate.Stop()
+	var g *cluster.Cluster
+	g, err := connectTLS(t, gate, "world", "127.0.0.1:17937", "gate",
+		cluster.WithTLS(gateCert, gateKey), cluster.WithMutualTLS(ca.file, nil),
+		cluster.WithOnConnect(func(ctx *core.ChannelContext, channel core.Channel) { peers <- g.PeerOf(ctx) }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case peer := <-peers:
+		if peer == nil || peer.CommonName != "world" || peer.Service != "world" {
+			t.Fatalf("expect peer of server world, found %+v", peer)
+		}
+	case <-time.After(time.Second * 2):
+		t.Fatal("expect gate connected")
+	}
+	waitFor(t, "gate identified", func() bool { return len(w.Topology()["gate"]) == 1 })
+
+	// the certificate of client is presented after reloaded.
+	clientCert, clientKey := filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key")
+	copyFiles(t, map[string]string{rogueCert: clientCert, rogueKey: clientKey})
+	game := engins.NewApp()
+	defer game.Stop()
+	closed, onClosed := notifier()
+	c, err := connectTLS(t, game, "world", "127.0.0.1:17937", "game",
+		cluster.WithTLS(clientCert, clientKey), cluster.WithMutualTLS(ca.file, nil),
+		cluster.WithOnDisConnect(func(*core.ChannelContext) { onClosed() }))
+	if err == nil {
+		select {
+		case <-closed:
+		case <-time.After(time.Second * 2):
+			t.Fatal("expect untrusted client rejected")
+		}
+	}
+	copyFiles(t, map[string]string{gameCert: clientCert, gameKey: clientKey})
+	if err := c.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.ConnectTLS("world", "127.0.0.1:17937"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "game identified", func() bool { return len(w.Topology()["game"]) == 1 })
+}